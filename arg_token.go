@@ -8,15 +8,8 @@ import (
 )
 
 var (
-	constantNumberPattern = "(-?[0-9]*|-?0x[0-9a-fA-F]*)"
-	symbolNamePattern     = "([a-zA-Z0-9_]*)"
-)
-
-var (
-	constantRegexp        = regexp.MustCompile("^" + constantNumberPattern + "$")
-	symbolRegexp          = regexp.MustCompile("^" + symbolNamePattern + "$")
-	memoryRegexp          = regexp.MustCompile("^(" + constantNumberPattern + "|)\\(.+\\)$")
-	memorySubfieldsRegexp = regexp.MustCompile("^(.*)\\((.*)\\)$")
+	symbolNamePattern = "([a-zA-Z0-9_.]*)"
+	symbolRegexp      = regexp.MustCompile("^" + symbolNamePattern + "$")
 )
 
 var registerNames = map[string]int{
@@ -52,6 +45,32 @@ type MemoryReference struct {
 	Offset   int16
 }
 
+// An AddressHalf refers to the high or low 16 bits of a symbol's address, written as
+// "%hi(symbol)" or "%lo(symbol)".
+//
+// The high half is rounded up when bit 15 of the address is set, since the low half is treated
+// as a signed offset. A LUI of the high half followed by an ADDIU, load, or store with the low
+// half therefore produces the full address.
+type AddressHalf struct {
+	Symbol string
+	High   bool
+}
+
+// Value computes the half of an address which the reference refers to.
+func (a AddressHalf) Value(addr uint32) uint16 {
+	if a.High {
+		return uint16((addr + 0x8000) >> 16)
+	}
+	return uint16(addr)
+}
+
+func (a AddressHalf) String() string {
+	if a.High {
+		return "%hi(" + a.Symbol + ")"
+	}
+	return "%lo(" + a.Symbol + ")"
+}
+
 // An ArgToken represents a register, a number, a symbol, or a memory location.
 // For instance, the instruction "SB $5, 5($6)" contains two tokens.
 //
@@ -70,21 +89,30 @@ type ArgToken struct {
 	isMemory    bool
 	memRegister int
 	memOffset   int16
+
+	// isHalf is set if the constant or memory offset is an AddressHalf.
+	isHalf bool
+	half   AddressHalf
+
+	// literal is the source text of the constant or memory offset, like "0x10" or "'a'".
+	// It is empty for tokens which were not read from source code.
+	literal string
 }
 
 // ParseArgToken parses a human-readable token string.
-// The string must not have any leading or trailing whitespace.
+// The string may use any spacing the assembler accepts, as in "4 ( $sp )".
 func ParseArgToken(tokenStr string) (token *ArgToken, err error) {
-	if regToken, err := parseRegisterArgToken(tokenStr); err == nil {
-		return regToken, nil
-	} else if constantRegexp.MatchString(tokenStr) {
-		return parseConstantArgToken(tokenStr)
-	} else if symbolRegexp.MatchString(tokenStr) {
-		return parseSymbolArgToken(tokenStr)
-	} else if memoryRegexp.MatchString(tokenStr) {
-		return parseMemoryArgToken(tokenStr)
+	tokens, syntaxErr := scanLine(tokenStr)
+	if syntaxErr == nil {
+		if len(tokens) == 0 || tokens[len(tokens)-1].Kind == CommentToken {
+			return nil, errors.New("unable to parse token: " + tokenStr)
+		}
+		token, syntaxErr = parseOperand(tokens)
+	}
+	if syntaxErr != nil {
+		return nil, errors.New(syntaxErr.Message)
 	}
-	return nil, errors.New("unable to parse token: " + tokenStr)
+	return token, nil
 }
 
 // Register returns the register index represented by this token.
@@ -110,7 +138,13 @@ func (t *ArgToken) SignedConstant16() (constant int16, ok bool) {
 // Constant5 returns the 5-bit unsigned constant represented by this token.
 // If this token cannot be treated as a 5-bit constant, ok will be false.
 func (t *ArgToken) Constant5() (constant uint8, ok bool) {
-	return uint8(t.constant), t.isConstant && t.constant < 0x20
+	return uint8(t.constant), t.isConstant && !t.isHalf && t.constant < 0x20
+}
+
+// Constant32 returns the 32-bit constant represented by this token.
+// If this token cannot be treated as a 32-bit constant, ok will be false.
+func (t *ArgToken) Constant32() (constant uint32, ok bool) {
+	return t.constant, t.isConstant && !t.isHalf
 }
 
 // RelativeCodePointer returns the relative code pointer represented by this token.
@@ -119,7 +153,9 @@ func (t *ArgToken) Constant5() (constant uint8, ok bool) {
 // If the code pointer is a constant, the constant value will represent an 18-bit signed address
 // which is signed extended to 32 bits.
 func (t *ArgToken) RelativeCodePointer() (ptr CodePointer, ok bool) {
-	if t.isConstant {
+	if t.isHalf {
+		return
+	} else if t.isConstant {
 		constant := int16(t.constant >> 2)
 		if uint32(constant)<<2 != t.constant&0xfffffffc {
 			return
@@ -138,7 +174,9 @@ func (t *ArgToken) RelativeCodePointer() (ptr CodePointer, ok bool) {
 // If the code pointer is a constant, it will be an absolute jump destination.
 // The destination address should include the high bits of the intended PC+4 value.
 func (t *ArgToken) AbsoluteCodePointer() (ptr CodePointer, ok bool) {
-	if t.isConstant {
+	if t.isHalf {
+		return
+	} else if t.isConstant {
 		return CodePointer{Absolute: true, Constant: uint32(t.constant)}, true
 	} else if t.isSymbol {
 		return CodePointer{Absolute: true, IsSymbol: true, Symbol: t.symbol}, true
@@ -153,50 +191,151 @@ func (t *ArgToken) MemoryReference() (ref MemoryReference, ok bool) {
 	return MemoryReference{Register: t.memRegister, Offset: t.memOffset}, t.isMemory
 }
 
-func parseRegisterArgToken(tokenStr string) (token *ArgToken, err error) {
-	regNum, err := parseRegister(tokenStr)
+// AddressHalf returns the "%hi(symbol)" or "%lo(symbol)" reference represented by this token,
+// which may be the offset of a memory reference.
+// If this token contains no such reference, ok will be false.
+//
+// The constant or memory offset of a token with a reference is 0 until the symbol is resolved.
+func (t *ArgToken) AddressHalf() (half AddressHalf, ok bool) {
+	return t.half, t.isHalf
+}
+
+// parseOperand parses the tokens of a single instruction operand.
+func parseOperand(tokens []Token) (*ArgToken, *SyntaxError) {
+	first := tokens[0]
+	if first.Text == "%" {
+		return parseAddressHalfOperand(tokens)
+	}
+	if len(tokens) == 1 {
+		switch first.Kind {
+		case RegisterToken:
+			regNum, err := parseRegister(first.Text)
+			if err != nil {
+				return nil, syntaxError(first.Span.Column, err.Error())
+			}
+			return &ArgToken{isRegister: true, register: regNum}, nil
+		case IdentifierToken:
+			return &ArgToken{isSymbol: true, symbol: first.Text}, nil
+		}
+	}
+
+	for i, tok := range tokens {
+		if tok.Text == "(" {
+			return parseMemoryOperand(tokens[:i], tokens[i:])
+		}
+	}
+	if first.Kind == RegisterToken || first.Kind == IdentifierToken {
+		return nil, syntaxError(tokens[1].Span.Column, "unexpected "+
+			strconv.Quote(tokens[1].Text)+" (missing comma?)")
+	}
+
+	constant, n, err := parseConstantTokens(tokens)
 	if err != nil {
 		return nil, err
-	} else {
-		return &ArgToken{isRegister: true, register: regNum}, nil
+	} else if n < len(tokens) {
+		return nil, syntaxError(tokens[n].Span.Column, "unexpected "+strconv.Quote(tokens[n].Text)+
+			" (missing comma?)")
 	}
+	return &ArgToken{isConstant: true, constant: constant, literal: tokensText(tokens)}, nil
 }
 
-func parseConstantArgToken(tokenStr string) (token *ArgToken, err error) {
-	num, err := parseConstant(tokenStr)
+// parseAddressHalfOperand parses an operand like "%hi(symbol)" or "%lo(symbol)($t0)".
+func parseAddressHalfOperand(tokens []Token) (*ArgToken, *SyntaxError) {
+	if len(tokens) < 5 || (tokens[1].Text != "hi" && tokens[1].Text != "lo") ||
+		tokens[2].Text != "(" || tokens[3].Kind != IdentifierToken || tokens[4].Text != ")" {
+		return nil, syntaxError(tokens[0].Span.Column, "expected %hi(symbol) or %lo(symbol)")
+	}
+	half := AddressHalf{Symbol: tokens[3].Text, High: tokens[1].Text == "hi"}
+	if len(tokens) == 5 {
+		return &ArgToken{isConstant: true, isHalf: true, half: half}, nil
+	} else if tokens[5].Text != "(" {
+		return nil, syntaxError(tokens[5].Span.Column, "unexpected "+strconv.Quote(tokens[5].Text)+
+			" (missing comma?)")
+	}
+	res, err := parseMemoryOperand(nil, tokens[5:])
 	if err != nil {
 		return nil, err
 	}
-	return &ArgToken{isConstant: true, constant: num}, nil
-}
-
-func parseSymbolArgToken(tokenStr string) (token *ArgToken, err error) {
-	return &ArgToken{isSymbol: true, symbol: tokenStr}, nil
+	res.isHalf = true
+	res.half = half
+	return res, nil
 }
 
-func parseMemoryArgToken(tokenStr string) (token *ArgToken, err error) {
-	pieces := memorySubfieldsRegexp.FindStringSubmatch(tokenStr)
-	if pieces == nil {
-		return nil, errors.New("invalid memory reference: " + tokenStr)
+// parseMemoryOperand parses an operand like "-4($sp)" from its (possibly empty) offset tokens
+// and the tokens starting at the opening parenthesis.
+func parseMemoryOperand(offsetTokens, regTokens []Token) (*ArgToken, *SyntaxError) {
+	if len(regTokens) < 3 || regTokens[1].Kind != RegisterToken || regTokens[2].Text != ")" {
+		return nil, syntaxError(regTokens[0].Span.Column, "invalid memory reference")
+	} else if len(regTokens) > 3 {
+		return nil, syntaxError(regTokens[3].Span.Column, "unexpected "+
+			strconv.Quote(regTokens[3].Text)+" after memory reference")
 	}
-
-	reg, err := parseRegister(pieces[2])
+	reg, err := parseRegister(regTokens[1].Text)
 	if err != nil {
-		return
+		return nil, syntaxError(regTokens[1].Span.Column, err.Error())
 	}
 
 	var offset int16
-	if len(pieces[1]) != 0 {
-		if offNum, err := parseConstant(pieces[1]); err != nil {
+	if len(offsetTokens) > 0 {
+		offNum, n, err := parseConstantTokens(offsetTokens)
+		if err != nil {
 			return nil, err
+		} else if n < len(offsetTokens) {
+			return nil, syntaxError(offsetTokens[n].Span.Column, "invalid memory offset")
 		} else if (offNum&0xffff8000) != 0xffff8000 && (offNum&0xffff8000) != 0 {
-			return nil, errors.New("memory offset out of bounds: " + pieces[1])
-		} else {
-			offset = int16(offNum)
+			return nil, syntaxError(offsetTokens[0].Span.Column, "memory offset out of bounds")
 		}
+		offset = int16(offNum)
 	}
 
-	return &ArgToken{isMemory: true, memOffset: offset, memRegister: reg}, nil
+	return &ArgToken{
+		isMemory:    true,
+		memOffset:   offset,
+		memRegister: reg,
+		literal:     tokensText(offsetTokens),
+	}, nil
+}
+
+// tokensText joins the text of tokens which are written without spaces between them.
+func tokensText(tokens []Token) string {
+	var res string
+	for _, tok := range tokens {
+		res += tok.Text
+	}
+	return res
+}
+
+// parseConstantTokens parses an optionally signed number or character literal.
+// It returns the number of tokens which were used.
+func parseConstantTokens(tokens []Token) (constant uint32, used int, err *SyntaxError) {
+	sign := ""
+	if tokens[0].Text == "-" || tokens[0].Text == "+" {
+		sign = tokens[0].Text
+		used++
+	}
+	if used == len(tokens) {
+		return 0, 0, syntaxError(tokens[0].Span.Column, "missing number after "+sign)
+	}
+	tok := tokens[used]
+	used++
+	switch tok.Kind {
+	case NumberToken:
+		value, parseErr := parseConstant(sign + tok.Text)
+		if parseErr != nil {
+			return 0, 0, syntaxError(tok.Span.Column, "invalid number: "+tok.Text)
+		}
+		return value, used, nil
+	case CharToken:
+		value, err := unquoteChar(&tok)
+		if err != nil {
+			return 0, 0, err
+		}
+		if sign == "-" {
+			value = -value
+		}
+		return value, used, nil
+	}
+	return 0, 0, syntaxError(tok.Span.Column, "unable to parse token: "+tok.Text)
 }
 
 func parseRegister(tokenStr string) (regIndex int, err error) {
@@ -217,7 +356,7 @@ func parseConstant(tokenStr string) (constant uint32, err error) {
 		return 0, err
 	}
 	if resNum > 0xffffffff || resNum < -0xffffffff {
-		return 0, err
+		return 0, errors.New("constant out of bounds: " + tokenStr)
 	}
 	return uint32(resNum), nil
 }
@@ -269,14 +269,16 @@ func TestParseArgToken(t *testing.T) {
 		}
 	}
 
-	validTokens := []string{"$r0", "$r31", "$r15", "0x7fff($r1)", "-0x8000($r1)"}
+	validTokens := []string{"$r0", "$r31", "$r15", "0x7fff($r1)", "-0x8000($r1)", "4 ( $sp )",
+		"'A'", "'\\0'", "1b"}
 	for _, tok := range validTokens {
 		if _, err := ParseArgToken(tok); err != nil {
 			t.Error("failed to parse "+tok+":", err)
 		}
 	}
 
-	badTokens := []string{"Monkey Brain", "$r32", "$r-1", "$32", "0x8000($r1)", "-0x8001($r1)"}
+	badTokens := []string{"Monkey Brain", "$r32", "$r-1", "$32", "0x8000($r1)", "-0x8001($r1)",
+		"0x100000000", "'AB'", "4($sp", "", "-"}
 	for _, tok := range badTokens {
 		if _, err := ParseArgToken(tok); err == nil {
 			t.Error("parsed invalid token:", tok)
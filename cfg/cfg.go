@@ -0,0 +1,331 @@
+// Package cfg builds control-flow graphs of MIPS programs.
+//
+// A graph is made of basic blocks: runs of instructions which always execute from start to end.
+// A block which ends in a branch or jump includes the instruction in its delay slot, since that
+// instruction runs before the branch or jump takes effect.
+package cfg
+
+import (
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/unixpickle/mips32"
+)
+
+// An EdgeKind describes how control passes from one block to another.
+type EdgeKind int
+
+const (
+	// FallthroughEdge goes to the instruction after the end of a block. This includes the
+	// return from a call to the instruction after its delay slot.
+	FallthroughEdge EdgeKind = iota
+
+	// BranchEdge goes to the target of a conditional branch when it is taken.
+	BranchEdge
+
+	// JumpEdge goes to the target of a J instruction, of a JR whose target is known, or of a
+	// branch which is always taken.
+	JumpEdge
+
+	// CallEdge goes from a JAL or JALR to the function it calls.
+	CallEdge
+)
+
+var edgeKindNames = []string{"fallthrough", "branch", "jump", "call"}
+
+func (e EdgeKind) String() string {
+	if int(e) < len(edgeKindNames) {
+		return edgeKindNames[e]
+	}
+	return "EdgeKind(" + strconv.Itoa(int(e)) + ")"
+}
+
+// An Edge connects two blocks.
+type Edge struct {
+	From *Block
+	To   *Block
+	Kind EdgeKind
+}
+
+// A Block is a basic block.
+type Block struct {
+	// Start is the address of the first instruction.
+	Start uint32
+
+	// Instructions lists the instructions of the block in order, including the delay slot of
+	// the branch or jump at the end, if there is one.
+	Instructions []*mips32.Instruction
+
+	Succs []*Edge
+	Preds []*Edge
+
+	// Function is the first function which reaches this block. Code shared by several
+	// functions belongs to the one with the lowest entry address.
+	Function *Function
+
+	// Returns is set if the block ends with "JR $ra".
+	Returns bool
+
+	// IndirectJump is set if the block ends with a JR to any other register, whose target is
+	// unknown.
+	IndirectJump bool
+
+	// IndirectCall is set if the block ends with a JALR whose callee is unknown.
+	IndirectCall bool
+}
+
+// End returns the address after the last instruction of the block.
+func (b *Block) End() uint32 {
+	return b.Start + uint32(len(b.Instructions)*4)
+}
+
+// Terminator returns the branch or jump at the end of the block, or nil if the block falls
+// through into the next one.
+func (b *Block) Terminator() *mips32.Instruction {
+	if len(b.Instructions) >= 2 {
+		if inst := b.Instructions[len(b.Instructions)-2]; inst.HasDelaySlot() {
+			return inst
+		}
+	}
+	return nil
+}
+
+// Callees returns the blocks which this block calls.
+func (b *Block) Callees() []*Block {
+	var res []*Block
+	for _, edge := range b.Succs {
+		if edge.Kind == CallEdge {
+			res = append(res, edge.To)
+		}
+	}
+	return res
+}
+
+// A Function is a group of blocks which are reached from an entry block without following
+// calls.
+type Function struct {
+	// Name is a symbol at the entry of the function, or a name like "func_00400120" made
+	// from its address.
+	Name string
+
+	Entry *Block
+
+	// Blocks lists every block which is reached from the entry, in order of address.
+	// A block may be part of more than one function.
+	Blocks []*Block
+}
+
+// A Graph is the control-flow graph of an executable.
+type Graph struct {
+	// Blocks lists every block in order of address.
+	Blocks []*Block
+
+	// Functions lists every function in order of address.
+	Functions []*Function
+
+	executable *mips32.Executable
+	blocks     map[uint32]*Block
+}
+
+// Build finds the blocks and functions of an executable.
+//
+// Functions start at the entry point, at the targets of calls, and at symbols which begin code
+// that is not reached from any other function, such as interrupt handlers. Symbols starting
+// with "." are never treated as functions.
+//
+// The blocks are made from the executable's ControlFlow, so only code reached from a function
+// is included. The targets of JR and JALR instructions are followed when they are loaded as
+// constants; a JR to any other target leaves its function.
+func Build(e *mips32.Executable) *Graph {
+	b := &builder{
+		exc:     e,
+		flow:    e.ControlFlow(symbolStarts(e)...),
+		leaders: map[uint32]bool{},
+		graph:   &Graph{executable: e, blocks: map[uint32]*Block{}},
+	}
+	b.findLeaders()
+	b.readBlocks()
+	b.connectBlocks()
+	b.groupFunctions()
+	return b.graph
+}
+
+// symbolStarts lists the addresses of the symbols which may start functions, in ascending
+// order.
+func symbolStarts(e *mips32.Executable) []uint32 {
+	var res []uint32
+	for name, addr := range e.Symbols {
+		if !strings.HasPrefix(name, ".") {
+			res = append(res, addr)
+		}
+	}
+	sort.Slice(res, func(i, j int) bool {
+		return res[i] < res[j]
+	})
+	return res
+}
+
+// Block returns the block which starts at an address, or nil if there is none.
+func (g *Graph) Block(start uint32) *Block {
+	return g.blocks[start]
+}
+
+// Function returns the function with a given name, or nil if there is none.
+func (g *Graph) Function(name string) *Function {
+	for _, f := range g.Functions {
+		if f.Name == name {
+			return f
+		}
+	}
+	return nil
+}
+
+type builder struct {
+	exc   *mips32.Executable
+	flow  *mips32.ControlFlow
+	graph *Graph
+
+	// leaders contains the addresses where control can arrive other than by falling through
+	// from the previous instruction.
+	leaders map[uint32]bool
+}
+
+// findLeaders finds the instructions which start blocks.
+func (b *builder) findLeaders() {
+	for _, start := range b.flow.Functions() {
+		b.leaders[start] = true
+	}
+	for addr, preds := range b.flow.Predecessors() {
+		if len(preds) != 1 || preds[0] != addr-4 {
+			b.leaders[addr] = true
+		} else if _, ok := b.flow.Branch(addr - 4); ok {
+			b.leaders[addr] = true
+		}
+	}
+}
+
+// readBlocks splits the reachable instructions into blocks, which end after the delay slot of
+// a branch or jump, or before a leader.
+func (b *builder) readBlocks() {
+	var block *Block
+	for _, addr := range b.flow.Addresses() {
+		if block == nil || b.leaders[addr] || addr != block.End() {
+			block = &Block{Start: addr}
+			b.graph.blocks[addr] = block
+			b.graph.Blocks = append(b.graph.Blocks, block)
+		}
+		block.Instructions = append(block.Instructions, b.exc.Get(addr))
+		if _, ok := b.flow.Branch(addr); ok {
+			block = nil
+		}
+	}
+}
+
+// connectBlocks adds the edges between blocks.
+func (b *builder) connectBlocks() {
+	for _, block := range b.graph.Blocks {
+		last := block.End() - 4
+		branchAddr, ok := b.flow.Branch(last)
+		if !ok {
+			for _, next := range b.flow.Successors(last) {
+				b.addEdge(block, next, FallthroughEdge)
+			}
+			continue
+		}
+		term := b.exc.Get(branchAddr)
+		if isCall, target, known := b.flow.Call(last); isCall {
+			if known {
+				b.addEdge(block, target, CallEdge)
+			} else {
+				block.IndirectCall = true
+			}
+			b.addEdge(block, block.End(), FallthroughEdge)
+			continue
+		}
+		if b.flow.Exits(last) {
+			if term.Name == "JR" && term.Registers[0] == 31 {
+				block.Returns = true
+			} else {
+				block.IndirectJump = true
+			}
+		}
+		succs := b.flow.Successors(last)
+		for _, next := range succs {
+			kind := JumpEdge
+			if term.IsBranch() && next == block.End() {
+				kind = FallthroughEdge
+			} else if term.IsBranch() && len(succs) == 2 {
+				kind = BranchEdge
+			}
+			b.addEdge(block, next, kind)
+		}
+	}
+}
+
+func (b *builder) addEdge(from *Block, to uint32, kind EdgeKind) {
+	if toBlock := b.graph.blocks[to]; toBlock != nil {
+		edge := &Edge{From: from, To: toBlock, Kind: kind}
+		from.Succs = append(from.Succs, edge)
+		toBlock.Preds = append(toBlock.Preds, edge)
+	}
+}
+
+// groupFunctions finds the blocks of each function.
+func (b *builder) groupFunctions() {
+	starts := b.flow.Functions()
+	sort.Slice(starts, func(i, j int) bool {
+		return starts[i] < starts[j]
+	})
+	for _, start := range starts {
+		entry := b.graph.blocks[start]
+		if entry == nil {
+			continue
+		}
+		f := &Function{Name: b.functionName(start), Entry: entry}
+		visited := map[*Block]bool{}
+		pending := []*Block{f.Entry}
+		for len(pending) > 0 {
+			block := pending[len(pending)-1]
+			pending = pending[:len(pending)-1]
+			if visited[block] {
+				continue
+			}
+			visited[block] = true
+			f.Blocks = append(f.Blocks, block)
+			if block.Function == nil {
+				block.Function = f
+			}
+			for _, edge := range block.Succs {
+				if edge.Kind != CallEdge {
+					pending = append(pending, edge.To)
+				}
+			}
+		}
+		sort.Slice(f.Blocks, func(i, j int) bool {
+			return f.Blocks[i].Start < f.Blocks[j].Start
+		})
+		b.graph.Functions = append(b.graph.Functions, f)
+	}
+}
+
+func (b *builder) functionName(addr uint32) string {
+	var name string
+	for symbol, symbolAddr := range b.exc.Symbols {
+		if symbolAddr == addr && (name == "" || symbol < name) {
+			name = symbol
+		}
+	}
+	if name == "" {
+		return "func_" + hexWord(addr)
+	}
+	return name
+}
+
+func hexWord(n uint32) string {
+	s := strconv.FormatUint(uint64(n), 16)
+	for len(s) < 8 {
+		s = "0" + s
+	}
+	return s
+}
@@ -0,0 +1,133 @@
+package cfg
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/unixpickle/mips32"
+)
+
+const testSource = `.text 0x400100
+main:
+	ADDIU $a0, $0, 3
+	JAL count
+	NOP
+	BNE $v0, $0, main
+	NOP
+	JR $t9
+	NOP
+
+count:
+	ADDIU $v0, $0, 0
+loop:
+	ADDIU $v0, $v0, 1
+	BNE $v0, $a0, loop
+	ADDIU $a0, $a0, 0
+	LUI $t0, %hi(done)
+	ORI $t0, $t0, %lo(done)
+	JR $t0
+	NOP
+done:
+	JR $ra
+	NOP
+
+unused:
+	ADDIU $v0, $0, 1
+	JR $ra
+	NOP`
+
+func testGraph(t *testing.T) *Graph {
+	lines, err := mips32.TokenizeSource(testSource)
+	if err != nil {
+		t.Fatal(err)
+	}
+	exc, err := mips32.ParseExecutable(lines)
+	if err != nil {
+		t.Fatal(err)
+	}
+	exc.Entry = exc.Symbols["main"]
+	return Build(exc)
+}
+
+func TestBuild(t *testing.T) {
+	g := testGraph(t)
+
+	var names []string
+	for _, f := range g.Functions {
+		names = append(names, f.Name)
+	}
+	if strings.Join(names, ",") != "main,count,unused" {
+		t.Fatal("unexpected functions:", names)
+	}
+
+	expected := []struct {
+		start  uint32
+		length int
+		succs  []EdgeKind
+	}{
+		{0x400100, 3, []EdgeKind{CallEdge, FallthroughEdge}},
+		{0x40010c, 2, []EdgeKind{BranchEdge, FallthroughEdge}},
+		{0x400114, 2, nil},
+		{0x40011c, 1, []EdgeKind{FallthroughEdge}},
+		{0x400120, 3, []EdgeKind{BranchEdge, FallthroughEdge}},
+		{0x40012c, 4, []EdgeKind{JumpEdge}},
+		{0x40013c, 2, nil},
+		{0x400144, 3, nil},
+	}
+	if len(g.Blocks) != len(expected) {
+		t.Fatal("unexpected number of blocks:", len(g.Blocks))
+	}
+	for i, x := range expected {
+		block := g.Blocks[i]
+		if block.Start != x.start || len(block.Instructions) != x.length ||
+			len(block.Succs) != len(x.succs) {
+			t.Errorf("block %d: unexpected block at 0x%x with %d instructions", i,
+				block.Start, len(block.Instructions))
+			continue
+		}
+		for j, kind := range x.succs {
+			if block.Succs[j].Kind != kind {
+				t.Errorf("block %d: edge %d should be %s but is %s", i, j, kind,
+					block.Succs[j].Kind)
+			}
+		}
+	}
+
+	loop := g.Block(0x400120)
+	if loop.Succs[0].To != loop || loop.Function != g.Function("count") {
+		t.Error("unexpected loop block")
+	}
+	if jump := g.Block(0x40012c); jump.Succs[0].To != g.Block(0x40013c) {
+		t.Error("the loaded JR target was not followed")
+	}
+	if !g.Block(0x40013c).Returns || !g.Block(0x400114).IndirectJump {
+		t.Error("returns and indirect jumps were not detected")
+	}
+	if unused := g.Function("unused"); unused.Entry != g.Block(0x400144) ||
+		len(unused.Blocks) != 1 || !unused.Entry.Returns {
+		t.Error("unexpected uncalled function")
+	}
+	if callees := g.Block(0x400100).Callees(); len(callees) != 1 ||
+		callees[0] != g.Function("count").Entry {
+		t.Error("unexpected callees:", callees)
+	}
+}
+
+func TestWriteDOT(t *testing.T) {
+	g := testGraph(t)
+	var buf bytes.Buffer
+	if err := g.WriteDOT(&buf, []*Function{g.Function("count")}); err != nil {
+		t.Fatal(err)
+	}
+	dot := buf.String()
+	for _, x := range []string{"label=\"count\"", "b_00400120 -> b_00400120 [color=green]",
+		"00400128:  24840000   addiu", "(return)"} {
+		if !strings.Contains(dot, x) {
+			t.Errorf("missing %q in:\n%s", x, dot)
+		}
+	}
+	if strings.Contains(dot, "b_00400100") {
+		t.Error("other functions should be left out")
+	}
+}
@@ -0,0 +1,120 @@
+package cfg
+
+import (
+	"bufio"
+	"io"
+	"strconv"
+	"strings"
+
+	"github.com/unixpickle/mips32"
+)
+
+// WriteDOT writes the graph in the DOT language of Graphviz.
+//
+// Each function is drawn as a cluster of blocks, and each block lists its instructions in the
+// style of objdump. Branches which are taken are drawn in green, fallthrough edges in black,
+// jumps in blue, and calls as dashed lines between functions.
+//
+// If functions is not nil, only the blocks of the listed functions are written.
+func (g *Graph) WriteDOT(w io.Writer, functions []*Function) error {
+	if functions == nil {
+		functions = g.Functions
+	}
+	formatter := mips32.NewObjdumpFormatter(g.executable.Symbols)
+	included := map[*Block]bool{}
+
+	buf := bufio.NewWriter(w)
+	buf.WriteString("digraph cfg {\n")
+	buf.WriteString("\tnode [shape=box, fontname=\"monospace\"];\n")
+	for i, f := range functions {
+		buf.WriteString("\tsubgraph cluster_" + strconv.Itoa(i) + " {\n")
+		buf.WriteString("\t\tlabel=" + dotString(f.Name) + ";\n")
+		for _, block := range f.Blocks {
+			if included[block] || (block.Function != f && containsFunction(functions,
+				block.Function)) {
+				continue
+			}
+			included[block] = true
+			label, err := blockLabel(block, formatter)
+			if err != nil {
+				return err
+			}
+			buf.WriteString("\t\t" + blockNode(block) + " [label=" + label + "];\n")
+		}
+		buf.WriteString("\t}\n")
+	}
+	for _, block := range g.Blocks {
+		if !included[block] {
+			continue
+		}
+		for _, edge := range block.Succs {
+			if !included[edge.To] {
+				continue
+			}
+			buf.WriteString("\t" + blockNode(block) + " -> " + blockNode(edge.To) + " [" +
+				edgeAttributes(edge.Kind) + "];\n")
+		}
+	}
+	buf.WriteString("}\n")
+	return buf.Flush()
+}
+
+func blockNode(b *Block) string {
+	return "b_" + hexWord(b.Start)
+}
+
+// blockLabel lists the instructions of a block, left-aligned, with a line at the end for
+// returns and indirect jumps.
+func blockLabel(b *Block, formatter *mips32.ObjdumpFormatter) (string, error) {
+	var lines []string
+	for i, inst := range b.Instructions {
+		addr := b.Start + uint32(i*4)
+		delaySlot := i > 0 && b.Instructions[i-1].HasDelaySlot()
+		line, err := formatter.FormatInstruction(inst, addr, delaySlot)
+		if err != nil {
+			return "", err
+		}
+		lines = append(lines, line)
+	}
+	if b.Returns {
+		lines = append(lines, "(return)")
+	} else if b.IndirectJump {
+		lines = append(lines, "(indirect jump)")
+	} else if b.IndirectCall {
+		lines = append(lines, "(indirect call)")
+	}
+	var res string
+	for _, line := range lines {
+		res += dotEscape(line) + "\\l"
+	}
+	return "\"" + res + "\"", nil
+}
+
+func edgeAttributes(kind EdgeKind) string {
+	switch kind {
+	case BranchEdge:
+		return "color=green"
+	case JumpEdge:
+		return "color=blue"
+	case CallEdge:
+		return "style=dashed"
+	}
+	return "color=black"
+}
+
+func containsFunction(list []*Function, f *Function) bool {
+	for _, x := range list {
+		if x == f {
+			return true
+		}
+	}
+	return false
+}
+
+func dotString(s string) string {
+	return "\"" + dotEscape(s) + "\""
+}
+
+func dotEscape(s string) string {
+	return strings.NewReplacer("\\", "\\\\", "\"", "\\\"").Replace(s)
+}
@@ -0,0 +1,371 @@
+package mips32
+
+import "strings"
+
+// A RegisterSet is a set of general-purpose registers, where bit i is set if register i is in
+// the set.
+type RegisterSet uint32
+
+// Contains checks if a register is in the set.
+func (r RegisterSet) Contains(reg int) bool {
+	return r&(1<<uint(reg&31)) != 0
+}
+
+// Registers lists the registers in the set in ascending order.
+func (r RegisterSet) Registers() []int {
+	var res []int
+	for reg := 0; reg < 32; reg++ {
+		if r.Contains(reg) {
+			res = append(res, reg)
+		}
+	}
+	return res
+}
+
+// String lists the registers by their ABI names, as in "$a0 $sp $ra".
+func (r RegisterSet) String() string {
+	var names []string
+	for _, reg := range r.Registers() {
+		names = append(names, "$"+abiRegisterNames[reg])
+	}
+	return strings.Join(names, " ")
+}
+
+func registerSetOf(regs []int) RegisterSet {
+	var res RegisterSet
+	for _, reg := range regs {
+		res |= 1 << uint(reg&31)
+	}
+	return res
+}
+
+const (
+	// callUsedRegisters may be read by a function which is called: the arguments, $gp, $sp,
+	// and $ra, which it returns through.
+	callUsedRegisters RegisterSet = 0xf<<4 | 1<<28 | 1<<29 | 1<<31
+
+	// callClobberedRegisters may be changed by a function which is called: $at, the results,
+	// the arguments, the temporaries, and $ra.
+	callClobberedRegisters RegisterSet = 1<<1 | 0x3<<2 | 0xf<<4 | 0xff<<8 | 0x3<<24 | 1<<31
+
+	// returnLiveRegisters are needed by the caller when a function returns: the results and the
+	// registers which the function must preserve.
+	returnLiveRegisters RegisterSet = 0x3<<2 | 0xff<<16 | 0x7<<28 | 1<<31
+
+	allRegisters RegisterSet = 0xfffffffe
+)
+
+// A DefinitionKind says where the value of a Definition comes from.
+type DefinitionKind int
+
+const (
+	// InstructionDefinition is a value written by the instruction at the definition's address.
+	InstructionDefinition DefinitionKind = iota
+
+	// EntryDefinition is the value which a register holds when the function starting at the
+	// definition's address is called.
+	EntryDefinition
+
+	// CallDefinition is a value left in a register by the JAL or JALR at the definition's
+	// address: a result in $v0 or $v1, or whatever the called function left in another register
+	// which it does not have to preserve.
+	CallDefinition
+)
+
+// A Definition is a place where a register is given a value.
+type Definition struct {
+	Kind     DefinitionKind
+	Address  uint32
+	Register int
+}
+
+// A DataFlow holds the results of data-flow analyses of the general-purpose registers of an
+// executable. (The instruction set has no HI and LO registers.)
+//
+// It answers questions about the instructions which can be reached from the entry point, as
+// found by following control flow into every function that is called. The instruction in a
+// delay slot runs after its branch or jump and before the branch or jump's target.
+//
+// Functions are analyzed separately, using the usual calling convention: a call reads the
+// argument registers, $gp and $sp, and may change any register except $s0-$s7, $gp, $sp and
+// $fp. A function's results and preserved registers are live when it returns.
+// When the program finishes, by returning from the function at the entry point or by running
+// past the end of its code, every register is live, since its registers hold its results.
+type DataFlow struct {
+	exc   *Executable
+	graph *flowGraph
+
+	// callingConvention is false if calls may read every register and leave every register
+	// unchanged, and if returns may be followed by a read of any register.
+	callingConvention bool
+
+	// entryFunction contains the instructions of the function at the entry point.
+	entryFunction map[uint32]bool
+
+	liveIn  map[uint32]RegisterSet
+	liveOut map[uint32]RegisterSet
+
+	definitions []Definition
+	defIndices  map[Definition]int
+	reachingIn  map[uint32]defSet
+}
+
+// DataFlow analyzes the registers of the executable.
+func (e *Executable) DataFlow() *DataFlow {
+	return e.dataFlow(true)
+}
+
+func (e *Executable) dataFlow(callingConvention bool) *DataFlow {
+	d := &DataFlow{exc: e, graph: e.flowGraph(), callingConvention: callingConvention}
+	d.entryFunction = d.graph.function(e.Entry)
+	d.computeLiveness()
+	d.computeReachingDefinitions()
+	return d
+}
+
+// Analyzed checks if the instruction at an address was reached, and therefore analyzed.
+func (d *DataFlow) Analyzed(addr uint32) bool {
+	_, ok := d.graph.successors[addr]
+	return ok
+}
+
+// LiveIn returns the registers whose values may be read at or after the instruction at an
+// address, before they are written again.
+func (d *DataFlow) LiveIn(addr uint32) RegisterSet {
+	return d.liveIn[addr]
+}
+
+// LiveOut returns the registers whose values may be read after the instruction at an address,
+// before they are written again.
+func (d *DataFlow) LiveOut(addr uint32) RegisterSet {
+	return d.liveOut[addr]
+}
+
+// ReachingDefinitions finds every definition of a register which may be the value of the
+// register when the instruction at an address starts.
+func (d *DataFlow) ReachingDefinitions(addr uint32, reg int) []Definition {
+	var res []Definition
+	set := d.reachingIn[addr]
+	for i, def := range d.definitions {
+		if def.Register == reg && set.contains(i) {
+			res = append(res, def)
+		}
+	}
+	return res
+}
+
+// Uses finds the addresses of the instructions which may read the value of a definition, in
+// ascending order.
+func (d *DataFlow) Uses(def Definition) []uint32 {
+	idx, ok := d.defIndices[def]
+	if !ok {
+		return nil
+	}
+	var res []uint32
+	for _, addr := range d.graph.addresses() {
+		if d.reachingIn[addr].contains(idx) && d.reads(addr).Contains(def.Register) {
+			res = append(res, addr)
+		}
+	}
+	return res
+}
+
+// DeadDefinitions finds the registers written by instructions whose values are never read.
+// Writes to $zero are not included.
+func (d *DataFlow) DeadDefinitions() []Definition {
+	var res []Definition
+	for _, def := range d.definitions {
+		if def.Kind == InstructionDefinition && !d.liveOut[def.Address].Contains(def.Register) {
+			res = append(res, def)
+		}
+	}
+	return res
+}
+
+// reads returns the registers which an instruction reads, not counting $zero.
+func (d *DataFlow) reads(addr uint32) RegisterSet {
+	return registerSetOf(d.exc.Get(addr).ReadRegisters()) &^ 1
+}
+
+// writes returns the registers which an instruction writes, not counting $zero.
+func (d *DataFlow) writes(addr uint32) RegisterSet {
+	return registerSetOf(d.exc.Get(addr).WrittenRegisters()) &^ 1
+}
+
+// exitLive finds the registers which are live when control leaves a function after the delay
+// slot at an address.
+func (d *DataFlow) exitLive(slot uint32) RegisterSet {
+	jump := d.exc.Get(d.graph.delaySlots[slot])
+	if d.callingConvention && jump.Name == "JR" && jump.Registers[0] == 31 &&
+		!d.entryFunction[slot] {
+		return returnLiveRegisters
+	}
+	return allRegisters
+}
+
+func (d *DataFlow) computeLiveness() {
+	addrs := d.graph.addresses()
+	d.liveIn = map[uint32]RegisterSet{}
+	d.liveOut = map[uint32]RegisterSet{}
+	for changed := true; changed; {
+		changed = false
+		for i := len(addrs) - 1; i >= 0; i-- {
+			addr := addrs[i]
+			var out RegisterSet
+			for _, next := range d.graph.successors[addr] {
+				if d.Analyzed(next) {
+					out |= d.liveIn[next]
+				} else {
+					// The program ends here.
+					out |= allRegisters
+				}
+			}
+			if d.graph.calls[addr] && !d.callingConvention {
+				// The called function may read any register, or leave it unchanged.
+				out = allRegisters
+			} else if d.graph.calls[addr] {
+				out = out&^callClobberedRegisters | callUsedRegisters
+			} else if d.graph.returns[addr] {
+				out |= d.exitLive(addr)
+			}
+			in := d.reads(addr) | out&^d.writes(addr)
+			if in != d.liveIn[addr] || out != d.liveOut[addr] {
+				d.liveIn[addr] = in
+				d.liveOut[addr] = out
+				changed = true
+			}
+		}
+	}
+}
+
+func (d *DataFlow) computeReachingDefinitions() {
+	addrs := d.graph.addresses()
+	d.defIndices = map[Definition]int{}
+	addDef := func(def Definition) {
+		d.defIndices[def] = len(d.definitions)
+		d.definitions = append(d.definitions, def)
+	}
+	for _, addr := range addrs {
+		for _, reg := range d.writes(addr).Registers() {
+			addDef(Definition{Kind: InstructionDefinition, Address: addr, Register: reg})
+		}
+	}
+	for _, f := range d.graph.functions {
+		for _, reg := range allRegisters.Registers() {
+			addDef(Definition{Kind: EntryDefinition, Address: f, Register: reg})
+		}
+	}
+	for _, addr := range addrs {
+		if d.graph.calls[addr] {
+			call := d.graph.delaySlots[addr]
+			for _, reg := range callClobberedRegisters.Registers() {
+				addDef(Definition{Kind: CallDefinition, Address: call, Register: reg})
+			}
+		}
+	}
+
+	defsOfRegister := make([]defSet, 32)
+	for i, def := range d.definitions {
+		defsOfRegister[def.Register] = defsOfRegister[def.Register].with(i)
+	}
+	killed := func(regs RegisterSet) defSet {
+		var res defSet
+		for _, reg := range regs.Registers() {
+			res = res.union(defsOfRegister[reg])
+		}
+		return res
+	}
+
+	entryDefs := map[uint32]defSet{}
+	for i, def := range d.definitions {
+		if def.Kind == EntryDefinition {
+			entryDefs[def.Address] = entryDefs[def.Address].with(i)
+		}
+	}
+
+	preds := d.graph.predecessors()
+	d.reachingIn = map[uint32]defSet{}
+	reachingOut := map[uint32]defSet{}
+	for changed := true; changed; {
+		changed = false
+		for _, addr := range addrs {
+			in := entryDefs[addr]
+			for _, pred := range preds[addr] {
+				in = in.union(reachingOut[pred])
+			}
+			writes := d.writes(addr)
+			out := in.minus(killed(writes))
+			for _, reg := range writes.Registers() {
+				def := Definition{Kind: InstructionDefinition, Address: addr, Register: reg}
+				out = out.with(d.defIndices[def])
+			}
+			if d.graph.calls[addr] {
+				call := d.graph.delaySlots[addr]
+				out = out.minus(killed(callClobberedRegisters))
+				for _, reg := range callClobberedRegisters.Registers() {
+					def := Definition{Kind: CallDefinition, Address: call, Register: reg}
+					out = out.with(d.defIndices[def])
+				}
+			}
+			if !out.equal(reachingOut[addr]) || !in.equal(d.reachingIn[addr]) {
+				d.reachingIn[addr] = in
+				reachingOut[addr] = out
+				changed = true
+			}
+		}
+	}
+}
+
+// A defSet is a set of definitions, where bit i of the set refers to definition i.
+type defSet []uint64
+
+func (s defSet) contains(i int) bool {
+	return i/64 < len(s) && s[i/64]&(1<<uint(i%64)) != 0
+}
+
+func (s defSet) with(i int) defSet {
+	res := make(defSet, len(s))
+	copy(res, s)
+	for len(res) <= i/64 {
+		res = append(res, 0)
+	}
+	res[i/64] |= 1 << uint(i%64)
+	return res
+}
+
+func (s defSet) union(s1 defSet) defSet {
+	if len(s) < len(s1) {
+		s, s1 = s1, s
+	}
+	res := make(defSet, len(s))
+	copy(res, s)
+	for i, x := range s1 {
+		res[i] |= x
+	}
+	return res
+}
+
+func (s defSet) minus(s1 defSet) defSet {
+	res := make(defSet, len(s))
+	copy(res, s)
+	for i := 0; i < len(res) && i < len(s1); i++ {
+		res[i] &^= s1[i]
+	}
+	return res
+}
+
+func (s defSet) equal(s1 defSet) bool {
+	for i := 0; i < len(s) || i < len(s1); i++ {
+		var x, y uint64
+		if i < len(s) {
+			x = s[i]
+		}
+		if i < len(s1) {
+			y = s1[i]
+		}
+		if x != y {
+			return false
+		}
+	}
+	return true
+}
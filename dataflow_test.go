@@ -0,0 +1,126 @@
+package mips32
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestDataFlow(t *testing.T) {
+	lines, err := TokenizeSource(`.text 0x400100
+main:
+	ADDIU $t0, $0, 5
+	ADDIU $t1, $0, 1
+	ADDIU $t1, $0, 2
+loop:
+	ADDU $t1, $t1, $t0
+	ADDIU $t0, $t0, -1
+	BNE $t0, $0, loop
+	ADDIU $t2, $t1, 0
+	ADDU $a0, $t2, $0
+	JAL f
+	NOP
+	ADDU $s0, $v0, $0
+	JR $ra
+	NOP
+f:
+	ADDIU $v0, $a0, 1
+	JR $ra
+	NOP`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	exc, err := ParseExecutable(lines)
+	if err != nil {
+		t.Fatal(err)
+	}
+	exc.Entry = 0x400100
+	flow := exc.DataFlow()
+
+	// Every register is live when main returns, except those which the call may change.
+	// The call reads $a1-$a3, and $ra is set by the JAL.
+	expectedLive := RegisterSet(1<<8 | 1<<9 | 0x7<<5 | 0x7f<<17 | 0x3<<26 | 0x7<<28)
+	if live := flow.LiveIn(0x40010c); live != expectedLive {
+		t.Errorf("unexpected live registers at loop: %s", live)
+	}
+	if live := flow.LiveOut(0x400114); !live.Contains(8) || !live.Contains(9) {
+		t.Errorf("unexpected live registers after branch: %s", live)
+	}
+	if live := flow.LiveIn(0x400134); !live.Contains(4) || live.Contains(2) {
+		t.Errorf("unexpected live registers in function: %s", live)
+	}
+	if !flow.Analyzed(0x400134) || flow.Analyzed(0x400140) {
+		t.Error("unexpected analyzed instructions")
+	}
+
+	defs := flow.ReachingDefinitions(0x40010c, 9)
+	expected := []Definition{
+		{InstructionDefinition, 0x400108, 9},
+		{InstructionDefinition, 0x40010c, 9},
+	}
+	if !reflect.DeepEqual(defs, expected) {
+		t.Error("unexpected definitions of $t1:", defs)
+	}
+	defs = flow.ReachingDefinitions(0x400128, 2)
+	if !reflect.DeepEqual(defs, []Definition{{CallDefinition, 0x400120, 2}}) {
+		t.Error("unexpected definitions of $v0:", defs)
+	}
+	defs = flow.ReachingDefinitions(0x400134, 4)
+	if !reflect.DeepEqual(defs, []Definition{{EntryDefinition, 0x400134, 4}}) {
+		t.Error("unexpected definitions of $a0:", defs)
+	}
+
+	uses := flow.Uses(Definition{InstructionDefinition, 0x400118, 10})
+	if !reflect.DeepEqual(uses, []uint32{0x40011c}) {
+		t.Error("unexpected uses of delay slot definition:", uses)
+	}
+
+	dead := flow.DeadDefinitions()
+	if !reflect.DeepEqual(dead, []Definition{{InstructionDefinition, 0x400104, 9}}) {
+		t.Error("unexpected dead definitions:", dead)
+	}
+}
+
+func TestDataFlowConstantJumps(t *testing.T) {
+	exc := parseTestExecutable(t, `.text 0x400100
+main:
+	LUI $t9, 0x40
+	ORI $t9, $t9, 0x120
+	JALR $t9
+	NOP
+	LUI $t0, 0x40
+	ADDIU $t0, $t0, 0x12c
+	JR $t0
+	ADDIU $t1, $0, 7
+f:
+	ADDIU $v0, $a0, 1
+	JR $ra
+	NOP
+next:
+	ADDU $a0, $t1, $v0
+	JR $ra
+	NOP`)
+	flow := exc.DataFlow()
+
+	if !flow.Analyzed(0x400120) || !flow.Analyzed(0x40012c) {
+		t.Fatal("the targets of JALR and JR were not analyzed")
+	}
+	defs := flow.ReachingDefinitions(0x40012c, 9)
+	if !reflect.DeepEqual(defs, []Definition{{InstructionDefinition, 0x40011c, 9}}) {
+		t.Error("unexpected definitions of $t1:", defs)
+	}
+	defs = flow.ReachingDefinitions(0x40012c, 2)
+	if !reflect.DeepEqual(defs, []Definition{{CallDefinition, 0x400108, 2}}) {
+		t.Error("unexpected definitions of $v0:", defs)
+	}
+	defs = flow.ReachingDefinitions(0x400120, 4)
+	if !reflect.DeepEqual(defs, []Definition{{EntryDefinition, 0x400120, 4}}) {
+		t.Error("unexpected definitions of $a0:", defs)
+	}
+}
+
+func TestRegisterSet(t *testing.T) {
+	set := registerSetOf([]int{29, 4, 31})
+	if set.String() != "$a0 $sp $ra" || !set.Contains(29) || set.Contains(5) {
+		t.Error("unexpected set:", set)
+	}
+}
@@ -0,0 +1,117 @@
+package mips32
+
+import (
+	"strconv"
+	"strings"
+)
+
+// A Severity indicates how serious a Diagnostic is.
+type Severity int
+
+const (
+	// SeverityError is used for problems which prevent a program from being assembled.
+	SeverityError Severity = iota
+
+	// SeverityWarning is used for code which assembles, but is probably a mistake.
+	SeverityWarning
+)
+
+func (s Severity) String() string {
+	if s == SeverityWarning {
+		return "warning"
+	}
+	return "error"
+}
+
+// A Diagnostic is an error or a warning about a specific place in a source file.
+type Diagnostic struct {
+	Severity Severity
+
+	// File is the name of the source file, or "" for unnamed sources.
+	File string
+
+	// Line and Column give the 1-based position of the problem.
+	// Column is 0 if the problem applies to the line as a whole.
+	Line   int
+	Column int
+
+	Message string
+
+	// Hint is an optional suggestion for fixing the problem.
+	Hint string
+}
+
+// String formats the diagnostic in the style of a compiler, as in
+// "lib/util.s:42:7: error: unknown instruction: ADDD".
+// If there is a hint, it is given on a second line as a note.
+func (d *Diagnostic) String() string {
+	location := d.Location()
+	res := location + ": " + d.Severity.String() + ": " + d.Message
+	if d.Hint != "" {
+		res += "\n" + location + ": note: " + d.Hint
+	}
+	return res
+}
+
+// Location returns the position of the diagnostic, as in "lib/util.s:42:7".
+func (d *Diagnostic) Location() string {
+	res := sourceLocation(d.File, d.Line)
+	if d.Column > 0 {
+		if d.File == "" {
+			res += ", column " + strconv.Itoa(d.Column)
+		} else {
+			res += ":" + strconv.Itoa(d.Column)
+		}
+	}
+	return res
+}
+
+// A DiagnosticList is an ordered list of diagnostics.
+// It implements the error interface, so that functions which find several problems can report
+// all of them at once.
+type DiagnosticList []Diagnostic
+
+// Error returns every diagnostic in the list, one per line.
+func (d DiagnosticList) Error() string {
+	strs := make([]string, len(d))
+	for i, x := range d {
+		strs[i] = x.String()
+	}
+	return strings.Join(strs, "\n")
+}
+
+// HasErrors returns true if any of the diagnostics is an error rather than a warning.
+func (d DiagnosticList) HasErrors() bool {
+	for _, x := range d {
+		if x.Severity == SeverityError {
+			return true
+		}
+	}
+	return false
+}
+
+// Err returns the list as an error if it contains any errors, or nil otherwise.
+func (d DiagnosticList) Err() error {
+	if d.HasErrors() {
+		return d
+	}
+	return nil
+}
+
+// addError appends an error diagnostic for a tokenized line.
+func (d *DiagnosticList) addError(line *TokenizedLine, column int, message, hint string) {
+	*d = append(*d, Diagnostic{
+		Severity: SeverityError,
+		File:     line.File,
+		Line:     line.LineNumber,
+		Column:   column,
+		Message:  message,
+		Hint:     hint,
+	})
+}
+
+// addWarning appends a warning diagnostic for a tokenized line.
+func (d *DiagnosticList) addWarning(line *TokenizedLine, column int, message, hint string) {
+	d.addError(line, column, message, hint)
+	(*d)[len(*d)-1].Severity = SeverityWarning
+}
@@ -0,0 +1,91 @@
+package mips32
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestParseExecutableDiagnostics(t *testing.T) {
+	source := `main:
+	ADDIU $t0, $t0, 70000
+	ADDD $t0, $t1, $t2
+main: NOP
+	.text 3
+	BEQ $t0, $t0, 1f
+	.set noreorder
+	J main
+	J main
+	NOP`
+	lines, err := TokenizeSource(source)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = ParseExecutable(lines)
+	list, ok := err.(DiagnosticList)
+	if !ok {
+		t.Fatal("expected a DiagnosticList but got", err)
+	}
+	expected := []struct {
+		severity Severity
+		line     int
+		column   int
+		hint     string
+	}{
+		{SeverityError, 2, 18, "expected ADDIU register, register, signed16"},
+		{SeverityError, 3, 2, "did you mean ADDU?"},
+		{SeverityError, 4, 1, "previously declared at line 1"},
+		{SeverityError, 5, 2, "segment addresses must be multiples of 4"},
+		{SeverityError, 6, 16, ""},
+		{SeverityWarning, 9, 2, "insert a NOP after the previous branch or jump"},
+	}
+	if len(list) != len(expected) {
+		t.Fatal("unexpected diagnostics:", list)
+	}
+	for i, x := range expected {
+		d := list[i]
+		if d.Severity != x.severity || d.Line != x.line || d.Column != x.column ||
+			d.Hint != x.hint {
+			t.Errorf("diagnostic %d: unexpected %s", i, d.String())
+		}
+	}
+}
+
+func TestParseExecutableWarnings(t *testing.T) {
+	lines, err := TokenizeSource(".set noreorder\nJ x\nx: JR $ra\nNOP")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var warnings []Diagnostic
+	_, err = ParseExecutableOptions(lines, ParseOptions{
+		WarningHandler: func(d Diagnostic) {
+			warnings = append(warnings, d)
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(warnings) != 1 || warnings[0].Line != 3 || warnings[0].Column != 4 {
+		t.Error("unexpected warnings:", warnings)
+	}
+}
+
+func TestTokenizeSourceDiagnostics(t *testing.T) {
+	_, err := TokenizeSource("NOP ?\nNOP\n.bogus\nLUI $t0 5")
+	list, ok := err.(DiagnosticList)
+	if !ok {
+		t.Fatal("expected a DiagnosticList but got", err)
+	}
+	if len(list) != 3 {
+		t.Fatal("unexpected diagnostics:", list)
+	}
+	for i, line := range []int{1, 3, 4} {
+		if list[i].Line != line {
+			t.Errorf("diagnostic %d: unexpected %s", i, list[i].String())
+		}
+	}
+	expectedStr := "line 3, column 1: error: unknown directive: .bogus\n" +
+		"line 3, column 1: note: supported directives are .text, "
+	if !strings.HasPrefix(list[1].String(), expectedStr) {
+		t.Error("unexpected string:", list[1].String())
+	}
+}
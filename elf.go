@@ -0,0 +1,375 @@
+package mips32
+
+import (
+	"bytes"
+	"debug/elf"
+	"encoding/binary"
+	"errors"
+	"io"
+	"strings"
+)
+
+const (
+	// elfFlagsMIPS32 marks a file as MIPS32 code for the O32 ABI
+	// (EF_MIPS_ARCH_32 | EF_MIPS_ABI_O32).
+	elfFlagsMIPS32 = 0x50001000
+
+	elfHeaderSize        = 52
+	elfProgHeaderSize    = 32
+	elfSectionHeaderSize = 40
+	elfSymbolSize        = 16
+	elfRelSize           = 8
+)
+
+// WriteELF writes an executable as an ELF32 executable for a MIPS processor.
+//
+// Every segment of the executable gets a loadable program header and a section of its own, and
+// the symbol table is written to ".symtab". The entry point is found by e.EntryPoint, so a
+// program without code at e.Entry starts at main, start, or its lowest address.
+func WriteELF(w io.Writer, e *Executable, littleEndian bool) error {
+	f := newELFFile(elf.ET_EXEC, littleEndian)
+	f.entry = e.EntryPoint()
+
+	segments := e.sortedSegmentAddresses()
+	sectionIndices := map[uint32]int{}
+	for i, addr := range segments {
+		data, err := encodeSegment(e, addr, f.order)
+		if err != nil {
+			return err
+		}
+		name := ".text"
+		if i > 0 {
+			name += "." + strings.TrimPrefix(hexAddress(addr), "0x")
+		}
+		sectionIndices[addr] = len(f.sections)
+		f.sections = append(f.sections, &elfSection{
+			name: name,
+			header: elf.Section32{
+				Type:      uint32(elf.SHT_PROGBITS),
+				Flags:     uint32(elf.SHF_ALLOC | elf.SHF_EXECINSTR),
+				Addr:      addr,
+				Addralign: 4,
+			},
+			data:     data,
+			loadable: true,
+		})
+	}
+
+	var symbols []elfSymbol
+	for _, pair := range e.sortedSymbolAddrPairs() {
+		section := elf.SHN_ABS
+		for _, addr := range segments {
+			if pair.Address >= addr && pair.Address < addr+uint32(len(e.Segments[addr])*4) {
+				section = elf.SectionIndex(sectionIndices[addr])
+			}
+		}
+		symbols = append(symbols, elfSymbol{
+			name:    pair.Symbol,
+			value:   pair.Address,
+			section: section,
+			global:  true,
+		})
+	}
+	f.addSymbolTable(symbols)
+	return f.write(w)
+}
+
+// WriteELFObject writes a relocatable object as an ELF32 relocatable file for a MIPS processor.
+//
+// Each section of the object gets a ".rel" section for its relocations. Since ELF relocations
+// for MIPS keep their addends in the relocated words, relocations with non-zero addends (other
+// than R_MIPS_32) are not supported.
+func WriteELFObject(w io.Writer, o *Object, littleEndian bool) error {
+	f := newELFFile(elf.ET_REL, littleEndian)
+
+	sectionIndices := map[string]elf.SectionIndex{}
+	for _, section := range o.Sections {
+		flags := elf.SHF_ALLOC | elf.SHF_WRITE
+		if strings.HasPrefix(section.Name, ".text") {
+			flags = elf.SHF_ALLOC | elf.SHF_EXECINSTR
+		}
+		sectionIndices[section.Name] = elf.SectionIndex(len(f.sections))
+		f.sections = append(f.sections, &elfSection{
+			name: section.Name,
+			header: elf.Section32{
+				Type:      uint32(elf.SHT_PROGBITS),
+				Flags:     uint32(flags),
+				Addralign: 4,
+			},
+		})
+	}
+
+	// Local symbols must come before global ones, and undefined symbols come last.
+	var symbols []elfSymbol
+	symbolIndices := map[string]int{}
+	for _, global := range []bool{false, true} {
+		for _, sym := range o.Symbols {
+			if sym.Global == global {
+				symbolIndices[sym.Name] = len(symbols) + 1
+				symbols = append(symbols, elfSymbol{
+					name:    sym.Name,
+					value:   sym.Offset,
+					section: sectionIndices[sym.Section],
+					global:  global,
+				})
+			}
+		}
+	}
+	for _, section := range o.Sections {
+		for _, reloc := range section.Relocations {
+			if _, ok := symbolIndices[reloc.Symbol]; !ok {
+				symbolIndices[reloc.Symbol] = len(symbols) + 1
+				symbols = append(symbols, elfSymbol{
+					name:    reloc.Symbol,
+					section: elf.SHN_UNDEF,
+					global:  true,
+				})
+			}
+		}
+	}
+	symtabIndex := len(f.sections) + len(o.Sections)
+	for _, section := range o.Sections {
+		for _, reloc := range section.Relocations {
+			if reloc.Addend != 0 && reloc.Type != elf.R_MIPS_32 {
+				return errors.New("cannot write " + reloc.Type.String() +
+					" relocation with an addend to an ELF file")
+			}
+		}
+		index := sectionIndices[section.Name]
+		f.sections[index].data = encodeObjectSection(section, f.order)
+
+		var rels bytes.Buffer
+		for _, reloc := range section.Relocations {
+			binary.Write(&rels, f.order, &elf.Rel32{
+				Off:  reloc.Offset,
+				Info: elf.R_INFO32(uint32(symbolIndices[reloc.Symbol]), uint32(reloc.Type)),
+			})
+		}
+		f.sections = append(f.sections, &elfSection{
+			name: ".rel" + section.Name,
+			header: elf.Section32{
+				Type:      uint32(elf.SHT_REL),
+				Flags:     uint32(elf.SHF_INFO_LINK),
+				Link:      uint32(symtabIndex),
+				Info:      uint32(index),
+				Addralign: 4,
+				Entsize:   elfRelSize,
+			},
+			data: rels.Bytes(),
+		})
+	}
+	f.addSymbolTable(symbols)
+	return f.write(w)
+}
+
+// encodeSegment encodes the instructions of a segment as bytes.
+func encodeSegment(e *Executable, start uint32, order binary.ByteOrder) ([]byte, error) {
+	insts := e.Segments[start]
+	res := make([]byte, len(insts)*4)
+	for i := range insts {
+		addr := start + uint32(i*4)
+		word, err := insts[i].Encode(addr, e.Symbols)
+		if err != nil {
+			return nil, errors.New(hexAddress(addr) + ": " + err.Error())
+		}
+		order.PutUint32(res[i*4:], word)
+	}
+	return res, nil
+}
+
+// encodeObjectSection encodes the words of a section as bytes.
+// Branches with R_MIPS_PC16 relocations are given the implicit addend -4 which other MIPS tools
+// expect, since ELF measures branch distances from the branch rather than its delay slot.
+func encodeObjectSection(section *ObjectSection, order binary.ByteOrder) []byte {
+	res := make([]byte, len(section.Words)*4)
+	for i, word := range section.Words {
+		order.PutUint32(res[i*4:], word)
+	}
+	for _, reloc := range section.Relocations {
+		idx := reloc.Offset
+		switch reloc.Type {
+		case elf.R_MIPS_32:
+			order.PutUint32(res[idx:], section.Words[idx/4]+uint32(reloc.Addend))
+		case elf.R_MIPS_PC16:
+			order.PutUint32(res[idx:], section.Words[idx/4]|0xffff)
+		}
+	}
+	return res
+}
+
+type elfSection struct {
+	name   string
+	header elf.Section32
+	data   []byte
+
+	// loadable is set if the section gets a PT_LOAD program header.
+	loadable bool
+}
+
+type elfSymbol struct {
+	name    string
+	value   uint32
+	section elf.SectionIndex
+	global  bool
+}
+
+// An elfFile collects the sections of an ELF file before it is written.
+type elfFile struct {
+	order    binary.ByteOrder
+	data     elf.Data
+	fileType elf.Type
+	entry    uint32
+
+	// sections starts with the null section.
+	sections []*elfSection
+}
+
+func newELFFile(fileType elf.Type, littleEndian bool) *elfFile {
+	res := &elfFile{
+		order:    binary.BigEndian,
+		data:     elf.ELFDATA2MSB,
+		fileType: fileType,
+		sections: []*elfSection{{}},
+	}
+	if littleEndian {
+		res.order = binary.LittleEndian
+		res.data = elf.ELFDATA2LSB
+	}
+	return res
+}
+
+// addSymbolTable adds the ".symtab" and ".strtab" sections.
+// Local symbols must precede global ones in the list.
+func (f *elfFile) addSymbolTable(symbols []elfSymbol) {
+	strtab := newELFStringTable()
+	var symtab bytes.Buffer
+	binary.Write(&symtab, f.order, &elf.Sym32{})
+	firstGlobal := len(symbols) + 1
+	for i, sym := range symbols {
+		bind := elf.STB_LOCAL
+		if sym.global {
+			bind = elf.STB_GLOBAL
+			if firstGlobal > i+1 {
+				firstGlobal = i + 1
+			}
+		}
+		binary.Write(&symtab, f.order, &elf.Sym32{
+			Name:  strtab.add(sym.name),
+			Value: sym.value,
+			Info:  elf.ST_INFO(bind, elf.STT_NOTYPE),
+			Shndx: uint16(sym.section),
+		})
+	}
+	f.sections = append(f.sections, &elfSection{
+		name: ".symtab",
+		header: elf.Section32{
+			Type:      uint32(elf.SHT_SYMTAB),
+			Link:      uint32(len(f.sections) + 1),
+			Info:      uint32(firstGlobal),
+			Addralign: 4,
+			Entsize:   elfSymbolSize,
+		},
+		data: symtab.Bytes(),
+	}, &elfSection{
+		name:   ".strtab",
+		header: elf.Section32{Type: uint32(elf.SHT_STRTAB), Addralign: 1},
+		data:   strtab.data,
+	})
+}
+
+// write lays out the file and writes it.
+// The headers come first, followed by the contents of each section and the section headers.
+func (f *elfFile) write(w io.Writer) error {
+	shstrtab := newELFStringTable()
+	f.sections = append(f.sections, &elfSection{
+		name:   ".shstrtab",
+		header: elf.Section32{Type: uint32(elf.SHT_STRTAB), Addralign: 1},
+	})
+	for _, section := range f.sections[1:] {
+		section.header.Name = shstrtab.add(section.name)
+	}
+	f.sections[len(f.sections)-1].data = shstrtab.data
+
+	var loadable []*elfSection
+	for _, section := range f.sections {
+		if section.loadable {
+			loadable = append(loadable, section)
+		}
+	}
+
+	offset := uint32(elfHeaderSize + elfProgHeaderSize*len(loadable))
+	for _, section := range f.sections[1:] {
+		offset = (offset + 3) &^ 3
+		section.header.Off = offset
+		section.header.Size = uint32(len(section.data))
+		offset += section.header.Size
+	}
+	sectionHeaderOffset := (offset + 3) &^ 3
+
+	header := elf.Header32{
+		Type:      uint16(f.fileType),
+		Machine:   uint16(elf.EM_MIPS),
+		Version:   uint32(elf.EV_CURRENT),
+		Entry:     f.entry,
+		Shoff:     sectionHeaderOffset,
+		Flags:     elfFlagsMIPS32,
+		Ehsize:    elfHeaderSize,
+		Phentsize: elfProgHeaderSize,
+		Phnum:     uint16(len(loadable)),
+		Shentsize: elfSectionHeaderSize,
+		Shnum:     uint16(len(f.sections)),
+		Shstrndx:  uint16(len(f.sections) - 1),
+	}
+	if len(loadable) > 0 {
+		header.Phoff = elfHeaderSize
+	}
+	copy(header.Ident[:], elf.ELFMAG)
+	header.Ident[elf.EI_CLASS] = byte(elf.ELFCLASS32)
+	header.Ident[elf.EI_DATA] = byte(f.data)
+	header.Ident[elf.EI_VERSION] = byte(elf.EV_CURRENT)
+
+	var buf bytes.Buffer
+	binary.Write(&buf, f.order, &header)
+	for _, section := range loadable {
+		binary.Write(&buf, f.order, &elf.Prog32{
+			Type:   uint32(elf.PT_LOAD),
+			Off:    section.header.Off,
+			Vaddr:  section.header.Addr,
+			Paddr:  section.header.Addr,
+			Filesz: section.header.Size,
+			Memsz:  section.header.Size,
+			Flags:  uint32(elf.PF_R | elf.PF_X),
+			Align:  4,
+		})
+	}
+	for _, section := range f.sections[1:] {
+		buf.Write(make([]byte, int(section.header.Off)-buf.Len()))
+		buf.Write(section.data)
+	}
+	buf.Write(make([]byte, int(sectionHeaderOffset)-buf.Len()))
+	for _, section := range f.sections {
+		binary.Write(&buf, f.order, &section.header)
+	}
+	_, err := w.Write(buf.Bytes())
+	return err
+}
+
+// An elfStringTable builds the contents of a string table section.
+type elfStringTable struct {
+	data    []byte
+	offsets map[string]uint32
+}
+
+func newELFStringTable() *elfStringTable {
+	return &elfStringTable{data: []byte{0}, offsets: map[string]uint32{"": 0}}
+}
+
+func (s *elfStringTable) add(str string) uint32 {
+	if offset, ok := s.offsets[str]; ok {
+		return offset
+	}
+	offset := uint32(len(s.data))
+	s.data = append(append(s.data, str...), 0)
+	s.offsets[str] = offset
+	return offset
+}
@@ -0,0 +1,109 @@
+package mips32
+
+import (
+	"debug/elf"
+	"errors"
+	"io"
+	"strconv"
+)
+
+// LoadELF reads an ELF32 executable for a MIPS processor, such as one written by WriteELF.
+//
+// Every PT_LOAD segment is copied into memory, and segments marked executable are decoded as
+// instructions. The symbols come from ".symtab".
+// Relocatable files cannot be loaded, since their addresses have not been decided.
+func LoadELF(r io.ReaderAt) (*Image, error) {
+	f, err := elf.NewFile(r)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	if f.Class != elf.ELFCLASS32 {
+		return nil, errors.New("not a 32-bit ELF file")
+	} else if f.Machine != elf.EM_MIPS {
+		return nil, errors.New("not a MIPS ELF file: " + f.Machine.String())
+	} else if f.Type == elf.ET_REL {
+		return nil, errors.New("relocatable ELF files must be linked before they are loaded")
+	} else if f.Type != elf.ET_EXEC {
+		return nil, errors.New("unsupported ELF file type: " + f.Type.String())
+	}
+
+	res := &Image{
+		Executable: &Executable{
+			Segments: map[uint32][]Instruction{},
+			Symbols:  map[string]uint32{},
+			Entry:    uint32(f.Entry),
+		},
+		Memory:       NewLazyMemory(),
+		LittleEndian: f.Data == elf.ELFDATA2LSB,
+	}
+	for _, prog := range f.Progs {
+		if prog.Type != elf.PT_LOAD {
+			continue
+		}
+		if err := res.loadSegment(prog); err != nil {
+			return nil, err
+		}
+	}
+	res.Executable.joinContiguousSegments()
+
+	symbols, err := f.Symbols()
+	if err != nil && err != elf.ErrNoSymbols {
+		return nil, err
+	}
+	for _, sym := range symbols {
+		typ := elf.ST_TYPE(sym.Info)
+		if sym.Name == "" || sym.Section == elf.SHN_UNDEF || typ == elf.STT_SECTION ||
+			typ == elf.STT_FILE {
+			continue
+		}
+		// Local symbols from different source files may share a name; the first one wins.
+		if _, ok := res.Executable.Symbols[sym.Name]; !ok {
+			res.Executable.Symbols[sym.Name] = uint32(sym.Value)
+		}
+	}
+	return res, nil
+}
+
+func (e *Image) loadSegment(prog *elf.Prog) error {
+	start := uint32(prog.Vaddr)
+	if prog.Vaddr+prog.Memsz > 1<<32 {
+		return errors.New("segment at " + hexAddress(start) + " extends past the address space")
+	}
+	data := make([]byte, prog.Filesz)
+	if _, err := prog.ReadAt(data, 0); err != nil {
+		return errors.New("segment at " + hexAddress(start) + ": " + err.Error())
+	}
+	for i, b := range data {
+		e.Memory.Set(start+uint32(i), b)
+	}
+
+	if prog.Flags&elf.PF_X == 0 || len(data) == 0 {
+		return nil
+	}
+	if start&3 != 0 || len(data)&3 != 0 {
+		return errors.New("executable segment at " + hexAddress(start) +
+			" is not word aligned (size " + strconv.Itoa(len(data)) + ")")
+	}
+	insts := make([]Instruction, len(data)/4)
+	for i := range insts {
+		var word uint32
+		for j := 0; j < 4; j++ {
+			b := uint32(data[i*4+j])
+			if e.LittleEndian {
+				word |= b << uint(8*j)
+			} else {
+				word |= b << uint(8*(3-j))
+			}
+		}
+		insts[i] = *decodeInstructionAt(word, start+uint32(i*4))
+	}
+	for addr := range e.Executable.Segments {
+		if e.Executable.addressInUse(start) || (addr >= start && addr < start+uint32(len(data))) {
+			return errors.New("overlapping executable segments at " + hexAddress(start))
+		}
+	}
+	e.Executable.Segments[start] = insts
+	return nil
+}
@@ -0,0 +1,277 @@
+package mips32
+
+import (
+	"bytes"
+	"debug/elf"
+	"encoding/binary"
+	"testing"
+)
+
+func TestWriteELF(t *testing.T) {
+	lines, err := TokenizeSource(`.text 0x80000000
+start: J done
+	NOP
+.text 0x80100000
+done: JR $ra
+	NOP`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	exc, err := ParseExecutable(lines)
+	if err != nil {
+		t.Fatal(err)
+	}
+	exc.Entry = 0x80000000
+
+	for _, little := range []bool{false, true} {
+		var buf bytes.Buffer
+		if err := WriteELF(&buf, exc, little); err != nil {
+			t.Fatal(err)
+		}
+		f, err := elf.NewFile(bytes.NewReader(buf.Bytes()))
+		if err != nil {
+			t.Fatal(err)
+		}
+		var order binary.ByteOrder = binary.BigEndian
+		if little {
+			order = binary.LittleEndian
+			if f.Data != elf.ELFDATA2LSB {
+				t.Error("unexpected data encoding:", f.Data)
+			}
+		} else if f.Data != elf.ELFDATA2MSB {
+			t.Error("unexpected data encoding:", f.Data)
+		}
+		if f.Class != elf.ELFCLASS32 || f.Machine != elf.EM_MIPS || f.Type != elf.ET_EXEC ||
+			f.Entry != 0x80000000 {
+			t.Error("unexpected header:", f.FileHeader)
+		}
+		if len(f.Progs) != 2 {
+			t.Fatal("unexpected number of program headers:", len(f.Progs))
+		}
+		for i, addr := range []uint32{0x80000000, 0x80100000} {
+			prog := f.Progs[i]
+			if prog.Type != elf.PT_LOAD || prog.Vaddr != uint64(addr) || prog.Filesz != 8 {
+				t.Error("unexpected program header:", prog.ProgHeader)
+				continue
+			}
+			data := make([]byte, 8)
+			if _, err := prog.ReadAt(data, 0); err != nil {
+				t.Fatal(err)
+			}
+			expected, _ := exc.Get(addr).Encode(addr, exc.Symbols)
+			if actual := order.Uint32(data); actual != expected {
+				t.Errorf("segment %d: expected %08x but got %08x", i, expected, actual)
+			}
+		}
+
+		symbols, err := f.Symbols()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(symbols) != 2 {
+			t.Fatal("unexpected symbols:", symbols)
+		}
+		for _, sym := range symbols {
+			section := f.Sections[sym.Section]
+			if sym.Value != uint64(exc.Symbols[sym.Name]) || section.Addr > sym.Value ||
+				section.Addr+section.Size <= sym.Value {
+				t.Error("unexpected symbol:", sym)
+			}
+		}
+	}
+}
+
+func TestWriteELFEntry(t *testing.T) {
+	sources := map[string]uint32{
+		".text 0x80000000\nstart: NOP\nmain: NOP":      0x80000004,
+		".text 0x80000000\nNOP\nstart: NOP":            0x80000004,
+		".text 0x80000100\nNOP\n.text 0x80000000\nNOP": 0x80000000,
+		"NOP\nmain: NOP": 0,
+	}
+	for source, entry := range sources {
+		lines, err := TokenizeSource(source)
+		if err != nil {
+			t.Fatal(err)
+		}
+		exc, err := ParseExecutable(lines)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var buf bytes.Buffer
+		if err := WriteELF(&buf, exc, false); err != nil {
+			t.Fatal(err)
+		}
+		f, err := elf.NewFile(bytes.NewReader(buf.Bytes()))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if f.Entry != uint64(entry) {
+			t.Errorf("expected entry 0x%x but got 0x%x for %q", entry, f.Entry, source)
+		}
+	}
+}
+
+func TestWriteELFObject(t *testing.T) {
+	obj := testParseObject(t, `.globl main
+main: JAL util
+	LUI $t0, %hi(table)
+	BEQ $0, $0, util
+	NOP
+	.data
+table: .word main`)
+
+	var buf bytes.Buffer
+	if err := WriteELFObject(&buf, obj, false); err != nil {
+		t.Fatal(err)
+	}
+	f, err := elf.NewFile(bytes.NewReader(buf.Bytes()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if f.Type != elf.ET_REL || f.Machine != elf.EM_MIPS {
+		t.Error("unexpected header:", f.FileHeader)
+	}
+
+	symbols, err := f.Symbols()
+	if err != nil {
+		t.Fatal(err)
+	}
+	expectedSymbols := []struct {
+		name    string
+		bind    elf.SymBind
+		section string
+	}{
+		{"table", elf.STB_LOCAL, ".data"},
+		{"main", elf.STB_GLOBAL, ".text"},
+		{"util", elf.STB_GLOBAL, ""},
+	}
+	if len(symbols) != len(expectedSymbols) {
+		t.Fatal("unexpected symbols:", symbols)
+	}
+	for i, x := range expectedSymbols {
+		sym := symbols[i]
+		section := ""
+		if sym.Section != elf.SHN_UNDEF {
+			section = f.Sections[sym.Section].Name
+		}
+		if sym.Name != x.name || elf.ST_BIND(sym.Info) != x.bind || section != x.section {
+			t.Error("unexpected symbol:", sym)
+		}
+	}
+
+	rels := f.Section(".rel.text")
+	if rels == nil {
+		t.Fatal("missing .rel.text")
+	}
+	data, err := rels.Data()
+	if err != nil {
+		t.Fatal(err)
+	}
+	expectedRels := []struct {
+		offset uint32
+		symbol string
+		typ    elf.R_MIPS
+	}{
+		{0, "util", elf.R_MIPS_26},
+		{4, "table", elf.R_MIPS_HI16},
+		{8, "util", elf.R_MIPS_PC16},
+	}
+	if len(data) != len(expectedRels)*8 {
+		t.Fatal("unexpected .rel.text size:", len(data))
+	}
+	for i, x := range expectedRels {
+		offset := binary.BigEndian.Uint32(data[i*8:])
+		info := binary.BigEndian.Uint32(data[i*8+4:])
+		symbol := symbols[elf.R_SYM32(info)-1].Name
+		if offset != x.offset || symbol != x.symbol || elf.R_MIPS(elf.R_TYPE32(info)) != x.typ {
+			t.Errorf("relocation %d: unexpected %d %s %d", i, offset, symbol, elf.R_TYPE32(info))
+		}
+	}
+
+	text, _ := f.Section(".text").Data()
+	if binary.BigEndian.Uint32(text[8:])&0xffff != 0xffff {
+		t.Error("expected implicit addend in branch")
+	}
+	if f.Section(".rel.data") == nil {
+		t.Error("missing .rel.data")
+	}
+}
+
+func TestLoadELF(t *testing.T) {
+	lines, err := TokenizeSource(`.text 0x80000000
+start: LUI $t0, 0x8010
+	LW $t1, 4($t0)
+	J done
+	NOP
+.text 0x80100000
+done: JR $ra
+	ADDIU $t1, $t1, 1`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	exc, err := ParseExecutable(lines)
+	if err != nil {
+		t.Fatal(err)
+	}
+	exc.Entry = exc.Symbols["start"]
+
+	for _, little := range []bool{false, true} {
+		var buf bytes.Buffer
+		if err := WriteELF(&buf, exc, little); err != nil {
+			t.Fatal(err)
+		}
+		image, err := LoadELF(bytes.NewReader(buf.Bytes()))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if image.LittleEndian != little {
+			t.Error("unexpected endianness:", image.LittleEndian)
+		}
+		loaded := image.Executable
+		if loaded.Entry != 0x80000000 || len(loaded.Segments) != 2 ||
+			len(loaded.Symbols) != 2 || loaded.Symbols["done"] != 0x80100000 {
+			t.Fatal("unexpected executable:", loaded)
+		}
+		for addr, insts := range exc.Segments {
+			for i := range insts {
+				expected, _ := insts[i].Encode(addr+uint32(i*4), exc.Symbols)
+				actual, err := loaded.Segments[addr][i].Encode(addr+uint32(i*4), loaded.Symbols)
+				if err != nil || actual != expected {
+					t.Errorf("instruction at %08x: expected %08x but got %08x", addr+uint32(i*4),
+						expected, actual)
+				}
+			}
+		}
+
+		emu := &Emulator{
+			Memory:         image.Memory,
+			Executable:     loaded,
+			LittleEndian:   little,
+			ProgramCounter: loaded.Entry,
+		}
+		emu.RegisterFile[31] = 0xffffff00
+		for !emu.Done() {
+			if err := emu.Step(); err != nil {
+				t.Fatal(err)
+			}
+		}
+		// The second word of the last segment is "ADDIU $t1, $t1, 1".
+		if emu.RegisterFile[9] != 0x25290002 {
+			t.Errorf("unexpected $t1: %08x", emu.RegisterFile[9])
+		}
+	}
+}
+
+func TestLoadELFErrors(t *testing.T) {
+	var buf bytes.Buffer
+	obj := testParseObject(t, "main: NOP")
+	if err := WriteELFObject(&buf, obj, false); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := LoadELF(bytes.NewReader(buf.Bytes())); err == nil {
+		t.Error("expected error for relocatable file")
+	}
+	if _, err := LoadELF(bytes.NewReader([]byte("\x7fELF"))); err == nil {
+		t.Error("expected error for truncated file")
+	}
+}
@@ -42,6 +42,9 @@ type Emulator struct {
 
 	// JumpTarget is the target location for the jump/branch referred to by JumpNext.
 	JumpTarget uint32
+
+	// instructionAddr is the address of the instruction being executed by Step.
+	instructionAddr uint32
 }
 
 // Done returns true if the program has begun to execute NOPs past the executable code.
@@ -57,6 +60,7 @@ func (e *Emulator) Done() bool {
 // In the case of an error, the program counter may still be changed as usual.
 func (e *Emulator) Step() error {
 	inst := e.Executable.Get(e.ProgramCounter)
+	e.instructionAddr = e.ProgramCounter
 	if e.JumpNext {
 		e.DelaySlot = true
 		e.JumpNext = false
@@ -94,14 +98,14 @@ func (e *Emulator) Step() error {
 	case "MOVN", "MOVZ":
 		e.executeConditionalMove(inst)
 	default:
-		return errors.New("unknown instruction: " + inst.Name)
+		return e.instructionError("unknown instruction: " + inst.Name)
 	}
 	return nil
 }
 
 func (e *Emulator) executeBranch(inst *Instruction) error {
 	if e.DelaySlot {
-		return errors.New("branch in delay slot yields unpredictable behavior")
+		return e.instructionError("branch in delay slot yields unpredictable behavior")
 	}
 
 	offset, err := instructionBranchOffset(inst, e.ProgramCounter-4, e.Executable.Symbols)
@@ -130,7 +134,7 @@ func (e *Emulator) executeBranch(inst *Instruction) error {
 
 func (e *Emulator) executeJump(inst *Instruction) error {
 	if e.DelaySlot {
-		return errors.New("jump in delay slot yields unpredictable behavior")
+		return e.instructionError("jump in delay slot yields unpredictable behavior")
 	}
 
 	if inst.Name == "J" || inst.Name == "JAL" {
@@ -335,9 +339,14 @@ func (e *Emulator) executeConditionalMove(inst *Instruction) {
 	}
 }
 
+// instructionError creates an error for the instruction which is being executed.
+// The error gives the instruction's source position when the executable has a line table.
 func (e *Emulator) instructionError(msg string) error {
-	pc := e.ProgramCounter - 4
+	pc := e.instructionAddr
 	pcStr := "0x" + strconv.FormatUint(uint64(pc), 16)
+	if pos, ok := e.Executable.LineTable[pc]; ok {
+		pcStr += " (" + pos.String() + ")"
+	}
 	return errors.New("error at " + pcStr + ": " + msg)
 }
 
@@ -289,6 +289,59 @@ func TestEmulatorWriteZero(t *testing.T) {
 	}
 }
 
+func TestEmulatorRelaxedBranches(t *testing.T) {
+	code := `BEQ $a0, $0, far
+	ORI $t0, $0, 1
+	ORI $t1, $0, 2
+	J done
+	NOP
+	.text 0x40000
+far: ORI $t2, $0, 3
+done: NOP`
+	lines, err := TokenizeSource(code)
+	if err != nil {
+		t.Fatal(err)
+	}
+	program, err := ParseExecutableOptions(lines, ParseOptions{RelaxBranches: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		a0        uint32
+		landing   uint32
+		registers RegisterFile
+	}{
+		// The branch is taken, so the inverted branch falls through into the long jump.
+		{0, 8, RegisterFile{1: 0x40000, 8: 1, 10: 3}},
+		// The branch is not taken, so the inverted branch skips the long jump.
+		{1, 0x18, RegisterFile{1: 0, 4: 1, 8: 1, 9: 2}},
+	}
+	for _, test := range tests {
+		emulator := &Emulator{Memory: NewLazyMemory(), Executable: program}
+		emulator.RegisterFile[4] = test.a0
+		for i := 0; i < 2; i++ {
+			if err := emulator.Step(); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if emulator.ProgramCounter != test.landing {
+			t.Errorf("$a0=%d: landed at 0x%x instead of 0x%x", test.a0,
+				emulator.ProgramCounter, test.landing)
+		}
+		for !emulator.Done() {
+			if err := emulator.Step(); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if emulator.ProgramCounter != 0x40008 {
+			t.Errorf("$a0=%d: finished at 0x%x", test.a0, emulator.ProgramCounter)
+		}
+		if emulator.RegisterFile != test.registers {
+			t.Errorf("$a0=%d: unexpected registers: %v", test.a0, emulator.RegisterFile)
+		}
+	}
+}
+
 func runTestProgram(code string) (*Emulator, error) {
 	return runTestProgramEndianness(code, false)
 }
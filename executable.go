@@ -13,67 +13,85 @@ type Executable struct {
 
 	// Symbols maps symbol names to their addresses.
 	Symbols map[string]uint32
+
+	// Entry is the address of the first instruction to execute.
+	Entry uint32
+
+	// LineTable gives the source position of each instruction, if it is known.
+	// It is filled in by ParseExecutable, and is nil for programs which were decoded or linked.
+	LineTable LineTable
 }
 
 // ParseExecutable turns a tokenized source file into an executable blob.
 //
 // If the executable cannot be parsed for any reason, this will fail.
 // Overlapping .text sections, invalid instructions, and repeated symbols will all cause errors.
+// Every branch and jump must refer to a defined symbol or address which it can reach.
+// Every problem is reported, not just the first one: the error is a DiagnosticList.
+//
+// Numeric local labels (e.g. "1:") may be repeated, and are referenced as "1b" (the closest
+// preceding declaration) or "1f" (the closest following declaration).
+// Each declaration appears in the symbol table under a unique name like ".L1.2".
+//
+// Code following a ".set reorder" directive has its branch delay slots filled automatically,
+// until a ".set noreorder" directive is seen.
 func ParseExecutable(lines []TokenizedLine) (*Executable, error) {
-	var segmentStart uint32
-	var instructionAddr uint32
-	res := &Executable{
-		Segments: map[uint32][]Instruction{},
-		Symbols:  map[string]uint32{},
+	return ParseExecutableOptions(lines, ParseOptions{})
+}
+
+// ParseExecutableOptions is like ParseExecutable, but with extra options.
+func ParseExecutableOptions(lines []TokenizedLine, options ParseOptions) (*Executable, error) {
+	p, err := parseExecutable(lines, options)
+	if err != nil {
+		return nil, err
 	}
-	for _, line := range lines {
-		if line.Instruction != nil {
-			parsed, err := ParseTokenizedInstruction(line.Instruction)
-			if err != nil {
-				return nil, errors.New("line " + strconv.Itoa(line.LineNumber) + ": " + err.Error())
-			}
-			if res.addressInUse(instructionAddr) {
-				return nil, addressInUseError(line.LineNumber, instructionAddr)
-			}
-			res.Segments[segmentStart] = append(res.Segments[segmentStart], *parsed)
-			instructionAddr += 4
-		} else if line.Directive != nil {
-			dir := line.Directive
-			if dir.Name == "word" {
-				if res.addressInUse(instructionAddr) {
-					return nil, addressInUseError(line.LineNumber, instructionAddr)
-				}
-				nextInst := DecodeInstruction(dir.Constant)
-				res.Segments[segmentStart] = append(res.Segments[segmentStart], *nextInst)
-				instructionAddr += 4
-			} else if dir.Name == "text" {
-				if dir.Constant&3 != 0 {
-					return nil, errors.New("line " + strconv.Itoa(line.LineNumber) +
-						": misaligned segment")
-				}
-				segmentStart = dir.Constant
-				instructionAddr = dir.Constant
-			} else {
-				return nil, errors.New("line " + strconv.Itoa(line.LineNumber) +
-					": unknown directive: " + dir.Name)
-			}
-		} else if line.SymbolMarker != nil {
-			sym := *line.SymbolMarker
-			if _, ok := res.Symbols[sym]; ok {
-				return nil, errors.New("line " + strconv.Itoa(line.LineNumber) +
-					": repeated symbol declaration: " + sym)
-			}
-			res.Symbols[sym] = instructionAddr
+	return p.res, nil
+}
+
+// parseExecutable runs the parser over a program, and returns the parser so that the details of
+// the finished program can be inspected.
+func parseExecutable(lines []TokenizedLine, options ParseOptions) (*executableParser, error) {
+	relax := newRelaxation()
+	var p *executableParser
+	for {
+		p = newExecutableParser(lines, options, relax)
+		for i := range lines {
+			p.parseLine(&lines[i])
+		}
+		p.flushRelaxTail()
+		p.checkTargets()
+		p.resolveReferences()
+		if !options.RelaxBranches || !relax.nextPass(p.res.Symbols) {
+			break
+		}
+	}
+	if p.diagnostics.HasErrors() {
+		return nil, p.diagnostics
+	}
+	if options.WarningHandler != nil {
+		for _, d := range p.diagnostics {
+			options.WarningHandler(d)
 		}
 	}
-	res.joinContiguousSegments()
-	// TODO: make sure no jump offsets are invalid.
-	return res, nil
+	p.res.joinContiguousSegments()
+	p.res.LineTable = p.lineTable()
+	return p, nil
 }
 
 // Render generates a tokenized source file that corresponds to the given executable.
 // If any the instructions are invalid, this will return an error.
 func (e *Executable) Render() (list []TokenizedLine, err error) {
+	return e.render(false)
+}
+
+// RenderWithOptions is like Render, but it uses the FoldIdioms setting of the options to write
+// common instruction sequences as pseudo-instructions.
+// The other options apply when the lines are formatted with TokenizedLine.Format.
+func (e *Executable) RenderWithOptions(o RenderOptions) (list []TokenizedLine, err error) {
+	return e.render(o.FoldIdioms)
+}
+
+func (e *Executable) render(fold bool) (list []TokenizedLine, err error) {
 	sortedSegments := e.sortedSegmentAddresses()
 	sortedSymbols := e.sortedSymbolAddrPairs()
 
@@ -108,7 +126,11 @@ func (e *Executable) Render() (list []TokenizedLine, err error) {
 			})
 		}
 		currentAddress = segment
-		for _, inst := range e.Segments[segment] {
+		insts := e.Segments[segment]
+		if fold {
+			insts = FoldIdioms(insts, segment, e.Symbols)
+		}
+		for _, inst := range insts {
 			for symbolIdx < len(sortedSymbols) &&
 				sortedSymbols[symbolIdx].Address == currentAddress {
 				sym := sortedSymbols[symbolIdx]
@@ -122,7 +144,7 @@ func (e *Executable) Render() (list []TokenizedLine, err error) {
 					err.Error())
 			}
 			list = append(list, *rendered)
-			currentAddress += 4
+			currentAddress += uint32(len(inst.Expand()) * 4)
 		}
 	}
 
@@ -144,6 +166,58 @@ func (e *Executable) Render() (list []TokenizedLine, err error) {
 	return
 }
 
+// EntryPoint finds where the program starts: e.Entry if there is an instruction there, or else
+// the main or start symbol, or else the lowest address of the program.
+//
+// This is useful for programs which do not set Entry, such as assembly source whose code does
+// not begin at address 0.
+func (e *Executable) EntryPoint() uint32 {
+	if e.Get(e.Entry) != nil {
+		return e.Entry
+	}
+	for _, name := range []string{"main", "start"} {
+		if addr, ok := e.Symbols[name]; ok {
+			return addr
+		}
+	}
+	if addrs := e.sortedSegmentAddresses(); len(addrs) > 0 {
+		return addrs[0]
+	}
+	return e.Entry
+}
+
+// Copy creates a deep copy of the executable, which can be changed without affecting the
+// original.
+func (e *Executable) Copy() *Executable {
+	res := &Executable{
+		Segments: map[uint32][]Instruction{},
+		Symbols:  map[string]uint32{},
+		Entry:    e.Entry,
+	}
+	for start, insts := range e.Segments {
+		newInsts := make([]Instruction, len(insts))
+		for i, inst := range insts {
+			inst.Registers = append([]int(nil), inst.Registers...)
+			if inst.AddressHalf != nil {
+				half := *inst.AddressHalf
+				inst.AddressHalf = &half
+			}
+			newInsts[i] = inst
+		}
+		res.Segments[start] = newInsts
+	}
+	for name, addr := range e.Symbols {
+		res.Symbols[name] = addr
+	}
+	if e.LineTable != nil {
+		res.LineTable = LineTable{}
+		for addr, pos := range e.LineTable {
+			res.LineTable[addr] = pos
+		}
+	}
+	return res
+}
+
 // End returns the pointer to the first byte that is completely past any instruction data.
 // Once a program starts executing instructions at or past End(), no more instructions will be seen.
 func (e *Executable) End() uint32 {
@@ -213,11 +287,6 @@ func (e *Executable) sortedSymbolAddrPairs() symbolAddrPairList {
 	return l
 }
 
-func addressInUseError(line int, addr uint32) error {
-	hexStr := "0x" + strconv.FormatUint(uint64(addr), 16)
-	return errors.New("line " + strconv.Itoa(line) + ": overwriting address " + hexStr)
-}
-
 type uint32List []uint32
 
 func (u uint32List) Len() int {
@@ -244,6 +313,9 @@ func (s symbolAddrPairList) Len() int {
 }
 
 func (s symbolAddrPairList) Less(i, j int) bool {
+	if s[i].Address == s[j].Address {
+		return s[i].Symbol < s[j].Symbol
+	}
 	return s[i].Address < s[j].Address
 }
 
@@ -2,6 +2,7 @@ package mips32
 
 import (
 	"fmt"
+	"strings"
 	"testing"
 )
 
@@ -79,6 +80,55 @@ func TestParseExecutableSuccess(t *testing.T) {
 	}
 }
 
+func TestParseExecutableTargets(t *testing.T) {
+	source := `start: BEQ $t0, $t1, strat
+	J far
+	NOP
+	JAL start
+	BNE $t0, $0, near
+	.text 0x20000
+near: BGEZ $t0, start
+	NOP
+	.text 0x10000000
+far: J start
+	NOP
+	J far
+	NOP`
+	lines, err := TokenizeSource(source)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = ParseExecutable(lines)
+	list, ok := err.(DiagnosticList)
+	if !ok {
+		t.Fatal("expected a DiagnosticList but got", err)
+	}
+	expected := []string{
+		"line 1, column 22: error: undefined symbol: strat",
+		"line 1, column 22: note: did you mean start?",
+		"line 2, column 4: error: jump target outside the current 256MB region: far (0x10000000)",
+		"line 7, column 17: error: branch target out of range: start (0x0) is -131076 bytes " +
+			"from the delay slot",
+		"line 10, column 8: error: jump target outside the current 256MB region: start (0x0)",
+	}
+	var actual []string
+	var errorCount int
+	for _, d := range list {
+		actual = append(actual, strings.Split(d.String(), "\n")...)
+		if d.Severity == SeverityError {
+			errorCount++
+		}
+	}
+	for _, x := range expected {
+		if !containsString(actual, x) {
+			t.Error("missing diagnostic:", x)
+		}
+	}
+	if errorCount != 4 {
+		t.Error("unexpected diagnostics:", list)
+	}
+}
+
 func TestParseExecutableFailure(t *testing.T) {
 	failures := []string{
 		"NOP\n.text 0\nNOP",
@@ -98,6 +148,125 @@ func TestParseExecutableFailure(t *testing.T) {
 	}
 }
 
+func TestParseExecutableLocalLabels(t *testing.T) {
+	code := `
+        1:  ADDIU $t0, $t0, 1
+            BNE $t0, $t1, 1b
+        2:  NOP
+            BEQ $0, $0, 1f
+        1:  NOP
+            J 2b
+        loop: ADDIU $t2, $t2, -1
+        1:  BGTZ $t2, 1b
+            J loop
+    `
+	lines, err := TokenizeSource(code)
+	if err != nil {
+		t.Fatal(err)
+	}
+	executable, err := ParseExecutable(lines)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expectedSymbols := map[string]uint32{
+		".L1.1": 0, ".L2.1": 8, ".L1.2": 0x10, "loop": 0x18, ".L1.3": 0x1c,
+	}
+	if len(executable.Symbols) != len(expectedSymbols) {
+		t.Error("unexpected symbols:", executable.Symbols)
+	}
+	for sym, addr := range expectedSymbols {
+		if actual, ok := executable.Symbols[sym]; !ok || actual != addr {
+			t.Error("unexpected address for", sym, "-", actual)
+		}
+	}
+	expectedTargets := map[uint32]string{
+		4: ".L1.1", 0xc: ".L1.2", 0x14: ".L2.1", 0x1c: ".L1.3", 0x20: "loop",
+	}
+	for addr, target := range expectedTargets {
+		if inst := executable.Get(addr); inst == nil || inst.CodePointer.Symbol != target {
+			t.Error("unexpected target at", addr, "-", inst)
+		}
+	}
+
+	for _, failure := range []string{"1: NOP\nJ 1f", "J 1b\n1: NOP", "J 2f\n1: NOP"} {
+		lines, err := TokenizeSource(failure)
+		if err != nil {
+			t.Error(err)
+			continue
+		}
+		if _, err := ParseExecutable(lines); err == nil {
+			t.Error("expected error for:", failure)
+		}
+	}
+}
+
+func TestParseExecutableReorder(t *testing.T) {
+	code := `
+        .set reorder
+        ADDIU $t0, $t0, 1
+        BNE $t0, $t1, END
+        ADDIU $t2, $t2, 1
+        JAL END
+        ADDIU $t3, $t2, 1
+        JR $t3
+        LW $ra, 4($sp)
+        JR $ra
+        .set noreorder
+        J END
+        ADDIU $t4, $t4, 1
+        END:
+    `
+	withoutHoist := []string{
+		"ADDIU $8, $8, 1", "BNE $8, $9, END", "NOP",
+		"ADDIU $10, $10, 1", "JAL END", "NOP",
+		"ADDIU $11, $10, 1", "JR $11", "NOP",
+		"LW $31, 4($29)", "JR $31", "NOP",
+		"J END", "ADDIU $12, $12, 1",
+	}
+	withHoist := []string{
+		"ADDIU $8, $8, 1", "BNE $8, $9, END", "NOP",
+		"JAL END", "ADDIU $10, $10, 1",
+		"ADDIU $11, $10, 1", "JR $11", "NOP",
+		"LW $31, 4($29)", "JR $31", "NOP",
+		"J END", "ADDIU $12, $12, 1",
+	}
+	lines, err := TokenizeSource(code)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, expected := range [][]string{withoutHoist, withHoist} {
+		executable, err := ParseExecutableOptions(lines, ParseOptions{HoistDelaySlots: i == 1})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if addr := executable.Symbols["END"]; addr != uint32(len(expected)*4) {
+			t.Error("unexpected address for END:", addr)
+		}
+		insts := executable.Segments[0]
+		if len(insts) != len(expected) {
+			t.Error("unexpected number of instructions:", len(insts))
+			continue
+		}
+		for j, inst := range insts {
+			rendered, err := inst.Render()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if rendered.String() != expected[j] {
+				t.Error("hoist", i == 1, "instruction", j, "-", rendered.String())
+			}
+		}
+	}
+
+	lines, err = TokenizeSource(".set bogus")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ParseExecutable(lines); err == nil {
+		t.Error("expected error for unknown .set option")
+	}
+}
+
 func TestExecutableRender(t *testing.T) {
 	programs := []string{
 		`
@@ -154,3 +323,125 @@ func TestExecutableRender(t *testing.T) {
 		}
 	}
 }
+
+func TestParseExecutableRelaxBranches(t *testing.T) {
+	source := `ORI $t0, $0, 1
+	BEQ $t0, $0, never
+	ORI $t1, $0, 2
+	BNE $t0, $0, far
+	ORI $t4, $0, 3
+	ORI $t5, $0, 4
+	.text 0x40000
+never: ORI $t6, $0, 5
+far: ORI $t2, $0, 6
+	.set reorder
+	JAL farther
+	.text 0x10000000
+farther: ORI $t3, $0, 7`
+	lines, err := TokenizeSource(source)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ParseExecutable(lines); err == nil {
+		t.Fatal("expected error without relaxation")
+	}
+	exc, err := ParseExecutableOptions(lines, ParseOptions{RelaxBranches: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(exc.Segments[0]) != 14 {
+		t.Error("unexpected first segment:", exc.Segments[0])
+	}
+	if branch := exc.Segments[0][1]; branch.Name != "BNE" || branch.CodePointer.Constant != 20 {
+		t.Error("inverted branch should skip the jump:", branch)
+	}
+	if exc.Symbols["far"] != 0x40004 || exc.Symbols["farther"] != 0x10000000 {
+		t.Error("unexpected symbols:", exc.Symbols)
+	}
+
+	emulator := &Emulator{Memory: NewLazyMemory(), Executable: exc}
+	for !emulator.Done() {
+		if err := emulator.Step(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	expected := map[int]uint32{8: 1, 9: 2, 10: 6, 11: 7, 12: 3, 13: 0, 14: 0, 31: 0x40018}
+	for reg, value := range expected {
+		if emulator.RegisterFile[reg] != value {
+			t.Errorf("register %d should be %d but got %d", reg, value,
+				emulator.RegisterFile[reg])
+		}
+	}
+}
+
+func TestParseExecutableRelaxCascade(t *testing.T) {
+	// Relaxing the second branch pushes the first one out of range.
+	source := "BEQ $0, $0, edge\nNOP\nBEQ $0, $0, far\nNOP\n" +
+		strings.Repeat("NOP\n", 0x7ffc) + "edge: NOP\n.text 0x100000\nfar: NOP"
+	lines, err := TokenizeSource(source)
+	if err != nil {
+		t.Fatal(err)
+	}
+	exc, err := ParseExecutableOptions(lines, ParseOptions{RelaxBranches: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	insts := exc.Segments[0]
+	if insts[0].Name != "BNE" || insts[6].Name != "BNE" || insts[2].Name != "LUI" {
+		t.Error("unexpected instructions:", insts[:8])
+	}
+	if exc.Symbols["edge"] != 0x20000+32 {
+		t.Errorf("unexpected edge address: 0x%x", exc.Symbols["edge"])
+	}
+}
+
+func TestParseExecutableSymbolReferences(t *testing.T) {
+	source := `LUI $t0, %hi(table)
+	ADDIU $t0, $t0, %lo(table)
+	LW $t1, %lo(table)($0)
+	.text 0x12348000
+table: .word table
+	.word 1f
+1:	.word 0x08000000`
+	lines, err := TokenizeSource(source)
+	if err != nil {
+		t.Fatal(err)
+	}
+	exc, err := ParseExecutable(lines)
+	if err != nil {
+		t.Fatal(err)
+	}
+	insts := exc.Segments[0]
+	if insts[0].UnsignedConstant16 != 0x1235 || insts[1].SignedConstant16 != -0x8000 ||
+		insts[2].MemoryReference.Offset != -0x8000 {
+		t.Error("unexpected instructions:", insts)
+	}
+	for addr, expected := range map[uint32]uint32{
+		0x12348000: 0x12348000,
+		0x12348004: 0x12348008,
+		0x12348008: 0x08000000,
+	} {
+		if word, err := exc.Get(addr).Encode(addr, exc.Symbols); err != nil || word != expected {
+			t.Errorf("word at 0x%x should be 0x%x but got 0x%x (%v)", addr, expected, word, err)
+		}
+	}
+	rendered, err := exc.Render()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rendered[0].String() != "LUI $8, %hi(table)" ||
+		rendered[2].String() != "LW $9, %lo(table)($0)" {
+		t.Error("unexpected rendering:", rendered[0].String(), rendered[2].String())
+	}
+
+	for _, failure := range []string{"LUI $t0, %hi(nothing)", ".word nothing",
+		".section .data", "J %hi(x)"} {
+		lines, err := TokenizeSource(failure)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := ParseExecutable(lines); err == nil {
+			t.Error("expected error for:", failure)
+		}
+	}
+}
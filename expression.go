@@ -0,0 +1,257 @@
+package mips32
+
+import (
+	"errors"
+	"strconv"
+	"strings"
+)
+
+// evaluateExpression evaluates a constant expression like "DEBUG && (LEVEL >= 2)".
+//
+// Expressions may contain integer constants, defined names, the C arithmetic, bitwise, logical
+// and comparison operators, parentheses, and "defined(NAME)" tests.
+// Comparisons and logical operators produce 1 or 0.
+func evaluateExpression(expr string, defines map[string]int64) (int64, error) {
+	tokens, err := tokenizeExpression(expr)
+	if err != nil {
+		return 0, err
+	}
+	parser := &expressionParser{tokens: tokens, defines: defines}
+	res, err := parser.parseBinary(0)
+	if err != nil {
+		return 0, err
+	}
+	if parser.pos != len(parser.tokens) {
+		return 0, errors.New("unexpected token in expression: " + parser.tokens[parser.pos])
+	}
+	return res, nil
+}
+
+// expressionOperators lists the operators in the order they must be tokenized, so that two
+// character operators are found before their one character prefixes.
+var expressionOperators = []string{"||", "&&", "==", "!=", "<=", ">=", "<<", ">>", "|", "^", "&",
+	"<", ">", "+", "-", "*", "/", "%", "!", "~", "(", ")"}
+
+// binaryPrecedence lists binary operators from the loosest to the tightest binding.
+var binaryPrecedence = [][]string{
+	{"||"}, {"&&"}, {"|"}, {"^"}, {"&"}, {"==", "!="}, {"<", "<=", ">", ">="}, {"<<", ">>"},
+	{"+", "-"}, {"*", "/", "%"},
+}
+
+func tokenizeExpression(expr string) ([]string, error) {
+	var tokens []string
+	for i := 0; i < len(expr); {
+		ch := expr[i]
+		if ch == ' ' || ch == '\t' {
+			i++
+			continue
+		}
+		if isIdentifierChar(ch) {
+			start := i
+			for i < len(expr) && isIdentifierChar(expr[i]) {
+				i++
+			}
+			tokens = append(tokens, expr[start:i])
+			continue
+		}
+		found := false
+		for _, op := range expressionOperators {
+			if strings.HasPrefix(expr[i:], op) {
+				tokens = append(tokens, op)
+				i += len(op)
+				found = true
+				break
+			}
+		}
+		if !found {
+			return nil, errors.New("unexpected character in expression: " + string(ch))
+		}
+	}
+	if len(tokens) == 0 {
+		return nil, errors.New("empty expression")
+	}
+	return tokens, nil
+}
+
+func isIdentifierChar(ch byte) bool {
+	return (ch >= 'a' && ch <= 'z') || (ch >= 'A' && ch <= 'Z') || (ch >= '0' && ch <= '9') ||
+		ch == '_'
+}
+
+type expressionParser struct {
+	tokens  []string
+	pos     int
+	defines map[string]int64
+}
+
+func (p *expressionParser) peek() string {
+	if p.pos < len(p.tokens) {
+		return p.tokens[p.pos]
+	}
+	return ""
+}
+
+func (p *expressionParser) next() string {
+	tok := p.peek()
+	if tok != "" {
+		p.pos++
+	}
+	return tok
+}
+
+func (p *expressionParser) expect(tok string) error {
+	if p.next() != tok {
+		return errors.New("expected " + tok + " in expression")
+	}
+	return nil
+}
+
+func (p *expressionParser) parseBinary(level int) (int64, error) {
+	if level == len(binaryPrecedence) {
+		return p.parseUnary()
+	}
+	left, err := p.parseBinary(level + 1)
+	if err != nil {
+		return 0, err
+	}
+	for {
+		op := p.peek()
+		if !containsString(binaryPrecedence[level], op) {
+			return left, nil
+		}
+		p.next()
+		right, err := p.parseBinary(level + 1)
+		if err != nil {
+			return 0, err
+		}
+		left, err = applyBinaryOperator(op, left, right)
+		if err != nil {
+			return 0, err
+		}
+	}
+}
+
+func (p *expressionParser) parseUnary() (int64, error) {
+	switch op := p.peek(); op {
+	case "-", "+", "~", "!":
+		p.next()
+		val, err := p.parseUnary()
+		if err != nil {
+			return 0, err
+		}
+		switch op {
+		case "-":
+			return -val, nil
+		case "~":
+			return ^val, nil
+		case "!":
+			return boolToInt64(val == 0), nil
+		}
+		return val, nil
+	}
+	return p.parsePrimary()
+}
+
+func (p *expressionParser) parsePrimary() (int64, error) {
+	tok := p.next()
+	switch {
+	case tok == "":
+		return 0, errors.New("unexpected end of expression")
+	case tok == "(":
+		val, err := p.parseBinary(0)
+		if err != nil {
+			return 0, err
+		}
+		return val, p.expect(")")
+	case tok == "defined":
+		parens := p.peek() == "("
+		if parens {
+			p.next()
+		}
+		name := p.next()
+		if !symbolRegexp.MatchString(name) || name == "" {
+			return 0, errors.New("expected name after defined")
+		}
+		if parens {
+			if err := p.expect(")"); err != nil {
+				return 0, err
+			}
+		}
+		_, ok := p.defines[name]
+		return boolToInt64(ok), nil
+	case tok[0] >= '0' && tok[0] <= '9':
+		val, err := strconv.ParseInt(tok, 0, 64)
+		if err != nil {
+			return 0, errors.New("invalid number in expression: " + tok)
+		}
+		return val, nil
+	case isIdentifierChar(tok[0]):
+		if val, ok := p.defines[tok]; ok {
+			return val, nil
+		}
+		return 0, errors.New("undefined name in expression: " + tok)
+	}
+	return 0, errors.New("unexpected token in expression: " + tok)
+}
+
+func applyBinaryOperator(op string, left, right int64) (int64, error) {
+	switch op {
+	case "||":
+		return boolToInt64(left != 0 || right != 0), nil
+	case "&&":
+		return boolToInt64(left != 0 && right != 0), nil
+	case "|":
+		return left | right, nil
+	case "^":
+		return left ^ right, nil
+	case "&":
+		return left & right, nil
+	case "==":
+		return boolToInt64(left == right), nil
+	case "!=":
+		return boolToInt64(left != right), nil
+	case "<":
+		return boolToInt64(left < right), nil
+	case "<=":
+		return boolToInt64(left <= right), nil
+	case ">":
+		return boolToInt64(left > right), nil
+	case ">=":
+		return boolToInt64(left >= right), nil
+	case "<<":
+		return left << uint64(right&63), nil
+	case ">>":
+		return left >> uint64(right&63), nil
+	case "+":
+		return left + right, nil
+	case "-":
+		return left - right, nil
+	case "*":
+		return left * right, nil
+	case "/", "%":
+		if right == 0 {
+			return 0, errors.New("division by zero in expression")
+		}
+		if op == "/" {
+			return left / right, nil
+		}
+		return left % right, nil
+	}
+	return 0, errors.New("unknown operator: " + op)
+}
+
+func boolToInt64(b bool) int64 {
+	if b {
+		return 1
+	}
+	return 0
+}
+
+func containsString(list []string, s string) bool {
+	for _, x := range list {
+		if x == s {
+			return true
+		}
+	}
+	return false
+}
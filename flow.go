@@ -0,0 +1,269 @@
+package mips32
+
+import "sort"
+
+// A flowGraph records which instructions of an executable may run after each other, within the
+// functions that contain them.
+//
+// An instruction in a delay slot runs before its branch or jump takes effect, so the only
+// successor of a branch or jump is its delay slot, and the successors of the delay slot are the
+// places which the branch or jump goes to.
+// Calls are not followed: the delay slot of a JAL or JALR leads to the instruction after it, and
+// the target of the call is added to the list of functions.
+type flowGraph struct {
+	// successors maps the address of every reachable instruction to the addresses of the
+	// instructions which may run after it.
+	successors map[uint32][]uint32
+
+	// functions lists the entry point, followed by the targets of calls and any other starts in
+	// the order they were found.
+	functions  []uint32
+	isFunction map[uint32]bool
+
+	// delaySlots maps the address of each reachable delay slot to its branch or jump.
+	delaySlots map[uint32]uint32
+
+	// calls contains the delay slots of calls, after which control returns from a function.
+	calls map[uint32]bool
+
+	// callTargets maps the delay slots of calls to the functions they call, when known.
+	callTargets map[uint32]uint32
+
+	// returns contains the delay slots of jumps which leave a function, either by returning or
+	// by jumping to an address which could not be determined.
+	returns map[uint32]bool
+}
+
+// flowGraph follows the control flow of an executable from its entry point.
+func (e *Executable) flowGraph() *flowGraph {
+	g := &flowGraph{
+		successors:  map[uint32][]uint32{},
+		delaySlots:  map[uint32]uint32{},
+		calls:       map[uint32]bool{},
+		callTargets: map[uint32]uint32{},
+		returns:     map[uint32]bool{},
+		isFunction:  map[uint32]bool{},
+	}
+	g.follow(e, e.Entry)
+	return g
+}
+
+// follow adds a function and everything which it reaches to the graph.
+//
+// The target of a JR or JALR is only known if its register was loaded with a constant (using
+// LUI, ORI and ADDIU) earlier in the same straight-line run of code. A JR to any other target
+// is treated as a return.
+func (g *flowGraph) follow(e *Executable, start uint32) {
+	g.addFunction(start)
+	pending := []uint32{start}
+	for len(pending) > 0 {
+		addr := pending[len(pending)-1]
+		pending = pending[:len(pending)-1]
+		constants := map[int]uint32{0: 0}
+
+		for e.isCode(addr) {
+			if _, visited := g.successors[addr]; visited {
+				break
+			}
+			inst := e.Get(addr)
+			g.addEdge(addr, addr+4)
+			if !inst.HasDelaySlot() {
+				trackConstant(inst, constants)
+				addr += 4
+				continue
+			}
+
+			slot := addr + 4
+			if !e.isCode(slot) {
+				break
+			}
+			g.delaySlots[slot] = addr
+			if _, ok := g.successors[slot]; !ok {
+				g.successors[slot] = nil
+			}
+
+			var target uint32
+			var known bool
+			if inst.Name == "JR" || inst.Name == "JALR" {
+				reg := inst.Registers[len(inst.Registers)-1]
+				target, known = constants[reg]
+				known = known && !(inst.Name == "JR" && reg == 31)
+			} else {
+				target, known = codeTarget(inst, addr, e.Symbols)
+			}
+
+			switch {
+			case inst.Name == "JAL" || inst.Name == "JALR":
+				if known {
+					g.addFunction(target)
+					g.callTargets[slot] = target
+					pending = append(pending, target)
+				}
+				g.calls[slot] = true
+				g.addEdge(slot, addr+8)
+				pending = append(pending, addr+8)
+			case inst.Name == "J" || inst.Name == "JR":
+				if known {
+					g.addEdge(slot, target)
+					pending = append(pending, target)
+				} else {
+					g.returns[slot] = true
+				}
+			default:
+				g.addEdge(slot, target)
+				pending = append(pending, target)
+				if !alwaysBranches(inst) {
+					g.addEdge(slot, addr+8)
+					pending = append(pending, addr+8)
+				}
+			}
+			break
+		}
+	}
+}
+
+// isCode checks if there is an instruction, rather than data, at an address.
+func (e *Executable) isCode(addr uint32) bool {
+	inst := e.Get(addr)
+	return addr&3 == 0 && inst != nil && inst.Name != ".word"
+}
+
+func (g *flowGraph) addFunction(addr uint32) {
+	if !g.isFunction[addr] {
+		g.isFunction[addr] = true
+		g.functions = append(g.functions, addr)
+	}
+}
+
+// addEdge records that the instruction at dest may run after the instruction at source.
+func (g *flowGraph) addEdge(source, dest uint32) {
+	for _, x := range g.successors[source] {
+		if x == dest {
+			return
+		}
+	}
+	g.successors[source] = append(g.successors[source], dest)
+}
+
+// addresses returns the address of every reachable instruction in ascending order.
+func (g *flowGraph) addresses() []uint32 {
+	res := make(uint32List, 0, len(g.successors))
+	for addr := range g.successors {
+		res = append(res, addr)
+	}
+	sort.Sort(res)
+	return res
+}
+
+// predecessors finds the reachable instructions which may run before each instruction.
+func (g *flowGraph) predecessors() map[uint32][]uint32 {
+	res := map[uint32][]uint32{}
+	for _, addr := range g.addresses() {
+		for _, next := range g.successors[addr] {
+			if _, ok := g.successors[next]; ok {
+				res[next] = append(res[next], addr)
+			}
+		}
+	}
+	return res
+}
+
+// function finds the instructions which can be reached from the start of a function without
+// making a call.
+func (g *flowGraph) function(start uint32) map[uint32]bool {
+	res := map[uint32]bool{}
+	pending := []uint32{start}
+	for len(pending) > 0 {
+		addr := pending[len(pending)-1]
+		pending = pending[:len(pending)-1]
+		if _, ok := g.successors[addr]; !ok || res[addr] {
+			continue
+		}
+		res[addr] = true
+		pending = append(pending, g.successors[addr]...)
+	}
+	return res
+}
+
+// A ControlFlow describes which instructions of an executable may run after each other, as
+// followed by Lint, DataFlow and Optimize.
+//
+// The instruction in a delay slot runs after its branch or jump, and before the places which the
+// branch or jump goes to. Calls are not followed within a function: the delay slot of a JAL or
+// JALR leads to the instruction after it, and the function it calls starts a separate function.
+type ControlFlow struct {
+	graph *flowGraph
+}
+
+// ControlFlow follows the control flow of an executable from its entry point into every
+// function it calls.
+//
+// Each of the extra starts which the code found so far does not reach begins another function,
+// in the order they are given. This finds functions which are never called, such as interrupt
+// handlers.
+//
+// The target of a JR or JALR is only known if its register was loaded with a constant (using
+// LUI, ORI and ADDIU) earlier in the same straight-line run of code. A JR to any other target
+// leaves its function, like a return.
+func (e *Executable) ControlFlow(starts ...uint32) *ControlFlow {
+	g := e.flowGraph()
+	for _, start := range starts {
+		if _, reached := g.successors[start]; !reached && e.isCode(start) {
+			g.follow(e, start)
+		}
+	}
+	return &ControlFlow{graph: g}
+}
+
+// Addresses returns the address of every reachable instruction in ascending order.
+func (c *ControlFlow) Addresses() []uint32 {
+	return c.graph.addresses()
+}
+
+// Reached checks if the instruction at an address can be reached.
+func (c *ControlFlow) Reached(addr uint32) bool {
+	_, ok := c.graph.successors[addr]
+	return ok
+}
+
+// Successors returns the addresses of the instructions which may run after the instruction at
+// an address in the same function.
+func (c *ControlFlow) Successors(addr uint32) []uint32 {
+	return append([]uint32(nil), c.graph.successors[addr]...)
+}
+
+// Predecessors finds the reachable instructions which may run before each instruction.
+func (c *ControlFlow) Predecessors() map[uint32][]uint32 {
+	return c.graph.predecessors()
+}
+
+// Functions lists the start of every function: the entry point, then the targets of calls and
+// the extra starts in the order they were found.
+func (c *ControlFlow) Functions() []uint32 {
+	return append([]uint32(nil), c.graph.functions...)
+}
+
+// Function finds the instructions which can be reached from the start of a function without
+// making a call.
+func (c *ControlFlow) Function(start uint32) map[uint32]bool {
+	return c.graph.function(start)
+}
+
+// Branch finds the branch or jump whose delay slot is at an address.
+func (c *ControlFlow) Branch(slot uint32) (addr uint32, ok bool) {
+	addr, ok = c.graph.delaySlots[slot]
+	return
+}
+
+// Call checks if the instruction at an address is the delay slot of a JAL or JALR, and finds
+// the function it calls if that is known.
+func (c *ControlFlow) Call(slot uint32) (isCall bool, target uint32, known bool) {
+	target, known = c.graph.callTargets[slot]
+	return c.graph.calls[slot], target, known
+}
+
+// Exits checks if control leaves a function after the delay slot at an address, either by
+// returning or by jumping to an address which could not be determined.
+func (c *ControlFlow) Exits(slot uint32) bool {
+	return c.graph.returns[slot]
+}
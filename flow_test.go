@@ -0,0 +1,53 @@
+package mips32
+
+import "testing"
+
+func TestControlFlow(t *testing.T) {
+	exc := parseTestExecutable(t, `.text 0x400100
+main:
+	LUI $t0, 0x40
+	ORI $t0, $t0, 0x118
+	JALR $t0
+	NOP
+	JR $t0
+	NOP
+helper:
+	JR $ra
+	NOP
+handler:
+	ADDIU $t1, $0, 1
+	JR $ra
+	NOP`)
+	flow := exc.ControlFlow(exc.Symbols["helper"], exc.Symbols["handler"], 0x400200)
+
+	expectedFunctions := []uint32{0x400100, 0x400118, 0x400120}
+	functions := flow.Functions()
+	if len(functions) != len(expectedFunctions) {
+		t.Fatal("unexpected functions:", functions)
+	}
+	for i, x := range expectedFunctions {
+		if functions[i] != x {
+			t.Fatal("unexpected functions:", functions)
+		}
+	}
+
+	if isCall, target, known := flow.Call(0x40010c); !isCall || !known || target != 0x400118 {
+		t.Error("unexpected call:", isCall, target, known)
+	}
+	// Constants are not tracked across the call, so the JR leaves main.
+	if succ := flow.Successors(0x400114); len(succ) != 0 {
+		t.Error("unexpected JR successors:", succ)
+	}
+	if branch, ok := flow.Branch(0x400114); !ok || branch != 0x400110 {
+		t.Error("unexpected branch:", branch, ok)
+	}
+	if !flow.Exits(0x400114) || !flow.Exits(0x40011c) {
+		t.Error("unexpected exits")
+	}
+	if !flow.Reached(0x400128) || flow.Reached(0x400200) {
+		t.Error("unexpected reachability")
+	}
+	if body := flow.Function(0x400100); len(body) != 6 {
+		t.Error("unexpected function body:", body)
+	}
+}
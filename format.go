@@ -0,0 +1,122 @@
+package mips32
+
+import (
+	"errors"
+	"strings"
+)
+
+// FormatSource lays out the lines of a source file in a canonical style, using the given
+// options to write each instruction and directive.
+//
+// Labels are placed on lines of their own at the start of the line, and every instruction and
+// directive is indented by a tab. Comments stay on the lines they were on, without trailing
+// spaces; a comment on a line of its own keeps its indentation if it had any. Runs of blank
+// lines become a single blank line, and blank lines at the start and end of the file are
+// removed.
+//
+// The lines must come from a single file, as produced by TokenizeSource, since blank lines are
+// found from gaps in the line numbers.
+//
+// Instructions which cannot be parsed are reported in a DiagnosticList, since they cannot be
+// written in a different style. The formatted source is tokenized again to make sure that it
+// means the same thing as the original lines. If it does not, an error is returned.
+func FormatSource(lines []TokenizedLine, o RenderOptions) (string, error) {
+	var diagnostics DiagnosticList
+	for i := range lines {
+		line := &lines[i]
+		if line.Instruction == nil {
+			continue
+		}
+		if _, err := parseSourceInstruction(line.Instruction); err != nil {
+			column, hint := instructionProblem(line.Instruction)
+			diagnostics.addError(line, column, err.Error(), hint)
+		}
+	}
+	if err := diagnostics.Err(); err != nil {
+		return "", err
+	}
+
+	labelOptions := o
+	labelOptions.CommentColumn = 0
+
+	var res []string
+	for i, line := range lines {
+		if i > 0 && line.LineNumber > lines[i-1].LineNumber+1 {
+			res = append(res, "")
+		}
+		line.Comment = trimComment(line.Comment)
+		if line.Directive == nil && line.Instruction == nil {
+			if line.SymbolMarker == nil && startsWithSpace(line.Text) {
+				res = append(res, "\t"+line.Format(labelOptions))
+			} else {
+				res = append(res, line.Format(labelOptions))
+			}
+			continue
+		}
+		if line.SymbolMarker != nil {
+			res = append(res, *line.SymbolMarker+":")
+			line.SymbolMarker = nil
+		}
+		res = append(res, "\t"+line.Format(o))
+	}
+	formatted := strings.Join(res, "\n")
+	if len(res) > 0 {
+		formatted += "\n"
+	}
+
+	reformatted, err := TokenizeSource(formatted)
+	if err != nil {
+		return "", errors.New("formatted source does not tokenize: " + err.Error())
+	}
+	if !sameSourceLines(lines, reformatted) {
+		return "", errors.New("formatted source does not match the original")
+	}
+	return formatted, nil
+}
+
+// sameSourceLines checks if two lists of lines contain the same labels, instructions,
+// directives and comments in the same order, regardless of how they are split into lines.
+func sameSourceLines(lines1, lines2 []TokenizedLine) bool {
+	parts1, parts2 := sourceLineParts(lines1), sourceLineParts(lines2)
+	if len(parts1) != len(parts2) {
+		return false
+	}
+	for i, part := range parts1 {
+		if !part.Equal(&parts2[i]) {
+			return false
+		}
+	}
+	return true
+}
+
+// sourceLineParts splits each line into a label and the rest of the line, and removes the
+// information about where the lines came from.
+func sourceLineParts(lines []TokenizedLine) []TokenizedLine {
+	var res []TokenizedLine
+	for _, line := range lines {
+		if line.SymbolMarker != nil && (line.Directive != nil || line.Instruction != nil) {
+			res = append(res, TokenizedLine{SymbolMarker: line.SymbolMarker})
+			line.SymbolMarker = nil
+		}
+		res = append(res, TokenizedLine{
+			Comment:      trimComment(line.Comment),
+			Directive:    line.Directive,
+			Instruction:  line.Instruction,
+			SymbolMarker: line.SymbolMarker,
+		})
+	}
+	return res
+}
+
+// trimComment removes trailing spaces from a comment.
+func trimComment(comment *string) *string {
+	if comment == nil {
+		return nil
+	}
+	trimmed := strings.TrimRight(*comment, " \t")
+	return &trimmed
+}
+
+func startsWithSpace(s string) bool {
+	return strings.HasPrefix(s, " ") || strings.HasPrefix(s, "\t")
+}
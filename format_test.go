@@ -0,0 +1,80 @@
+package mips32
+
+import "testing"
+
+func TestFormatSource(t *testing.T) {
+	source := `
+
+# Header comment
+.text 0x400100
+main:   addiu $t0,$0,0x10      # count
+loop: ADDIU $8, $8, -1   
+      # inner comment
+    BNE $t0, $zero, loop
+  NOP
+
+
+
+	sw $ra, -8 ( $sp )
+	JR $ra
+	NOP
+table: .word 0x1234   // trailing
+
+`
+	expected := `# Header comment
+	.text 0x400100
+main:
+	ADDIU  $t0, $zero, 0x10   # count
+loop:
+	ADDIU  $t0, $t0, -1
+	# inner comment
+	BNE    $t0, $zero, loop
+	NOP
+
+	SW     $ra, -8($sp)
+	JR     $ra
+	NOP
+table:
+	.word 0x1234              # trailing
+`
+	options := RenderOptions{
+		Registers:         ABIRegisters,
+		PreserveConstants: true,
+		MnemonicWidth:     6,
+		CommentColumn:     27,
+	}
+	lines, err := TokenizeSource(source)
+	if err != nil {
+		t.Fatal(err)
+	}
+	formatted, err := FormatSource(lines, options)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if formatted != expected {
+		t.Fatalf("expected:\n%s\ngot:\n%s", expected, formatted)
+	}
+
+	lines, err = TokenizeSource(formatted)
+	if err != nil {
+		t.Fatal(err)
+	}
+	reformatted, err := FormatSource(lines, options)
+	if err != nil {
+		t.Fatal(err)
+	} else if reformatted != formatted {
+		t.Errorf("formatting is not idempotent:\n%s", reformatted)
+	}
+}
+
+func TestFormatSourceErrors(t *testing.T) {
+	lines, err := TokenizeSource("main: ADDU $t0, $t1\nADDD $t0, $t1, $t2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = FormatSource(lines, RenderOptions{})
+	diagnostics, ok := err.(DiagnosticList)
+	if !ok || len(diagnostics) != 2 || diagnostics[0].Line != 1 || diagnostics[1].Line != 2 {
+		t.Error("unexpected error:", err)
+	}
+}
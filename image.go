@@ -0,0 +1,588 @@
+package mips32
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+	"io/ioutil"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// An Image is a program loaded from a file, such as an ELF executable or an Intel HEX file.
+type Image struct {
+	// Executable contains the decoded instructions and the entry point.
+	// Formats which cannot tell code from data have all of their contents decoded.
+	Executable *Executable
+
+	// Memory contains everything stored in the file at its load address.
+	// Addresses which the file does not mention are zero.
+	Memory *LazyMemory
+
+	// LittleEndian is true if the image stores words in little endian byte order.
+	LittleEndian bool
+}
+
+// An ImageFormat is a file format for assembled programs.
+type ImageFormat int
+
+const (
+	// RawFormat is a flat binary which starts at address 0, so that it can be loaded without
+	// knowing a base address. The space before and between segments is filled with zeros.
+	RawFormat ImageFormat = iota
+
+	// ELFFormat is an ELF32 executable.
+	ELFFormat
+
+	// IHexFormat is an Intel HEX file with 32-bit extended linear addresses.
+	IHexFormat
+
+	// SRecFormat is a Motorola S-record file with 32-bit addresses.
+	SRecFormat
+
+	// MemhFormat is a file for Verilog's $readmemh with one 32-bit word per line.
+	// Addresses are given as word indices (byte addresses divided by four).
+	MemhFormat
+
+	// CArrayFormat is a C source file with a byte array for each segment.
+	CArrayFormat
+)
+
+var imageFormatNames = []string{"raw", "elf", "ihex", "srec", "memh", "c"}
+
+// ParseImageFormat finds the format with a given name: raw, elf, ihex, srec, memh, or c.
+func ParseImageFormat(name string) (ImageFormat, error) {
+	for i, x := range imageFormatNames {
+		if x == name {
+			return ImageFormat(i), nil
+		}
+	}
+	return 0, errors.New("unknown image format: " + name + " (expected one of " +
+		strings.Join(imageFormatNames, ", ") + ")")
+}
+
+func (f ImageFormat) String() string {
+	if int(f) < len(imageFormatNames) {
+		return imageFormatNames[f]
+	}
+	return "ImageFormat(" + strconv.Itoa(int(f)) + ")"
+}
+
+// GuessImageFormat identifies the format of a file from its contents and its extension.
+// It returns false if the file does not look like a program image.
+func GuessImageFormat(name string, header []byte) (ImageFormat, bool) {
+	if bytes.HasPrefix(header, []byte("\x7fELF")) {
+		return ELFFormat, true
+	}
+	switch strings.ToLower(filepath.Ext(name)) {
+	case ".elf":
+		return ELFFormat, true
+	case ".bin":
+		return RawFormat, true
+	case ".hex", ".ihex", ".ihx":
+		return IHexFormat, true
+	case ".srec", ".s19", ".s28", ".s37", ".mot":
+		return SRecFormat, true
+	case ".memh", ".mem":
+		return MemhFormat, true
+	case ".c", ".h":
+		return CArrayFormat, true
+	}
+	return 0, false
+}
+
+// WriteImage writes the instructions of an executable in the given format.
+// Formats other than raw and memh record the entry point.
+//
+// Raw images start at address 0; use WriteRawImage for a program which is loaded elsewhere.
+func WriteImage(w io.Writer, e *Executable, format ImageFormat, littleEndian bool) error {
+	if format == ELFFormat {
+		return WriteELF(w, e, littleEndian)
+	} else if format == RawFormat {
+		return WriteRawImage(w, e, 0, littleEndian)
+	}
+
+	order := imageByteOrder(littleEndian)
+	blocks, err := encodeImageBlocks(e, order)
+	if err != nil {
+		return err
+	}
+
+	buf := bufio.NewWriter(w)
+	switch format {
+	case IHexFormat:
+		writeIHex(buf, blocks, e.Entry)
+	case SRecFormat:
+		writeSRec(buf, blocks, e.Entry)
+	case MemhFormat:
+		writeMemh(buf, blocks, order)
+	case CArrayFormat:
+		writeCArray(buf, blocks, e.Entry)
+	default:
+		return errors.New("unknown image format: " + format.String())
+	}
+	return buf.Flush()
+}
+
+// WriteRawImage writes an executable as a flat binary which starts at a base address, so that
+// LoadRawImage can load it at the same address. The space between segments is filled with
+// zeros, and it is an error for the program to have code below the base address.
+func WriteRawImage(w io.Writer, e *Executable, base uint32, littleEndian bool) error {
+	blocks, err := encodeImageBlocks(e, imageByteOrder(littleEndian))
+	if err != nil {
+		return err
+	}
+	if len(blocks) > 0 && blocks[0].addr < base {
+		return errors.New("code at " + hexAddress(blocks[0].addr) +
+			" is below the base address " + hexAddress(base))
+	}
+	buf := bufio.NewWriter(w)
+	addr := base
+	for _, block := range blocks {
+		buf.Write(make([]byte, block.addr-addr))
+		buf.Write(block.data)
+		addr = block.addr + uint32(len(block.data))
+	}
+	return buf.Flush()
+}
+
+func imageByteOrder(littleEndian bool) binary.ByteOrder {
+	if littleEndian {
+		return binary.LittleEndian
+	}
+	return binary.BigEndian
+}
+
+func encodeImageBlocks(e *Executable, order binary.ByteOrder) ([]imageBlock, error) {
+	var blocks []imageBlock
+	for _, addr := range e.sortedSegmentAddresses() {
+		data, err := encodeSegment(e, addr, order)
+		if err != nil {
+			return nil, err
+		}
+		blocks = append(blocks, imageBlock{addr: addr, data: data})
+	}
+	return blocks, nil
+}
+
+// ReadImage reads a program in the given format.
+//
+// The byte order of an ELF file comes from the file itself; otherwise, littleEndian decides how
+// instructions are decoded (and, for memh files, how words are stored in memory).
+// Raw images are loaded at address 0; use LoadRawImage to load them elsewhere.
+// If the format does not record an entry point, the lowest address in the image is used.
+func ReadImage(r io.Reader, format ImageFormat, littleEndian bool) (*Image, error) {
+	data, err := ioutil.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	var blocks []imageBlock
+	var entry *uint32
+	switch format {
+	case ELFFormat:
+		return LoadELF(bytes.NewReader(data))
+	case RawFormat:
+		return LoadRawImage(data, 0, littleEndian), nil
+	case IHexFormat:
+		blocks, entry, err = readIHex(string(data))
+	case SRecFormat:
+		blocks, entry, err = readSRec(string(data))
+	case MemhFormat:
+		blocks, err = readMemh(string(data), littleEndian)
+	case CArrayFormat:
+		blocks, entry, err = readCArray(string(data))
+	default:
+		return nil, errors.New("unknown image format: " + format.String())
+	}
+	if err != nil {
+		return nil, err
+	}
+	return newImage(blocks, entry, littleEndian), nil
+}
+
+// LoadRawImage loads a flat binary at the given base address.
+// Every word is decoded as an instruction, and the entry point is the base address.
+func LoadRawImage(data []byte, base uint32, littleEndian bool) *Image {
+	return newImage([]imageBlock{{addr: base, data: data}}, nil, littleEndian)
+}
+
+// An imageBlock is a run of bytes at a given address.
+type imageBlock struct {
+	addr uint32
+	data []byte
+}
+
+// newImage loads blocks into memory and decodes every word they touch as an instruction.
+func newImage(blocks []imageBlock, entry *uint32, littleEndian bool) *Image {
+	res := &Image{
+		Executable: &Executable{
+			Segments: map[uint32][]Instruction{},
+			Symbols:  map[string]uint32{},
+		},
+		Memory:       NewLazyMemory(),
+		LittleEndian: littleEndian,
+	}
+
+	// Each range is the word-aligned [start, end) of a block, and overlapping ranges are merged.
+	type wordRange struct {
+		start, end uint64
+	}
+	var ranges []wordRange
+	for _, block := range blocks {
+		for i, b := range block.data {
+			res.Memory.Set(block.addr+uint32(i), b)
+		}
+		if len(block.data) > 0 {
+			ranges = append(ranges, wordRange{
+				start: uint64(block.addr &^ 3),
+				end:   (uint64(block.addr) + uint64(len(block.data)) + 3) &^ 3,
+			})
+		}
+	}
+	sort.Slice(ranges, func(i, j int) bool {
+		return ranges[i].start < ranges[j].start
+	})
+	for i := 0; i < len(ranges); i++ {
+		r := ranges[i]
+		for i+1 < len(ranges) && ranges[i+1].start <= r.end {
+			if ranges[i+1].end > r.end {
+				r.end = ranges[i+1].end
+			}
+			i++
+		}
+		var insts []Instruction
+		for addr := r.start; addr < r.end; addr += 4 {
+			word := memoryWord(res.Memory, uint32(addr), littleEndian)
+			insts = append(insts, *decodeInstructionAt(word, uint32(addr)))
+		}
+		res.Executable.Segments[uint32(r.start)] = insts
+	}
+
+	if entry != nil {
+		res.Executable.Entry = *entry
+	} else if len(ranges) > 0 {
+		res.Executable.Entry = uint32(ranges[0].start)
+	}
+	return res
+}
+
+func memoryWord(m Memory, addr uint32, littleEndian bool) uint32 {
+	var res uint32
+	for i := uint32(0); i < 4; i++ {
+		if littleEndian {
+			res |= uint32(m.Get(addr+i)) << (8 * i)
+		} else {
+			res |= uint32(m.Get(addr+i)) << (8 * (3 - i))
+		}
+	}
+	return res
+}
+
+// imageRecordSize is the number of data bytes in each Intel HEX or S-record line.
+const imageRecordSize = 16
+
+func writeIHex(w *bufio.Writer, blocks []imageBlock, entry uint32) {
+	var upper uint32
+	for _, block := range blocks {
+		for i := 0; i < len(block.data); {
+			addr := block.addr + uint32(i)
+			if addr>>16 != upper {
+				upper = addr >> 16
+				writeIHexRecord(w, 4, 0, []byte{byte(upper >> 8), byte(upper)})
+			}
+			// Records may not cross a 64KB boundary.
+			size := imageRecordSize
+			if remaining := 0x10000 - int(addr&0xffff); remaining < size {
+				size = remaining
+			}
+			if remaining := len(block.data) - i; remaining < size {
+				size = remaining
+			}
+			writeIHexRecord(w, 0, uint16(addr), block.data[i:i+size])
+			i += size
+		}
+	}
+	writeIHexRecord(w, 5, 0, []byte{byte(entry >> 24), byte(entry >> 16), byte(entry >> 8),
+		byte(entry)})
+	writeIHexRecord(w, 1, 0, nil)
+}
+
+func writeIHexRecord(w *bufio.Writer, recordType byte, addr uint16, data []byte) {
+	record := append([]byte{byte(len(data)), byte(addr >> 8), byte(addr), recordType}, data...)
+	var sum byte
+	for _, b := range record {
+		sum += b
+	}
+	w.WriteString(":" + strings.ToUpper(hexBytes(append(record, -sum))) + "\n")
+}
+
+func readIHex(source string) ([]imageBlock, *uint32, error) {
+	var blocks []imageBlock
+	var entry *uint32
+	var base uint32
+	for i, line := range strings.Split(source, "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" {
+			continue
+		}
+		lineError := func(message string) error {
+			return errors.New("line " + strconv.Itoa(i+1) + ": " + message)
+		}
+		if line[0] != ':' {
+			return nil, nil, lineError("expected ':' at start of record")
+		}
+		record, err := parseHexBytes(line[1:])
+		if err != nil || len(record) < 5 || int(record[0]) != len(record)-5 {
+			return nil, nil, lineError("malformed record")
+		}
+		var sum byte
+		for _, b := range record {
+			sum += b
+		}
+		if sum != 0 {
+			return nil, nil, lineError("bad checksum")
+		}
+		addr := uint32(record[1])<<8 | uint32(record[2])
+		data := record[4 : len(record)-1]
+		switch record[3] {
+		case 0:
+			blocks = append(blocks, imageBlock{addr: base + addr, data: data})
+		case 1:
+			return blocks, entry, nil
+		case 2, 4:
+			if len(data) != 2 {
+				return nil, nil, lineError("malformed extended address record")
+			}
+			base = uint32(data[0])<<8 | uint32(data[1])
+			if record[3] == 2 {
+				base <<= 4
+			} else {
+				base <<= 16
+			}
+		case 3, 5:
+			if len(data) != 4 {
+				return nil, nil, lineError("malformed start address record")
+			}
+			value := binary.BigEndian.Uint32(data)
+			if record[3] == 3 {
+				value = (value>>16)<<4 + value&0xffff
+			}
+			entry = &value
+		default:
+			return nil, nil, lineError("unknown record type: " + strconv.Itoa(int(record[3])))
+		}
+	}
+	return nil, nil, errors.New("missing end of file record")
+}
+
+func writeSRec(w *bufio.Writer, blocks []imageBlock, entry uint32) {
+	writeSRecRecord(w, '0', 0, 2, []byte("mips32"))
+	for _, block := range blocks {
+		for i := 0; i < len(block.data); i += imageRecordSize {
+			end := i + imageRecordSize
+			if end > len(block.data) {
+				end = len(block.data)
+			}
+			writeSRecRecord(w, '3', block.addr+uint32(i), 4, block.data[i:end])
+		}
+	}
+	writeSRecRecord(w, '7', entry, 4, nil)
+}
+
+func writeSRecRecord(w *bufio.Writer, recordType byte, addr uint32, addrSize int, data []byte) {
+	record := []byte{byte(addrSize + len(data) + 1)}
+	for i := addrSize - 1; i >= 0; i-- {
+		record = append(record, byte(addr>>uint(8*i)))
+	}
+	record = append(record, data...)
+	var sum byte
+	for _, b := range record {
+		sum += b
+	}
+	w.WriteString("S" + string(recordType) + strings.ToUpper(hexBytes(append(record, ^sum))) +
+		"\n")
+}
+
+func readSRec(source string) ([]imageBlock, *uint32, error) {
+	var blocks []imageBlock
+	var entry *uint32
+	for i, line := range strings.Split(source, "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" {
+			continue
+		}
+		lineError := func(message string) error {
+			return errors.New("line " + strconv.Itoa(i+1) + ": " + message)
+		}
+		if len(line) < 2 || line[0] != 'S' || line[1] < '0' || line[1] > '9' {
+			return nil, nil, lineError("expected S-record type")
+		}
+		record, err := parseHexBytes(line[2:])
+		if err != nil || len(record) < 2 || int(record[0]) != len(record)-1 {
+			return nil, nil, lineError("malformed record")
+		}
+		var sum byte
+		for _, b := range record {
+			sum += b
+		}
+		if sum != 0xff {
+			return nil, nil, lineError("bad checksum")
+		}
+		addrSize := map[byte]int{'0': 2, '1': 2, '2': 3, '3': 4, '5': 2, '6': 3, '7': 4, '8': 3,
+			'9': 2}[line[1]]
+		if addrSize == 0 || len(record) < addrSize+2 {
+			return nil, nil, lineError("malformed record")
+		}
+		var addr uint32
+		for _, b := range record[1 : 1+addrSize] {
+			addr = addr<<8 | uint32(b)
+		}
+		data := record[1+addrSize : len(record)-1]
+		switch line[1] {
+		case '1', '2', '3':
+			blocks = append(blocks, imageBlock{addr: addr, data: data})
+		case '7', '8', '9':
+			entry = &addr
+		}
+	}
+	return blocks, entry, nil
+}
+
+func writeMemh(w *bufio.Writer, blocks []imageBlock, order binary.ByteOrder) {
+	for _, block := range blocks {
+		w.WriteString("@" + hexWord(block.addr>>2) + "\n")
+		for i := 0; i < len(block.data); i += 4 {
+			w.WriteString(hexWord(order.Uint32(block.data[i:])) + "\n")
+		}
+	}
+}
+
+func readMemh(source string, littleEndian bool) ([]imageBlock, error) {
+	order := imageByteOrder(littleEndian)
+	var blocks []imageBlock
+	var current *imageBlock
+	for i, line := range strings.Split(source, "\n") {
+		if idx := strings.Index(line, "//"); idx >= 0 {
+			line = line[:idx]
+		}
+		for _, field := range strings.Fields(line) {
+			lineError := func(message string) error {
+				return errors.New("line " + strconv.Itoa(i+1) + ": " + message)
+			}
+			if field[0] == '@' {
+				index, err := strconv.ParseUint(field[1:], 16, 30)
+				if err != nil {
+					return nil, lineError("invalid address: " + field)
+				}
+				blocks = append(blocks, imageBlock{addr: uint32(index) << 2})
+				current = &blocks[len(blocks)-1]
+				continue
+			}
+			word, err := strconv.ParseUint(strings.Replace(field, "_", "", -1), 16, 32)
+			if err != nil {
+				return nil, lineError("invalid word: " + field)
+			}
+			if current == nil {
+				blocks = append(blocks, imageBlock{})
+				current = &blocks[len(blocks)-1]
+			}
+			var encoded [4]byte
+			order.PutUint32(encoded[:], uint32(word))
+			current.data = append(current.data, encoded[:]...)
+		}
+	}
+	return blocks, nil
+}
+
+var (
+	cArrayEntryRegexp   = regexp.MustCompile(`mips_entry\s*=\s*0x([0-9a-fA-F]+)`)
+	cArraySegmentRegexp = regexp.MustCompile(`mips_segment_([0-9a-fA-F]{8})\s*\[[0-9]*\]\s*=\s*` +
+		`\{([^}]*)\}`)
+)
+
+func writeCArray(w *bufio.Writer, blocks []imageBlock, entry uint32) {
+	w.WriteString("#include <stdint.h>\n\nconst uint32_t mips_entry = 0x" + hexWord(entry) + ";\n")
+	for _, block := range blocks {
+		w.WriteString("\nconst uint8_t mips_segment_" + hexWord(block.addr) + "[" +
+			strconv.Itoa(len(block.data)) + "] = {\n")
+		for i := 0; i < len(block.data); i += imageRecordSize {
+			w.WriteString("\t")
+			for j := i; j < i+imageRecordSize && j < len(block.data); j++ {
+				if j > i {
+					w.WriteString(" ")
+				}
+				w.WriteString("0x" + hexBytes(block.data[j:j+1]) + ",")
+			}
+			w.WriteString("\n")
+		}
+		w.WriteString("};\n")
+	}
+}
+
+func readCArray(source string) ([]imageBlock, *uint32, error) {
+	var entry *uint32
+	if match := cArrayEntryRegexp.FindStringSubmatch(source); match != nil {
+		value, err := strconv.ParseUint(match[1], 16, 32)
+		if err != nil {
+			return nil, nil, errors.New("invalid entry point: 0x" + match[1])
+		}
+		entry = new(uint32)
+		*entry = uint32(value)
+	}
+	var blocks []imageBlock
+	for _, match := range cArraySegmentRegexp.FindAllStringSubmatch(source, -1) {
+		addr, _ := strconv.ParseUint(match[1], 16, 32)
+		block := imageBlock{addr: uint32(addr)}
+		for _, item := range strings.Split(match[2], ",") {
+			item = strings.TrimSpace(item)
+			if item == "" {
+				continue
+			}
+			value, err := strconv.ParseUint(item, 0, 8)
+			if err != nil {
+				return nil, nil, errors.New("invalid byte in mips_segment_" + match[1] + ": " +
+					item)
+			}
+			block.data = append(block.data, byte(value))
+		}
+		blocks = append(blocks, block)
+	}
+	if len(blocks) == 0 {
+		return nil, nil, errors.New("no mips_segment_ arrays found")
+	}
+	return blocks, entry, nil
+}
+
+func hexBytes(data []byte) string {
+	const digits = "0123456789abcdef"
+	res := make([]byte, len(data)*2)
+	for i, b := range data {
+		res[i*2] = digits[b>>4]
+		res[i*2+1] = digits[b&0xf]
+	}
+	return string(res)
+}
+
+func hexWord(word uint32) string {
+	return hexBytes([]byte{byte(word >> 24), byte(word >> 16), byte(word >> 8), byte(word)})
+}
+
+func parseHexBytes(s string) ([]byte, error) {
+	if len(s)%2 != 0 {
+		return nil, errors.New("odd number of hex digits")
+	}
+	res := make([]byte, len(s)/2)
+	for i := range res {
+		b, err := strconv.ParseUint(s[i*2:i*2+2], 16, 8)
+		if err != nil {
+			return nil, err
+		}
+		res[i] = byte(b)
+	}
+	return res, nil
+}
@@ -0,0 +1,175 @@
+package mips32
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestImageRoundTrip(t *testing.T) {
+	lines, err := TokenizeSource(`.text 0x8000fff8
+start: ORI $t0, $0, 1
+	ORI $t1, $0, 2
+	J done
+	NOP
+.text 0x80100000
+done: JR $ra
+	NOP`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	exc, err := ParseExecutable(lines)
+	if err != nil {
+		t.Fatal(err)
+	}
+	exc.Entry = 0x8000fff8
+
+	for format := RawFormat; format <= CArrayFormat; format++ {
+		for _, little := range []bool{false, true} {
+			var buf bytes.Buffer
+			if format == RawFormat {
+				err = WriteRawImage(&buf, exc, 0x8000fff8, little)
+			} else {
+				err = WriteImage(&buf, exc, format, little)
+			}
+			if err != nil {
+				t.Fatal(format, err)
+			}
+			image, err := ReadImage(&buf, format, little)
+			if err != nil {
+				t.Error(format, err)
+				continue
+			}
+			base := uint32(0)
+			if format == RawFormat {
+				// The raw image starts at its base address, but is loaded at 0.
+				base = 0x8000fff8
+			}
+			for _, addr := range []uint32{0x8000fff8, 0x80010004, 0x80100000} {
+				expected, _ := exc.Get(addr).Encode(addr, exc.Symbols)
+				inst := image.Executable.Get(addr - base)
+				if inst == nil {
+					t.Errorf("%s: missing instruction at %08x", format, addr)
+					continue
+				}
+				actual, _ := inst.Encode(addr-base, image.Executable.Symbols)
+				if format != RawFormat && actual != expected {
+					t.Errorf("%s: expected %08x but got %08x", format, expected, actual)
+				}
+				if memoryWord(image.Memory, addr-base, little) != actual {
+					t.Errorf("%s: memory does not match instruction at %08x", format, addr)
+				}
+			}
+			if format == IHexFormat || format == SRecFormat || format == CArrayFormat ||
+				format == ELFFormat {
+				if image.Executable.Entry != exc.Entry {
+					t.Errorf("%s: unexpected entry %08x", format, image.Executable.Entry)
+				}
+			}
+		}
+	}
+}
+
+func TestWriteIHex(t *testing.T) {
+	exc := &Executable{
+		Segments: map[uint32][]Instruction{0x1fffc: {{Name: "NOP"}, {Name: "NOP"}}},
+		Entry:    0x1fffc,
+	}
+	var buf bytes.Buffer
+	if err := WriteImage(&buf, exc, IHexFormat, false); err != nil {
+		t.Fatal(err)
+	}
+	expected := []string{
+		":020000040001F9",
+		":04FFFC000000000001",
+		":020000040002F8",
+		":0400000000000000FC",
+		":040000050001FFFCFB",
+		":00000001FF",
+	}
+	if actual := strings.TrimSpace(buf.String()); actual != strings.Join(expected, "\n") {
+		t.Errorf("unexpected output:\n%s", actual)
+	}
+}
+
+func TestReadImageErrors(t *testing.T) {
+	failures := []struct {
+		format ImageFormat
+		source string
+	}{
+		{IHexFormat, ":0400000000000000FD\n:00000001FF"},
+		{IHexFormat, ":0400000000000000FC"},
+		{IHexFormat, "0400000000000000FC\n:00000001FF"},
+		{SRecFormat, "S30900000000000000F5"},
+		{SRecFormat, "X30900000000000000F6"},
+		{MemhFormat, "@0\nxyz"},
+		{CArrayFormat, "int x = 3;"},
+	}
+	for _, failure := range failures {
+		_, err := ReadImage(strings.NewReader(failure.source), failure.format, false)
+		if err == nil {
+			t.Error("expected error for", failure.format, "-", failure.source)
+		}
+	}
+}
+
+func TestGuessImageFormat(t *testing.T) {
+	tests := map[string]ImageFormat{
+		"a.bin": RawFormat, "a.HEX": IHexFormat, "a.s19": SRecFormat, "a.memh": MemhFormat,
+		"a.c": CArrayFormat, "a.elf": ELFFormat,
+	}
+	for name, expected := range tests {
+		if actual, ok := GuessImageFormat(name, nil); !ok || actual != expected {
+			t.Error("unexpected format for", name, "-", actual)
+		}
+	}
+	if actual, ok := GuessImageFormat("prog", []byte("\x7fELF\x01")); !ok || actual != ELFFormat {
+		t.Error("expected ELF format from magic number")
+	}
+	if _, ok := GuessImageFormat("prog.s", []byte("NOP")); ok {
+		t.Error("assembly should not be an image")
+	}
+}
+
+func TestLoadRawImage(t *testing.T) {
+	data := []byte{0x05, 0x00, 0x08, 0x34, 0x00, 0x00, 0x00, 0x00, 0x02}
+	image := LoadRawImage(data, 0xbfc00000, true)
+	if image.Executable.Entry != 0xbfc00000 || len(image.Executable.Segments[0xbfc00000]) != 3 {
+		t.Fatal("unexpected executable:", image.Executable)
+	}
+	if inst := image.Executable.Get(0xbfc00000); inst.Name != "ORI" || inst.UnsignedConstant16 != 5 {
+		t.Error("unexpected first instruction:", inst)
+	}
+	if image.Memory.Get(0xbfc00008) != 2 || image.Memory.Get(0xbfc00009) != 0 {
+		t.Error("unexpected memory contents")
+	}
+}
+
+func TestWriteRawImage(t *testing.T) {
+	exc := &Executable{
+		Segments: map[uint32][]Instruction{
+			0x8:  {{Name: "ORI", Registers: []int{8, 0}, UnsignedConstant16: 5}},
+			0x10: {{Name: "NOP"}},
+		},
+	}
+	var buf bytes.Buffer
+	if err := WriteImage(&buf, exc, RawFormat, false); err != nil {
+		t.Fatal(err)
+	}
+	expected := []byte{0, 0, 0, 0, 0, 0, 0, 0, 0x34, 0x08, 0x00, 0x05, 0, 0, 0, 0, 0, 0, 0, 0}
+	if !bytes.Equal(buf.Bytes(), expected) {
+		t.Errorf("unexpected image from 0: %x", buf.Bytes())
+	}
+
+	buf.Reset()
+	if err := WriteRawImage(&buf, exc, 0x8, false); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(buf.Bytes(), expected[8:]) {
+		t.Errorf("unexpected image from 0x8: %x", buf.Bytes())
+	}
+
+	if err := WriteRawImage(&buf, exc, 0xc, false); err == nil {
+		t.Error("expected error for code below the base address")
+	}
+}
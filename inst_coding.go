@@ -345,6 +345,17 @@ func instructionJumpBase(inst *Instruction, instAddr uint32,
 	}
 }
 
+// decodeInstructionAt is like DecodeInstruction, but it knows the address of the instruction.
+// This gives the target of a J or JAL instruction the same top four bits as its delay slot, so
+// that it can be encoded again at the same address.
+func decodeInstructionAt(word, addr uint32) *Instruction {
+	res := DecodeInstruction(word)
+	if res.Name == "J" || res.Name == "JAL" {
+		res.CodePointer.Constant |= (addr + 4) & 0xf0000000
+	}
+	return res
+}
+
 func numberForInstruction(m map[uint32]string, inst string) (uint32, bool) {
 	for number, name := range m {
 		if name == inst {
@@ -21,6 +21,14 @@ type Instruction struct {
 	CodePointer        CodePointer
 	MemoryReference    MemoryReference
 
+	// AddressHalf is set if the 16-bit constant or memory offset was given as "%hi(symbol)" or
+	// "%lo(symbol)". The constant or offset holds the resolved value once the symbol's address
+	// is known.
+	AddressHalf *AddressHalf
+
+	// Constant32 is only used by the LI pseudo-instruction.
+	Constant32 uint32
+
 	// RawWord is only used for instructions which cannot be decoded.
 	// This is only used when Name is set to ".word"
 	RawWord uint32
@@ -28,9 +36,12 @@ type Instruction struct {
 
 // ParseTokenizedInstruction generates an Instruction which represents a TokenizedInstruction.
 // This may fail if the instruction is invalid, in which case an error is returned.
+//
+// Pseudo-instructions are parsed as well, so that rendered executables can be read back, but
+// the assembler does not accept them.
 func ParseTokenizedInstruction(t *TokenizedInstruction) (*Instruction, error) {
 	validName := false
-	for _, template := range Templates {
+	for _, template := range allTemplates {
 		if template.Name == t.Name {
 			validName = true
 		}
@@ -38,6 +49,9 @@ func ParseTokenizedInstruction(t *TokenizedInstruction) (*Instruction, error) {
 			res := &Instruction{Name: t.Name}
 			for i, arg := range template.Arguments {
 				tokArg := t.Arguments[i]
+				if half, ok := tokArg.AddressHalf(); ok {
+					res.AddressHalf = &half
+				}
 				switch arg {
 				case Register:
 					reg, _ := tokArg.Register()
@@ -54,6 +68,8 @@ func ParseTokenizedInstruction(t *TokenizedInstruction) (*Instruction, error) {
 					res.CodePointer, _ = tokArg.RelativeCodePointer()
 				case MemoryAddress:
 					res.MemoryReference, _ = tokArg.MemoryReference()
+				case Constant32:
+					res.Constant32, _ = tokArg.Constant32()
 				}
 			}
 			return res, nil
@@ -81,10 +97,76 @@ func (i *Instruction) Render() (*TokenizedLine, error) {
 			},
 		}, nil
 	}
-	found := false
+	template, err := i.template()
+	if err != nil {
+		return nil, err
+	}
+	res := &TokenizedInstruction{
+		Name:      i.Name,
+		Arguments: make([]*ArgToken, len(template.Arguments)),
+	}
+	regIndex := 0
+	for argIndex, arg := range template.Arguments {
+		switch arg {
+		case Register:
+			res.Arguments[argIndex] = &ArgToken{
+				isRegister: true,
+				register:   i.Registers[regIndex],
+			}
+			regIndex++
+		case SignedConstant16:
+			res.Arguments[argIndex] = &ArgToken{
+				isConstant: true,
+				constant:   uint32(i.SignedConstant16),
+			}
+		case UnsignedConstant16:
+			res.Arguments[argIndex] = &ArgToken{
+				isConstant: true,
+				constant:   uint32(i.UnsignedConstant16),
+			}
+		case Constant5:
+			res.Arguments[argIndex] = &ArgToken{
+				isConstant: true,
+				constant:   uint32(i.Constant5),
+			}
+		case AbsoluteCodePointer, RelativeCodePointer:
+			if i.CodePointer.IsSymbol {
+				res.Arguments[argIndex] = &ArgToken{
+					isSymbol: true,
+					symbol:   i.CodePointer.Symbol,
+				}
+			} else {
+				res.Arguments[argIndex] = &ArgToken{
+					isConstant: true,
+					constant:   i.CodePointer.Constant,
+				}
+			}
+		case MemoryAddress:
+			res.Arguments[argIndex] = &ArgToken{
+				isMemory:    true,
+				memOffset:   i.MemoryReference.Offset,
+				memRegister: i.MemoryReference.Register,
+			}
+		case Constant32:
+			res.Arguments[argIndex] = &ArgToken{
+				isConstant: true,
+				constant:   i.Constant32,
+			}
+		}
+		if i.AddressHalf != nil && (arg == SignedConstant16 ||
+			arg == UnsignedConstant16 || arg == MemoryAddress) {
+			res.Arguments[argIndex].isHalf = true
+			res.Arguments[argIndex].half = *i.AddressHalf
+		}
+	}
+	return &TokenizedLine{Instruction: res}, nil
+}
 
-TemplateLoop:
-	for _, template := range Templates {
+// template finds the template which describes the instruction's arguments.
+func (i *Instruction) template() (*Template, error) {
+	found := false
+	for j := range allTemplates {
+		template := &allTemplates[j]
 		if template.Name != i.Name {
 			continue
 		}
@@ -92,58 +174,16 @@ TemplateLoop:
 		if template.RegisterCount() != len(i.Registers) {
 			continue
 		}
-		res := &TokenizedInstruction{
-			Name:      i.Name,
-			Arguments: make([]*ArgToken, len(template.Arguments)),
-		}
-		regIndex := 0
-		for argIndex, arg := range template.Arguments {
-			switch arg {
-			case Register:
-				res.Arguments[argIndex] = &ArgToken{
-					isRegister: true,
-					register:   i.Registers[regIndex],
-				}
-				regIndex++
-			case SignedConstant16:
-				res.Arguments[argIndex] = &ArgToken{
-					isConstant: true,
-					constant:   uint32(i.SignedConstant16),
-				}
-			case UnsignedConstant16:
-				res.Arguments[argIndex] = &ArgToken{
-					isConstant: true,
-					constant:   uint32(i.UnsignedConstant16),
-				}
-			case Constant5:
-				res.Arguments[argIndex] = &ArgToken{
-					isConstant: true,
-					constant:   uint32(i.Constant5),
-				}
-			case AbsoluteCodePointer, RelativeCodePointer:
-				if i.CodePointer.Absolute != (arg == AbsoluteCodePointer) {
-					continue TemplateLoop
-				}
-				if i.CodePointer.IsSymbol {
-					res.Arguments[argIndex] = &ArgToken{
-						isSymbol: true,
-						symbol:   i.CodePointer.Symbol,
-					}
-				} else {
-					res.Arguments[argIndex] = &ArgToken{
-						isConstant: true,
-						constant:   i.CodePointer.Constant,
-					}
-				}
-			case MemoryAddress:
-				res.Arguments[argIndex] = &ArgToken{
-					isMemory:    true,
-					memOffset:   i.MemoryReference.Offset,
-					memRegister: i.MemoryReference.Register,
-				}
+		matches := true
+		for _, arg := range template.Arguments {
+			if (arg == AbsoluteCodePointer || arg == RelativeCodePointer) &&
+				i.CodePointer.Absolute != (arg == AbsoluteCodePointer) {
+				matches = false
 			}
 		}
-		return &TokenizedLine{Instruction: res}, nil
+		if matches {
+			return template, nil
+		}
 	}
 	if found {
 		return nil, errors.New("invalid arguments for " + i.Name)
@@ -151,3 +191,81 @@ TemplateLoop:
 		return nil, errors.New("no such instruction: " + i.Name)
 	}
 }
+
+// setAddressHalfValue sets the 16-bit constant or memory offset which AddressHalf refers to.
+func (i *Instruction) setAddressHalfValue(value uint16) {
+	switch i.Name {
+	case "LB", "LBU", "LW", "SB", "SW":
+		i.MemoryReference.Offset = int16(value)
+	default:
+		i.SignedConstant16 = int16(value)
+		i.UnsignedConstant16 = value
+	}
+}
+
+// IsBranch returns true if this is a conditional branch, which uses a relative code pointer.
+func (i *Instruction) IsBranch() bool {
+	switch i.Name {
+	case "BEQ", "BGEZ", "BGTZ", "BLEZ", "BLTZ", "BNE":
+		return true
+	}
+	return false
+}
+
+// IsJump returns true if this is an unconditional jump.
+func (i *Instruction) IsJump() bool {
+	switch i.Name {
+	case "J", "JAL", "JALR", "JR":
+		return true
+	}
+	return false
+}
+
+// HasDelaySlot returns true if this is a branch or jump, in which case the instruction after it
+// is executed before the branch or jump takes effect.
+func (i *Instruction) HasDelaySlot() bool {
+	return i.IsBranch() || i.IsJump()
+}
+
+// ReadRegisters returns the indices of the registers whose values this instruction uses.
+func (i *Instruction) ReadRegisters() []int {
+	switch i.Name {
+	case "ADDIU", "ANDI", "ORI", "XORI", "SLTI", "SLTIU", "SLL", "SRA", "SRL":
+		return []int{i.Registers[1]}
+	case "ADDU", "AND", "NOR", "OR", "SUBU", "XOR", "SLT", "SLTU", "SLLV", "SRAV", "SRLV":
+		return []int{i.Registers[1], i.Registers[2]}
+	case "MOVN", "MOVZ":
+		// The destination is kept when the move does not happen.
+		return []int{i.Registers[1], i.Registers[2], i.Registers[0]}
+	case "BEQ", "BNE":
+		return []int{i.Registers[0], i.Registers[1]}
+	case "BGEZ", "BGTZ", "BLEZ", "BLTZ", "JR":
+		return []int{i.Registers[0]}
+	case "JALR":
+		return []int{i.Registers[len(i.Registers)-1]}
+	case "LB", "LBU", "LW":
+		return []int{i.MemoryReference.Register}
+	case "SB", "SW":
+		return []int{i.Registers[0], i.MemoryReference.Register}
+	}
+	return nil
+}
+
+// WrittenRegisters returns the indices of the registers which this instruction may modify.
+// Register 0 may be included, even though writes to it have no effect.
+func (i *Instruction) WrittenRegisters() []int {
+	switch i.Name {
+	case "ADDIU", "ANDI", "ORI", "XORI", "SLTI", "SLTIU", "SLL", "SRA", "SRL", "ADDU", "AND",
+		"NOR", "OR", "SUBU", "XOR", "SLT", "SLTU", "SLLV", "SRAV", "SRLV", "MOVN", "MOVZ", "LUI",
+		"LB", "LBU", "LW":
+		return []int{i.Registers[0]}
+	case "JAL":
+		return []int{31}
+	case "JALR":
+		if len(i.Registers) == 2 {
+			return []int{i.Registers[0]}
+		}
+		return []int{31}
+	}
+	return nil
+}
@@ -0,0 +1,16 @@
+// Package cli holds pieces shared by the command-line tools.
+package cli
+
+import "strings"
+
+// StringList is a flag.Value which collects every occurrence of a repeated flag.
+type StringList []string
+
+func (s *StringList) String() string {
+	return strings.Join(*s, ",")
+}
+
+func (s *StringList) Set(v string) error {
+	*s = append(*s, v)
+	return nil
+}
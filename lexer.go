@@ -1,35 +1,49 @@
 package mips32
 
 import (
-	"errors"
 	"regexp"
 	"strconv"
 	"strings"
 )
 
-var (
-	commentRegexp      = regexp.MustCompile("^(.*?)(#|//|;)(.*)$")
-	directiveRegexp    = regexp.MustCompile("^\\.(text|word)\\s+" + constantNumberPattern + "$")
-	symbolMarkerRegexp = regexp.MustCompile("^" + symbolNamePattern + ":$")
-	instNameRegexp     = regexp.MustCompile("^[A-Za-z]*$")
-)
+var instNameRegexp = regexp.MustCompile("^[A-Za-z]+$")
+
+var supportedDirectives = []string{".text", ".data", ".section", ".globl", ".word", ".include",
+	".set", ".if", ".ifdef", ".ifndef", ".elseif", ".else", ".endif"}
 
 // A TokenizedLine represents one line of an assembly program, translated into syntactic tokens.
-// No more than one of Directive, SymbolDecl, and Instruction will be non-nil.
+// No more than one of Directive and Instruction will be non-nil.
+// The SymbolMarker field may be set alongside either of them (as in "loop: ADDIU $t0, $t0, 1"),
+// in which case the symbol marks the address of the directive or instruction.
 // The Comment field may be non-nil regardless of the other fields.
 type TokenizedLine struct {
+	// File is the name of the source file containing this line.
+	// It is empty for lines which were not read from a named file.
+	File       string
 	LineNumber int
 	Comment    *string
 
+	// Text is the original source text of the line.
+	// It is empty for lines which were not produced by the tokenizer.
+	Text string
+
 	Directive    *TokenizedDirective
 	Instruction  *TokenizedInstruction
 	SymbolMarker *string
+
+	// SymbolMarkerSpan is the position of the symbol marker, if there is one.
+	SymbolMarkerSpan Span
+
+	// Tokens lists every lexical token on the line, including the comment.
+	// It is empty for lines which were not produced by the tokenizer.
+	Tokens []Token
 }
 
 // Equal returns true if this tokenized line is equivalent to another one.
-// This is a deep comparison, and all fields (including the comment and line number) are compared.
+// This is a deep comparison, and all fields (including the comment and line number) are compared,
+// except for the original text, the tokens, and the column positions of the line's parts.
 func (t *TokenizedLine) Equal(t1 *TokenizedLine) bool {
-	if t.LineNumber != t1.LineNumber {
+	if t.File != t1.File || t.LineNumber != t1.LineNumber {
 		return false
 	}
 	if (t.Comment == nil) != (t1.Comment == nil) {
@@ -39,7 +53,7 @@ func (t *TokenizedLine) Equal(t1 *TokenizedLine) bool {
 	}
 	if (t.Directive == nil) != (t1.Directive == nil) {
 		return false
-	} else if t.Directive != nil && *t.Directive != *t1.Directive {
+	} else if t.Directive != nil && !t.Directive.Equal(t1.Directive) {
 		return false
 	}
 	if (t.Instruction == nil) != (t1.Instruction == nil) {
@@ -55,44 +69,113 @@ func (t *TokenizedLine) Equal(t1 *TokenizedLine) bool {
 	return true
 }
 
+// Location returns a human-readable description of where this line came from, such as
+// "lib/util.s:42", or "line 42" if the line has no file name.
+func (t *TokenizedLine) Location() string {
+	return sourceLocation(t.File, t.LineNumber)
+}
+
 // String returns a human-readable version of this line.
 func (l *TokenizedLine) String() string {
-	commentStr := ""
-	if l.Comment != nil {
-		commentStr = " #" + *l.Comment
-	}
+	return l.Format(RenderOptions{})
+}
+
+// Format is like String, but it renders the line in the given style.
+func (l *TokenizedLine) Format(o RenderOptions) string {
+	var code string
 	if l.Directive != nil {
-		return l.Directive.String() + commentStr
+		code = l.Directive.Format(o)
 	} else if l.Instruction != nil {
-		return l.Instruction.String() + commentStr
-	} else if l.SymbolMarker != nil {
-		return *l.SymbolMarker + ":" + commentStr
+		code = l.Instruction.Format(o)
 	}
-	if len(commentStr) > 0 {
-		return commentStr[1:]
-	} else {
-		return ""
+	if l.SymbolMarker != nil {
+		if code != "" {
+			code = *l.SymbolMarker + ": " + code
+		} else {
+			code = *l.SymbolMarker + ":"
+		}
+	}
+	if l.Comment != nil {
+		return o.withComment(code, *l.Comment)
 	}
+	return code
 }
 
 // A TokenizedDirective represents a directive like ".text 0x5000" or ".data 0x0".
 type TokenizedDirective struct {
 	Name     string
 	Constant uint32
+
+	// Argument is the textual argument for directives which do not take a constant.
+	// For example, this is the (unquoted) file name of an ".include" directive, the expression
+	// of an ".if" directive, or the symbol of a ".word" directive which refers to one.
+	//
+	// The ".text" and ".data" directives without an address are tokenized as ".section"
+	// directives, with an Argument of ".text" or ".data".
+	Argument string
+
+	// Span is the position of the directive and its arguments.
+	Span Span
+
+	// literal is the source text of the constant, if it was read from source code.
+	literal string
+}
+
+// Equal returns whether or not two directives are syntactically equivalent.
+// The positions of the directives are not compared.
+func (t *TokenizedDirective) Equal(t1 *TokenizedDirective) bool {
+	return t.Name == t1.Name && t.Constant == t1.Constant && t.Argument == t1.Argument
 }
 
 func (t *TokenizedDirective) String() string {
-	return "." + t.Name + " " + unsignedConst32ToString(t.Constant)
+	return t.Format(RenderOptions{})
+}
+
+// Format is like String, but it renders the directive in the given style.
+func (t *TokenizedDirective) Format(o RenderOptions) string {
+	switch t.Name {
+	case "include":
+		return ".include " + strconv.Quote(t.Argument)
+	case "else", "endif":
+		return "." + t.Name
+	case "if", "ifdef", "ifndef", "elseif", "set", "globl":
+		return "." + t.Name + " " + t.Argument
+	case "section":
+		if t.Argument == ".text" || t.Argument == ".data" {
+			return t.Argument
+		}
+		return ".section " + t.Argument
+	case "word":
+		if t.Argument != "" {
+			return ".word " + t.Argument
+		}
+	}
+	if o.PreserveConstants && t.literal != "" {
+		return "." + t.Name + " " + t.literal
+	}
+	return "." + t.Name + " " + o.directiveConstant(t.Constant)
 }
 
 // A TokenizedInstruction represents an instruction call.
 type TokenizedInstruction struct {
 	Name      string
 	Arguments []*ArgToken
+
+	// NameSpan is the position of the instruction's name.
+	NameSpan Span
+
+	// ArgumentSpans contains the position of each argument.
+	// It may be nil for instructions which were not produced by the tokenizer.
+	ArgumentSpans []Span
 }
 
 func (t *TokenizedInstruction) String() string {
-	for _, template := range Templates {
+	return t.Format(RenderOptions{})
+}
+
+// Format is like String, but it renders the instruction in the given style.
+func (t *TokenizedInstruction) Format(o RenderOptions) string {
+	for _, template := range allTemplates {
 		if !template.Match(t) {
 			continue
 		}
@@ -102,46 +185,69 @@ func (t *TokenizedInstruction) String() string {
 			switch arg {
 			case Register:
 				reg, _ := tokArg.Register()
-				argStrings[i] = registerToString(reg)
+				argStrings[i] = o.register(reg)
 			case SignedConstant16:
 				c, _ := tokArg.SignedConstant16()
-				argStrings[i] = signedConst16ToString(c)
+				argStrings[i] = o.signedConstant(int32(c), arg)
 			case UnsignedConstant16:
 				c, _ := tokArg.UnsignedConstant16()
-				argStrings[i] = unsignedConst16ToString(c)
+				argStrings[i] = o.unsignedConstant(uint32(c), arg)
 			case Constant5:
 				c, _ := tokArg.Constant5()
-				argStrings[i] = strconv.Itoa(int(c))
+				argStrings[i] = o.unsignedConstant(uint32(c), arg)
 			case AbsoluteCodePointer:
 				ptr, _ := tokArg.AbsoluteCodePointer()
 				if ptr.IsSymbol {
 					argStrings[i] = ptr.Symbol
 				} else {
-					argStrings[i] = unsignedConst32ToString(ptr.Constant)
+					argStrings[i] = o.unsignedConstant(ptr.Constant, arg)
 				}
 			case RelativeCodePointer:
 				ptr, _ := tokArg.RelativeCodePointer()
 				if ptr.IsSymbol {
 					argStrings[i] = ptr.Symbol
 				} else {
-					argStrings[i] = signedConst32ToString(int32(ptr.Constant))
+					argStrings[i] = o.signedConstant(int32(ptr.Constant), arg)
 				}
 			case MemoryAddress:
 				ref, _ := tokArg.MemoryReference()
-				argStrings[i] = signedConst16ToString(ref.Offset) + "(" +
-					registerToString(ref.Register) + ")"
+				offset := o.signedConstant(int32(ref.Offset), arg)
+				if half, ok := tokArg.AddressHalf(); ok {
+					offset = half.String()
+				}
+				argStrings[i] = offset + "(" + o.register(ref.Register) + ")"
+			case Constant32:
+				c, _ := tokArg.Constant32()
+				if o.HexArguments[arg] {
+					argStrings[i] = o.unsignedConstant(c, arg)
+				} else {
+					argStrings[i] = signedConst32ToString(int32(c))
+				}
+			}
+			if half, ok := tokArg.AddressHalf(); ok && arg != MemoryAddress {
+				argStrings[i] = half.String()
+			} else if o.PreserveConstants && tokArg.literal != "" {
+				if arg == MemoryAddress {
+					ref, _ := tokArg.MemoryReference()
+					argStrings[i] = tokArg.literal + "(" + o.register(ref.Register) + ")"
+				} else if tokArg.isConstant {
+					argStrings[i] = tokArg.literal
+				}
 			}
 		}
+		name := o.mnemonic(t.Name)
 		if len(argStrings) > 0 {
-			return t.Name + " " + strings.Join(argStrings, ", ")
+			return padRight(name, o.MnemonicWidth) + " " +
+				strings.Join(argStrings, o.operandSeparator())
 		} else {
-			return t.Name
+			return name
 		}
 	}
 	return t.Name + " # UNRECOGNIZED INSTRUCTION."
 }
 
 // Equal returns whether or not two TokenizedInstructions are syntactically equivalent.
+// The positions of the instructions and their arguments are not compared.
 //
 // Syntactic equivalence is not the same thing as semantic equivalence.
 // For example, "J Symbol5" might do the same thing as "J 0x54", but the two expressions are
@@ -154,7 +260,10 @@ func (t *TokenizedInstruction) Equal(t1 *TokenizedInstruction) bool {
 		return false
 	}
 	for i, arg := range t.Arguments {
-		if *arg != *t1.Arguments[i] {
+		// The spelling of constants does not matter.
+		a, b := *arg, *t1.Arguments[i]
+		a.literal, b.literal = "", ""
+		if a != b {
 			return false
 		}
 	}
@@ -162,88 +271,227 @@ func (t *TokenizedInstruction) Equal(t1 *TokenizedInstruction) bool {
 }
 
 // TokenizeSource takes a source file and tokenizes each line.
-// It returns an array of tokenized lines, on an error if one occurred.
+// It returns an array of tokenized lines, or an error if any line could not be tokenized.
+// The error is a DiagnosticList with an entry for every such line.
+//
+// The resulting lines have no file name; use a Preprocessor to tokenize named files and to expand
+// .include directives.
 func TokenizeSource(source string) ([]TokenizedLine, error) {
+	return tokenizeSource("", source)
+}
+
+func tokenizeSource(file, source string) ([]TokenizedLine, error) {
+	lines, diagnostics := tokenizeSourceDiagnostics(file, source)
+	if err := diagnostics.Err(); err != nil {
+		return nil, err
+	}
+	return lines, nil
+}
+
+// tokenizeSourceDiagnostics tokenizes every line it can, and produces a diagnostic for every line
+// it cannot.
+func tokenizeSourceDiagnostics(file, source string) ([]TokenizedLine, DiagnosticList) {
 	splitLines := strings.Split(source, "\n")
 	res := make([]TokenizedLine, 0, len(splitLines))
+	var diagnostics DiagnosticList
 	for lineNum, lineText := range splitLines {
 		line, err := tokenizeLine(lineText)
+		line.File = file
+		line.LineNumber = lineNum + 1
+		line.Text = strings.TrimSuffix(lineText, "\r")
 		if err != nil {
-			linePreamble := "error on line " + strconv.Itoa(lineNum+1) + ": "
-			return nil, errors.New(linePreamble + err.Error())
-		} else if (line == TokenizedLine{}) {
+			diagnostics.addError(&line, err.Column, err.Message, err.Hint)
+			continue
+		} else if len(line.Tokens) == 0 {
 			continue
 		}
-		line.LineNumber = lineNum + 1
 		res = append(res, line)
 	}
-	return res, nil
+	return res, diagnostics
 }
 
 // tokenizeLine tokenizes a single line of assembly code.
-func tokenizeLine(lineText string) (line TokenizedLine, err error) {
-	trimmed := strings.TrimSpace(lineText)
-	if len(trimmed) == 0 {
+func tokenizeLine(lineText string) (line TokenizedLine, err *SyntaxError) {
+	tokens, err := scanLine(lineText)
+	if err != nil {
 		return
 	}
+	line.Tokens = tokens
 
-	commentMatch := commentRegexp.FindStringSubmatch(trimmed)
-	if commentMatch != nil {
-		commentStr := commentMatch[3]
-		beforeComment := commentMatch[1]
-		line, err = tokenizeLine(beforeComment)
-		line.Comment = &commentStr
-		return
+	if len(tokens) > 0 && tokens[len(tokens)-1].Kind == CommentToken {
+		comment := tokens[len(tokens)-1].Text
+		if strings.HasPrefix(comment, "//") {
+			comment = comment[2:]
+		} else {
+			comment = comment[1:]
+		}
+		line.Comment = &comment
+		tokens = tokens[:len(tokens)-1]
 	}
 
-	directiveMatch := directiveRegexp.FindStringSubmatch(trimmed)
-	if directiveMatch != nil {
-		directiveConstant, err := parseConstant(directiveMatch[2])
-		if err != nil {
-			return line, err
+	if len(tokens) >= 2 && tokens[1].Text == ":" &&
+		(tokens[0].Kind == IdentifierToken || tokens[0].Kind == NumberToken) {
+		name := tokens[0].Text
+		if localReferenceRegexp.MatchString(name) ||
+			(tokens[0].Kind == NumberToken && !localLabelRegexp.MatchString(name)) {
+			return line, syntaxError(tokens[0].Span.Column, "invalid symbol name: "+name)
 		}
-		return TokenizedLine{
-			Directive: &TokenizedDirective{
-				Name:     directiveMatch[1],
-				Constant: directiveConstant,
-			},
-		}, nil
+		line.SymbolMarker = &name
+		line.SymbolMarkerSpan = tokens[0].Span
+		tokens = tokens[2:]
 	}
 
-	symbolMatch := symbolMarkerRegexp.FindStringSubmatch(trimmed)
-	if symbolMatch != nil {
-		return TokenizedLine{
-			SymbolMarker: &symbolMatch[1],
-		}, nil
+	if len(tokens) == 0 {
+		return
 	}
 
-	fields := strings.Fields(trimmed)
-	if len(fields) == 0 || !instNameRegexp.MatchString(fields[0]) {
-		err = errors.New("invalid/missing instruction name")
+	if tokens[0].Kind == IdentifierToken && strings.HasPrefix(tokens[0].Text, ".") {
+		tokens[0].Kind = DirectiveToken
+		line.Directive, err = tokenizeDirective(lineText, tokens)
+		if err == nil && line.SymbolMarker != nil && line.Directive.Name != "word" &&
+			line.Directive.Name != "text" {
+			err = syntaxError(tokens[0].Span.Column,
+				"symbol not allowed before ."+line.Directive.Name)
+		}
 		return
 	}
 
+	if tokens[0].Kind != IdentifierToken || !instNameRegexp.MatchString(tokens[0].Text) {
+		err = syntaxError(tokens[0].Span.Column, "invalid/missing instruction name")
+		return
+	}
 	line.Instruction = &TokenizedInstruction{
-		Name:      strings.ToUpper(fields[0]),
-		Arguments: make([]*ArgToken, len(fields)-1),
+		Name:      strings.ToUpper(tokens[0].Text),
+		Arguments: []*ArgToken{},
+		NameSpan:  tokens[0].Span,
+	}
+	operands, err := splitOperands(tokens[0].Span.EndColumn, tokens[1:])
+	if err != nil {
+		return
+	}
+	for _, operand := range operands {
+		arg, err := parseOperand(operand)
+		if err != nil {
+			return line, err
+		}
+		line.Instruction.Arguments = append(line.Instruction.Arguments, arg)
+		line.Instruction.ArgumentSpans = append(line.Instruction.ArgumentSpans, Span{
+			Column:    operand[0].Span.Column,
+			EndColumn: operand[len(operand)-1].Span.EndColumn,
+		})
 	}
 
-	for i, field := range fields[1:] {
-		if i != len(fields)-2 {
-			if !strings.HasSuffix(field, ",") {
-				err = errors.New("missing comma after operand " + strconv.Itoa(i+1))
-				return
+	return
+}
+
+// tokenizeDirective parses a directive from its tokens, which start with the directive's name.
+func tokenizeDirective(lineText string, tokens []Token) (*TokenizedDirective, *SyntaxError) {
+	nameTok := tokens[0]
+	args := tokens[1:]
+	res := &TokenizedDirective{
+		Name: nameTok.Text[1:],
+		Span: Span{
+			Column:    nameTok.Span.Column,
+			EndColumn: tokens[len(tokens)-1].Span.EndColumn,
+		},
+	}
+	argColumn := nameTok.Span.EndColumn
+	if len(args) > 0 {
+		argColumn = args[0].Span.Column
+	}
+
+	switch res.Name {
+	case "text", "data", "word":
+		if len(args) == 0 {
+			if res.Name == "word" {
+				return nil, syntaxError(argColumn, "missing constant for .word")
 			}
-			field = field[:len(field)-1]
+			res.Argument = "." + res.Name
+			res.Name = "section"
+			break
+		} else if res.Name == "data" {
+			return nil, syntaxError(argColumn, "unexpected argument for .data")
+		}
+		arg, err := parseOperand(args)
+		if err != nil {
+			return nil, err
+		}
+		if res.Name == "word" && arg.isSymbol {
+			res.Argument = arg.symbol
+		} else if !arg.isConstant || arg.isHalf {
+			return nil, syntaxError(argColumn, "expected constant for ."+res.Name)
+		}
+		res.Constant = arg.constant
+		res.literal = arg.literal
+	case "section", "globl", "global":
+		if len(args) != 1 || args[0].Kind != IdentifierToken {
+			return nil, syntaxError(argColumn, "expected a name for ."+res.Name)
+		}
+		res.Name = strings.Replace(res.Name, "global", "globl", 1)
+		res.Argument = args[0].Text
+	case "include":
+		if len(args) != 1 || args[0].Kind != StringToken {
+			return nil, syntaxError(argColumn, "expected file name string for .include")
 		}
-		line.Instruction.Arguments[i], err = ParseArgToken(field)
+		path, err := unquoteString(&args[0])
 		if err != nil {
-			err = errors.New("operand " + strconv.Itoa(i+1) + ": " + err.Error())
-			return
+			return nil, err
 		}
+		res.Argument = path
+	case "if", "elseif":
+		if len(args) == 0 {
+			return nil, syntaxError(argColumn, "missing argument for ."+res.Name)
+		}
+		res.Argument = strings.TrimSpace(lineText[argColumn-1 : res.Span.EndColumn-1])
+	case "ifdef", "ifndef", "set":
+		if len(args) != 1 || args[0].Kind != IdentifierToken {
+			return nil, syntaxError(argColumn, "expected a name for ."+res.Name)
+		}
+		res.Argument = args[0].Text
+	case "else", "endif":
+		if len(args) != 0 {
+			return nil, syntaxError(argColumn, "unexpected argument for ."+res.Name)
+		}
+	default:
+		err := syntaxError(nameTok.Span.Column, "unknown directive: "+nameTok.Text)
+		err.Hint = "supported directives are " + strings.Join(supportedDirectives, ", ")
+		return nil, err
 	}
+	return res, nil
+}
 
-	return
+// splitOperands splits the tokens after an instruction name into comma-separated operands.
+// The column argument gives the position just past the instruction name.
+func splitOperands(column int, tokens []Token) ([][]Token, *SyntaxError) {
+	if len(tokens) == 0 {
+		return nil, nil
+	}
+	var res [][]Token
+	var current []Token
+	for _, tok := range tokens {
+		if tok.Text == "," {
+			if len(current) == 0 {
+				return nil, syntaxError(tok.Span.Column,
+					"missing operand "+strconv.Itoa(len(res)+1))
+			}
+			res = append(res, current)
+			current = nil
+		} else {
+			current = append(current, tok)
+		}
+		column = tok.Span.EndColumn
+	}
+	if len(current) == 0 {
+		return nil, syntaxError(column, "missing operand "+strconv.Itoa(len(res)+1))
+	}
+	return append(res, current), nil
+}
+
+func sourceLocation(file string, lineNumber int) string {
+	if file == "" {
+		return "line " + strconv.Itoa(lineNumber)
+	}
+	return file + ":" + strconv.Itoa(lineNumber)
 }
 
 func unsignedConst32ToString(constant uint32) string {
@@ -1,6 +1,9 @@
 package mips32
 
-import "testing"
+import (
+	"strings"
+	"testing"
+)
 
 func TestTokenizeSource(t *testing.T) {
 	source := `.text 0x50000 # this says where our program's data is located.
@@ -24,7 +27,7 @@ func TestTokenizeSource(t *testing.T) {
 			{
 				LineNumber: 1,
 				Comment:    createStringPtr(" this says where our program's data is located."),
-				Directive:  &TokenizedDirective{"text", 0x50000},
+				Directive:  &TokenizedDirective{Name: "text", Constant: 0x50000},
 			},
 			{
 				LineNumber:   2,
@@ -68,7 +71,7 @@ func TestTokenizeSource(t *testing.T) {
 			},
 			{
 				LineNumber: 9,
-				Directive:  &TokenizedDirective{"word", 0},
+				Directive:  &TokenizedDirective{Name: "word", Constant: 0},
 			},
 			{
 				LineNumber: 11,
@@ -99,7 +102,7 @@ func TestTokenizeSource(t *testing.T) {
 	}
 
 	invalidStrs := []string{"LUI $r5 0xDEAD", ".text foo", "Monkey Brains:", "foo_bar $r5",
-		"$r5, $r4"}
+		"$r5, $r4", "a: b: NOP", "a: .include \"b.s\"", "a: LUI $r5 0xDEAD"}
 	for _, str := range invalidStrs {
 		if _, err := TokenizeSource(str); err == nil {
 			t.Error("expected parse to fail:", str)
@@ -107,6 +110,127 @@ func TestTokenizeSource(t *testing.T) {
 	}
 }
 
+func TestTokenizeLabelledLine(t *testing.T) {
+	tokenized, err := TokenizeSource("loop: addiu $t0, $t0, 1 # count\n1:.word 5")
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []TokenizedLine{
+		{
+			LineNumber:   1,
+			Comment:      createStringPtr(" count"),
+			SymbolMarker: createStringPtr("loop"),
+			Instruction: &TokenizedInstruction{
+				Name: "ADDIU",
+				Arguments: []*ArgToken{
+					&ArgToken{isRegister: true, register: 8},
+					&ArgToken{isRegister: true, register: 8},
+					&ArgToken{isConstant: true, constant: 1},
+				},
+			},
+		},
+		{
+			LineNumber:   2,
+			SymbolMarker: createStringPtr("1"),
+			Directive:    &TokenizedDirective{Name: "word", Constant: 5},
+		},
+	}
+	if len(tokenized) != len(expected) {
+		t.Fatal("invalid tokenized program:", tokenized)
+	}
+	for i, line := range tokenized {
+		if !line.Equal(&expected[i]) {
+			t.Error("invalid line", expected[i].LineNumber, ":", line)
+		}
+	}
+	if s := tokenized[0].String(); s != "loop: ADDIU $8, $8, 1 # count" {
+		t.Error("unexpected string:", s)
+	}
+}
+
+func TestTokenizeSpacing(t *testing.T) {
+	equivalents := [][]string{
+		{"ADDU $t0, $t1, $t2", "addu $t0,$t1,$t2", "ADDU\t$t0 ,\t$t1 , $t2"},
+		{"LW $t0, 4($sp)", "lw $t0, 4 ( $sp )", "lw $t0,4($sp)", "LW $t0, +4($29)"},
+		{"SW $t0, -4($sp)", "sw $t0,-4($sp)", "SW $t0, - 4 ($sp)", "sw $t0,-0x4($sp)"},
+		{"ORI $t0, $0, 65", "ori $t0, $0, 'A'", "ORI $t0, $0, 0x41 # 'B'"},
+		{"ORI $t0, $0, 10", "ori $t0, $0, '\\n'", "ORI $t0,$0,012"},
+	}
+	for _, group := range equivalents {
+		var first *TokenizedInstruction
+		for _, source := range group {
+			lines, err := TokenizeSource(source)
+			if err != nil {
+				t.Error(source, "-", err)
+				continue
+			} else if len(lines) != 1 || lines[0].Instruction == nil {
+				t.Error("unexpected lines for", source)
+				continue
+			}
+			if first == nil {
+				first = lines[0].Instruction
+			} else if !first.Equal(lines[0].Instruction) {
+				t.Error("unexpected instruction for", source, "-", lines[0].Instruction)
+			}
+		}
+	}
+}
+
+func TestTokenizeColumns(t *testing.T) {
+	lines, err := TokenizeSource("loop:\taddu $t0,$t1, 4 ( $sp ) # hi\n  .include \"a#b.s\"")
+	if err != nil {
+		t.Fatal(err)
+	}
+	inst := lines[0].Instruction
+	if lines[0].SymbolMarkerSpan != (Span{1, 5}) {
+		t.Error("unexpected symbol span:", lines[0].SymbolMarkerSpan)
+	}
+	if inst.NameSpan != (Span{7, 11}) {
+		t.Error("unexpected name span:", inst.NameSpan)
+	}
+	expectedSpans := []Span{{12, 15}, {16, 19}, {21, 30}}
+	if len(inst.ArgumentSpans) != len(expectedSpans) {
+		t.Fatal("unexpected argument spans:", inst.ArgumentSpans)
+	}
+	for i, span := range expectedSpans {
+		if inst.ArgumentSpans[i] != span {
+			t.Error("argument", i, "has span", inst.ArgumentSpans[i])
+		}
+	}
+	if len(lines[0].Tokens) != 12 {
+		t.Error("unexpected tokens:", lines[0].Tokens)
+	} else if tok := lines[0].Tokens[11]; tok.Kind != CommentToken || tok.Span != (Span{31, 35}) {
+		t.Error("unexpected comment token:", tok)
+	}
+
+	dir := lines[1].Directive
+	if dir.Name != "include" || dir.Argument != "a#b.s" || dir.Span != (Span{3, 19}) {
+		t.Error("unexpected directive:", *dir)
+	}
+	if lines[1].Comment != nil {
+		t.Error("unexpected comment")
+	}
+
+	errorColumns := map[string]string{
+		"ADDU $t0,, $t1":    "column 10",
+		"ADDU $t0, $t1,":    "column 15",
+		"LUI $r5 0xDEAD":    "column 9",
+		"LW $t0, 4($sp":     "column 10",
+		"ORI $t0, $0, 'AB'": "column 14",
+		".word \"x":         "column 7",
+		"  .bogus 5":        "column 3",
+		"NOP ?":             "column 5",
+	}
+	for source, column := range errorColumns {
+		_, err := TokenizeSource(source)
+		if err == nil {
+			t.Error("expected error for:", source)
+		} else if !strings.HasPrefix(err.Error(), "line 1, "+column+": error:") {
+			t.Error("unexpected error for", source, "-", err)
+		}
+	}
+}
+
 func BenchmarkTokenizeSource(b *testing.B) {
 	code := `
 		.text 0x50000 # this says where our program's data is located.
@@ -130,3 +254,29 @@ func BenchmarkTokenizeSource(b *testing.B) {
 func createStringPtr(s string) *string {
 	return &s
 }
+
+func TestTokenizeSectionDirectives(t *testing.T) {
+	tests := map[string]string{
+		".text":              ".text",
+		".data # vars":       ".data # vars",
+		".section .rodata":   ".section .rodata",
+		".global main":       ".globl main",
+		"table: .word entry": "table: .word entry",
+		"LUI $t0, %hi(x)":    "LUI $8, %hi(x)",
+		"SW $t0, %lo(x)($8)": "SW $8, %lo(x)($8)",
+	}
+	for source, expected := range tests {
+		lines, err := TokenizeSource(source)
+		if err != nil {
+			t.Error(source, err)
+		} else if actual := lines[0].String(); actual != expected {
+			t.Errorf("expected %q but got %q", expected, actual)
+		}
+	}
+	for _, failure := range []string{".data 0x100", ".section", ".globl 5", "LUI $t0, %hi(x",
+		"LUI $t0, %mid(x)", ".text %hi(x)"} {
+		if _, err := TokenizeSource(failure); err == nil {
+			t.Error("expected error for:", failure)
+		}
+	}
+}
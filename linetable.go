@@ -0,0 +1,103 @@
+package mips32
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"sort"
+	"strconv"
+)
+
+// A SourcePosition identifies the source code which produced an instruction.
+type SourcePosition struct {
+	// File is the name of the source file, or "" for unnamed sources.
+	File string
+
+	// Line and Column give the 1-based position of the instruction or directive.
+	Line   int
+	Column int
+
+	// Expansion is set for instructions which the assembler generated rather than copying them
+	// from the source, such as "delay slot NOP" or "relaxed BEQ". The position is that of the
+	// source line which caused the expansion.
+	Expansion string `json:",omitempty"`
+}
+
+// String formats the position like a Diagnostic location, as in "lib/util.s:42:7", followed by
+// the expansion in parentheses if there is one.
+func (s SourcePosition) String() string {
+	d := Diagnostic{File: s.File, Line: s.Line, Column: s.Column}
+	res := d.Location()
+	if s.Expansion != "" {
+		res += " (" + s.Expansion + ")"
+	}
+	return res
+}
+
+// A LineTable maps the address of each instruction to the source code which produced it.
+type LineTable map[uint32]SourcePosition
+
+const lineTableFormat = "mips32-lines"
+
+type lineTableEntry struct {
+	Address uint32
+	SourcePosition
+}
+
+type lineTableFile struct {
+	Format string
+	Lines  []lineTableEntry
+}
+
+// WriteLineTable writes a line table as JSON, so that it can be kept alongside a binary.
+func WriteLineTable(w io.Writer, t LineTable) error {
+	file := lineTableFile{Format: lineTableFormat, Lines: []lineTableEntry{}}
+	for addr, pos := range t {
+		file.Lines = append(file.Lines, lineTableEntry{Address: addr, SourcePosition: pos})
+	}
+	sort.Slice(file.Lines, func(i, j int) bool {
+		return file.Lines[i].Address < file.Lines[j].Address
+	})
+	data, err := json.MarshalIndent(&file, "", "  ")
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(append(data, '\n'))
+	return err
+}
+
+// ReadLineTable reads a line table which was written by WriteLineTable.
+func ReadLineTable(r io.Reader) (LineTable, error) {
+	var file lineTableFile
+	if err := json.NewDecoder(r).Decode(&file); err != nil || file.Format != lineTableFormat {
+		return nil, errors.New("not a mips32 line table")
+	}
+	res := LineTable{}
+	for _, entry := range file.Lines {
+		if entry.Address&3 != 0 {
+			return nil, errors.New("misaligned address in line table: " +
+				strconv.FormatUint(uint64(entry.Address), 16))
+		}
+		res[entry.Address] = entry.SourcePosition
+	}
+	return res, nil
+}
+
+// lineTable builds the line table for the instructions of the current section.
+func (p *executableParser) lineTable() LineTable {
+	res := LineTable{}
+	for addr, line := range p.instructionLines {
+		pos := SourcePosition{
+			File:      line.File,
+			Line:      line.LineNumber,
+			Expansion: p.instructionExpansions[addr],
+		}
+		if line.Instruction != nil {
+			pos.Column = line.Instruction.NameSpan.Column
+		} else if line.Directive != nil {
+			pos.Column = line.Directive.Span.Column
+		}
+		res[addr] = pos
+	}
+	return res
+}
@@ -0,0 +1,99 @@
+package mips32
+
+import (
+	"bytes"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestExecutableLineTable(t *testing.T) {
+	preprocessor := &Preprocessor{ReadFile: testReadFile(map[string]string{
+		"main.s": `.set reorder
+main: ADDIU $t0, $t0, 1
+  JAL 0x40000
+  .word main
+  ORI $t1, $0, 1
+  BEQ $0, $0, main`,
+	})}
+	lines, err := preprocessor.TokenizeFile("main.s")
+	if err != nil {
+		t.Fatal(err)
+	}
+	exc, err := ParseExecutableOptions(lines, ParseOptions{HoistDelaySlots: true,
+		RelaxBranches: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := LineTable{
+		0x00: {File: "main.s", Line: 3, Column: 3},
+		0x04: {File: "main.s", Line: 2, Column: 7, Expansion: "hoisted into delay slot"},
+		0x08: {File: "main.s", Line: 4, Column: 3},
+		0x0c: {File: "main.s", Line: 6, Column: 3},
+		0x10: {File: "main.s", Line: 5, Column: 3, Expansion: "hoisted into delay slot"},
+	}
+	if !reflect.DeepEqual(exc.LineTable, expected) {
+		t.Error("unexpected line table:", exc.LineTable)
+	}
+
+	lines, err = TokenizeSource(`.set reorder
+J 0x10000000`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	exc, err = ParseExecutableOptions(lines, ParseOptions{RelaxBranches: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(exc.LineTable) != 4 {
+		t.Fatal("unexpected line table:", exc.LineTable)
+	}
+	for addr, pos := range exc.LineTable {
+		if pos.Line != 2 || pos.Expansion != "relaxed J" {
+			t.Errorf("unexpected position at %x: %s", addr, pos)
+		}
+	}
+}
+
+func TestEmulatorErrorPosition(t *testing.T) {
+	lines, err := TokenizeSource(`LUI $t0, 0x8000
+J next
+LW $t1, 2($t0)
+next: NOP`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	exc, err := ParseExecutable(lines)
+	if err != nil {
+		t.Fatal(err)
+	}
+	emu := &Emulator{Memory: NewLazyMemory(), Executable: exc, ForceMemAlignment: true}
+	for !emu.Done() {
+		if err = emu.Step(); err != nil {
+			break
+		}
+	}
+	if err == nil || !strings.HasPrefix(err.Error(), "error at 0x8 (line 3, column 1): ") {
+		t.Error("unexpected error:", err)
+	}
+}
+
+func TestLineTableRoundTrip(t *testing.T) {
+	table := LineTable{
+		0x80000000: {File: "a.s", Line: 3, Column: 1},
+		0x80000004: {File: "b.s", Line: 7, Column: 5, Expansion: "delay slot NOP"},
+	}
+	var buf bytes.Buffer
+	if err := WriteLineTable(&buf, table); err != nil {
+		t.Fatal(err)
+	}
+	actual, err := ReadLineTable(&buf)
+	if err != nil {
+		t.Fatal(err)
+	} else if !reflect.DeepEqual(actual, table) {
+		t.Error("unexpected line table:", actual)
+	}
+	if _, err := ReadLineTable(strings.NewReader(`{"Format": "mips32-object"}`)); err == nil {
+		t.Error("expected error for wrong format")
+	}
+}
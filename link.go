@@ -0,0 +1,288 @@
+package mips32
+
+import (
+	"debug/elf"
+	"errors"
+	"strconv"
+	"strings"
+)
+
+// A LinkerScript tells Link where to place sections and where execution begins.
+type LinkerScript struct {
+	// Sections gives the addresses of output sections, in the order they are placed.
+	// Sections which are not listed are placed after the listed ones, in the order in which
+	// they first appear in the objects, each directly after the previous one.
+	Sections []SectionAddress
+
+	// Entry is the global symbol where execution begins.
+	// If it is empty, execution begins at the first placed section.
+	Entry string
+}
+
+// A SectionAddress gives the address of an output section.
+type SectionAddress struct {
+	Name    string
+	Address uint32
+}
+
+// ParseLinkerScript parses a linker script.
+//
+// Each line of a script either gives the address of a section, as in ".text 0x80000000", or names
+// the entry point, as in "ENTRY(main)". Comments start with "#".
+func ParseLinkerScript(source string) (*LinkerScript, error) {
+	res := &LinkerScript{}
+	for i, line := range strings.Split(source, "\n") {
+		if idx := strings.Index(line, "#"); idx >= 0 {
+			line = line[:idx]
+		}
+		fields := strings.Fields(line)
+		if len(fields) == 0 {
+			continue
+		}
+		location := "line " + strconv.Itoa(i+1) + ": "
+		if strings.HasPrefix(fields[0], "ENTRY(") {
+			if len(fields) != 1 || !strings.HasSuffix(fields[0], ")") || res.Entry != "" {
+				return nil, errors.New(location + "expected a single ENTRY(symbol)")
+			}
+			res.Entry = fields[0][len("ENTRY(") : len(fields[0])-1]
+			continue
+		}
+		if len(fields) != 2 || !strings.HasPrefix(fields[0], ".") {
+			return nil, errors.New(location + "expected a section name and an address")
+		}
+		addr, err := strconv.ParseUint(fields[1], 0, 32)
+		if err != nil || addr&3 != 0 {
+			return nil, errors.New(location + "invalid section address: " + fields[1])
+		}
+		for _, section := range res.Sections {
+			if section.Name == fields[0] {
+				return nil, errors.New(location + "repeated section: " + fields[0])
+			}
+		}
+		res.Sections = append(res.Sections, SectionAddress{Name: fields[0], Address: uint32(addr)})
+	}
+	return res, nil
+}
+
+// address finds the address of a section, if the script gives one.
+func (l *LinkerScript) address(section string) (uint32, bool) {
+	for _, x := range l.Sections {
+		if x.Name == section {
+			return x.Address, true
+		}
+	}
+	return 0, false
+}
+
+// Link combines relocatable objects into an executable.
+//
+// Sections with the same name are placed one after another, in the order of the objects.
+// Every relocation is resolved against the symbols of its own object first, and then against
+// the global symbols of all the objects.
+//
+// If script is nil, sections are placed one after another starting at address 0.
+// All of the problems found while linking are reported in the error, one per line.
+func Link(objects []*Object, script *LinkerScript) (*Executable, error) {
+	if script == nil {
+		script = &LinkerScript{}
+	}
+	l := &linker{objects: objects, script: script}
+	l.placeSections()
+	l.collectSymbols()
+	res := l.relocate()
+	if len(l.errors) > 0 {
+		return nil, errors.New(strings.Join(l.errors, "\n"))
+	}
+	res.joinContiguousSegments()
+	return res, nil
+}
+
+type linker struct {
+	objects []*Object
+	script  *LinkerScript
+	errors  []string
+
+	// sectionAddrs maps the sections of each object to their addresses.
+	sectionAddrs []map[string]uint32
+
+	// firstAddr is the address of the first output section.
+	firstAddr uint32
+
+	globals      map[string]uint32
+	globalOwners map[string]string
+	locals       []map[string]uint32
+}
+
+func (l *linker) addError(object *Object, message string) {
+	if object != nil && object.Name != "" {
+		message = object.Name + ": " + message
+	}
+	l.errors = append(l.errors, message)
+}
+
+func (l *linker) placeSections() {
+	var names []string
+	for _, section := range l.script.Sections {
+		names = append(names, section.Name)
+	}
+	for _, obj := range l.objects {
+		for _, section := range obj.Sections {
+			if !containsString(names, section.Name) {
+				names = append(names, section.Name)
+			}
+		}
+	}
+
+	l.sectionAddrs = make([]map[string]uint32, len(l.objects))
+	for i := range l.sectionAddrs {
+		l.sectionAddrs[i] = map[string]uint32{}
+	}
+	type outputSection struct {
+		name       string
+		start, end uint64
+	}
+	var placed []outputSection
+	var addr uint64
+	for i, name := range names {
+		if base, ok := l.script.address(name); ok {
+			addr = uint64(base)
+		}
+		if i == 0 {
+			l.firstAddr = uint32(addr)
+		}
+		start := addr
+		for j, obj := range l.objects {
+			if section := obj.Section(name); section != nil {
+				l.sectionAddrs[j][name] = uint32(addr)
+				addr += uint64(len(section.Words) * 4)
+			}
+		}
+		if addr > 1<<32 {
+			l.addError(nil, "section "+name+" extends past the end of memory")
+		}
+		for _, other := range placed {
+			if start < other.end && other.start < addr {
+				l.addError(nil, "section "+name+" overlaps section "+other.name)
+			}
+		}
+		placed = append(placed, outputSection{name, start, addr})
+	}
+}
+
+func (l *linker) collectSymbols() {
+	l.globals = map[string]uint32{}
+	l.globalOwners = map[string]string{}
+	l.locals = make([]map[string]uint32, len(l.objects))
+	for i, obj := range l.objects {
+		l.locals[i] = map[string]uint32{}
+		for _, sym := range obj.Symbols {
+			base, ok := l.sectionAddrs[i][sym.Section]
+			if !ok {
+				l.addError(obj, "symbol "+sym.Name+" is in missing section "+sym.Section)
+				continue
+			}
+			addr := base + sym.Offset
+			l.locals[i][sym.Name] = addr
+			if !sym.Global {
+				continue
+			}
+			if owner, ok := l.globalOwners[sym.Name]; ok {
+				l.addError(obj, "multiple definitions of "+sym.Name+" (also defined in "+
+					owner+")")
+				continue
+			}
+			l.globals[sym.Name] = addr
+			l.globalOwners[sym.Name] = obj.Name
+		}
+	}
+}
+
+func (l *linker) relocate() *Executable {
+	res := &Executable{
+		Segments: map[uint32][]Instruction{},
+		Symbols:  map[string]uint32{},
+		Entry:    l.firstAddr,
+	}
+	for i, obj := range l.objects {
+		for _, section := range obj.Sections {
+			if len(section.Words) == 0 {
+				continue
+			}
+			base := l.sectionAddrs[i][section.Name]
+			words := append([]uint32{}, section.Words...)
+			for _, reloc := range section.Relocations {
+				target, ok := l.locals[i][reloc.Symbol]
+				if !ok {
+					target, ok = l.globals[reloc.Symbol]
+				}
+				location := section.Name + "+" + hexAddress(reloc.Offset) + ": "
+				if !ok {
+					l.addError(obj, location+"undefined reference to "+reloc.Symbol)
+				} else if reloc.Offset&3 != 0 || int(reloc.Offset/4) >= len(words) {
+					l.addError(obj, location+"relocation outside of section")
+				} else {
+					idx := reloc.Offset / 4
+					word, err := applyRelocation(words[idx], &reloc, base+reloc.Offset, target)
+					if err != nil {
+						l.addError(obj, location+err.Error())
+					}
+					words[idx] = word
+				}
+			}
+			insts := make([]Instruction, len(words))
+			for j, word := range words {
+				insts[j] = *decodeInstructionAt(word, base+uint32(j*4))
+			}
+			res.Segments[base] = insts
+		}
+		for name, addr := range l.locals[i] {
+			if _, ok := res.Symbols[name]; !ok {
+				res.Symbols[name] = addr
+			}
+		}
+	}
+	// Global names take precedence over local symbols from other objects.
+	for name, addr := range l.globals {
+		res.Symbols[name] = addr
+	}
+	if l.script.Entry != "" {
+		if addr, ok := l.globals[l.script.Entry]; ok {
+			res.Entry = addr
+		} else {
+			l.addError(nil, "undefined entry symbol: "+l.script.Entry)
+		}
+	}
+	return res
+}
+
+// applyRelocation patches a word at address addr to refer to a symbol at address target.
+func applyRelocation(word uint32, reloc *Relocation, addr, target uint32) (uint32, error) {
+	value := target + uint32(reloc.Addend)
+	switch reloc.Type {
+	case elf.R_MIPS_32:
+		return value, nil
+	case elf.R_MIPS_26:
+		if value&3 != 0 {
+			return word, errors.New("misaligned jump target: " + hexAddress(value))
+		} else if value&jumpRegionMask != (addr+4)&jumpRegionMask {
+			return word, errors.New("jump target outside the current 256MB region: " +
+				reloc.Symbol + " (" + hexAddress(value) + ")")
+		}
+		return (word & 0xfc000000) | ((value >> 2) & 0x03ffffff), nil
+	case elf.R_MIPS_HI16:
+		half := AddressHalf{High: true}
+		return (word & 0xffff0000) | uint32(half.Value(value)), nil
+	case elf.R_MIPS_LO16:
+		return (word & 0xffff0000) | (value & 0xffff), nil
+	case elf.R_MIPS_PC16:
+		distance := int64(int32(value - (addr + 4)))
+		if distance&3 != 0 {
+			return word, errors.New("misaligned branch target: " + hexAddress(value))
+		} else if distance < -maxBranchDistance || distance >= maxBranchDistance {
+			return word, errors.New("branch target out of range: " + reloc.Symbol + " (" +
+				hexAddress(value) + ")")
+		}
+		return (word & 0xffff0000) | (uint32(distance>>2) & 0xffff), nil
+	}
+	return word, errors.New("unsupported relocation type: " + reloc.Type.String())
+}
@@ -0,0 +1,185 @@
+package mips32
+
+import (
+	"bytes"
+	"debug/elf"
+	"strings"
+	"testing"
+)
+
+func TestParseObject(t *testing.T) {
+	obj := testParseObject(t, `.globl main
+main: JAL util
+	NOP
+	LUI $t0, %hi(table)
+	LW $t0, %lo(table)($t0)
+	BEQ $0, $0, main
+	NOP
+	.data
+table: .word main`)
+	text := obj.Section(".text")
+	data := obj.Section(".data")
+	if text == nil || data == nil || len(obj.Sections) != 2 {
+		t.Fatal("unexpected sections:", obj.Sections)
+	}
+	if len(text.Words) != 6 || text.Words[4] != 0x1000fffb {
+		t.Errorf("unexpected text: %x", text.Words)
+	}
+	expected := []Relocation{
+		{Offset: 0, Type: elf.R_MIPS_26, Symbol: "util"},
+		{Offset: 8, Type: elf.R_MIPS_HI16, Symbol: "table"},
+		{Offset: 12, Type: elf.R_MIPS_LO16, Symbol: "table"},
+	}
+	if len(text.Relocations) != len(expected) {
+		t.Fatal("unexpected relocations:", text.Relocations)
+	}
+	for i, x := range expected {
+		if text.Relocations[i] != x {
+			t.Error("unexpected relocation:", text.Relocations[i])
+		}
+	}
+	if len(data.Relocations) != 1 || data.Relocations[0].Type != elf.R_MIPS_32 {
+		t.Error("unexpected data relocations:", data.Relocations)
+	}
+	expectedSymbols := []ObjectSymbol{
+		{Name: "main", Section: ".text", Offset: 0, Global: true},
+		{Name: "table", Section: ".data", Offset: 0},
+	}
+	if len(obj.Symbols) != 2 || obj.Symbols[0] != expectedSymbols[0] ||
+		obj.Symbols[1] != expectedSymbols[1] {
+		t.Error("unexpected symbols:", obj.Symbols)
+	}
+
+	var buf bytes.Buffer
+	if err := WriteObject(&buf, obj); err != nil {
+		t.Fatal(err)
+	}
+	read, err := ReadObject(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(read.Sections) != 2 || read.Section(".text").Relocations[0] != expected[0] {
+		t.Error("object did not survive a round trip")
+	}
+}
+
+func TestLink(t *testing.T) {
+	main := testParseObject(t, `.globl main
+main: JAL util
+	NOP
+	LUI $t0, %hi(table)
+	ADDIU $t0, $t0, %lo(table)
+	BEQ $0, $0, done
+	NOP
+	.data
+table: .word main
+	.word util`)
+	lib := testParseObject(t, `.globl util
+	.globl done
+util: ORI $v0, $0, 42
+	JR $ra
+	NOP
+	.data
+table: .word 7
+	.text
+done: ORI $v1, $0, 1`)
+	script, err := ParseLinkerScript("# Data first.\n.data 0x80000000\n.text 0x80001000\n" +
+		"ENTRY(main)")
+	if err != nil {
+		t.Fatal(err)
+	}
+	exc, err := Link([]*Object{main, lib}, script)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if exc.Entry != 0x80001000 || exc.Symbols["util"] != 0x80001018 ||
+		exc.Symbols["done"] != 0x80001024 || exc.Symbols["table"] != 0x80000000 {
+		t.Errorf("unexpected layout: entry 0x%x, symbols %v", exc.Entry, exc.Symbols)
+	}
+	for addr, expected := range map[uint32]uint32{
+		0x80000000: 0x80001000,
+		0x80000004: 0x80001018,
+		0x80000008: 7,
+	} {
+		if word, err := exc.Get(addr).Encode(addr, exc.Symbols); err != nil || word != expected {
+			t.Errorf("word at 0x%x should be 0x%x but got 0x%x (%v)", addr, expected, word, err)
+		}
+	}
+
+	emulator := &Emulator{
+		Memory:         NewLazyMemory(),
+		Executable:     exc,
+		ProgramCounter: exc.Entry,
+	}
+	for !emulator.Done() {
+		if err := emulator.Step(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	expected := map[int]uint32{2: 42, 3: 1, 8: 0x80000000, 31: 0x80001008}
+	for reg, value := range expected {
+		if emulator.RegisterFile[reg] != value {
+			t.Errorf("register %d should be 0x%x but got 0x%x", reg, value,
+				emulator.RegisterFile[reg])
+		}
+	}
+}
+
+func TestLinkErrors(t *testing.T) {
+	a := testParseObject(t, ".globl f\nf: J g\nNOP\nBEQ $0, $0, far\nNOP")
+	a.Name = "a.o"
+	b := testParseObject(t, ".globl f\nf: NOP\n.globl far\n.section .far\nfar: NOP")
+	b.Name = "b.o"
+	script := &LinkerScript{
+		Sections: []SectionAddress{{".text", 0}, {".far", 0x100000}},
+		Entry:    "start",
+	}
+	_, err := Link([]*Object{a, b}, script)
+	if err == nil {
+		t.Fatal("expected an error")
+	}
+	expected := []string{
+		"b.o: multiple definitions of f (also defined in a.o)",
+		"a.o: .text+0x0: undefined reference to g",
+		"a.o: .text+0x8: branch target out of range: far (0x100000)",
+		"undefined entry symbol: start",
+	}
+	if err.Error() != strings.Join(expected, "\n") {
+		t.Error("unexpected errors:", err)
+	}
+
+	script.Sections[1].Address = 8
+	c := testParseObject(t, ".globl g\ng: NOP")
+	if _, err := Link([]*Object{a, b, c}, script); err == nil ||
+		!strings.Contains(err.Error(), "section .far overlaps section .text") {
+		t.Error("unexpected error:", err)
+	}
+}
+
+func TestParseLinkerScriptErrors(t *testing.T) {
+	failures := []string{
+		".text",
+		".text 0x1002",
+		"text 0x1000",
+		".text 0x1000\n.text 0x2000",
+		"ENTRY(a)\nENTRY(b)",
+		"ENTRY(main",
+	}
+	for _, failure := range failures {
+		if _, err := ParseLinkerScript(failure); err == nil {
+			t.Error("expected error for:", failure)
+		}
+	}
+}
+
+func testParseObject(t *testing.T, source string) *Object {
+	lines, err := TokenizeSource(source)
+	if err != nil {
+		t.Fatal(err)
+	}
+	obj, err := ParseObject(lines, ParseOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return obj
+}
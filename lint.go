@@ -0,0 +1,403 @@
+package mips32
+
+import (
+	"errors"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// A LintCheck is a kind of likely mistake which Lint looks for.
+type LintCheck int
+
+const (
+	// DelaySlotCheck finds branches and jumps in the delay slots of other branches and jumps,
+	// which the emulator only rejects when they run.
+	DelaySlotCheck LintCheck = iota
+
+	// ZeroWriteCheck finds instructions which write to $zero, and therefore do nothing.
+	ZeroWriteCheck
+
+	// ReservedRegisterCheck finds uses of $at, which belongs to the assembler, and of $k0 and
+	// $k1, which belong to the kernel.
+	ReservedRegisterCheck
+
+	// UninitializedCheck finds registers which may be read before they are given a value.
+	UninitializedCheck
+
+	// UnreachableCheck finds code after an unconditional jump which nothing jumps to.
+	UnreachableCheck
+
+	// MisalignedStackCheck finds LW and SW instructions whose offsets from $sp or $gp are not
+	// multiples of four.
+	MisalignedStackCheck
+
+	// CalleeSavedCheck finds functions which change $s0-$s7 or $fp without saving them on the
+	// stack and restoring them.
+	CalleeSavedCheck
+
+	// UnusedLabelCheck finds labels which are never referenced.
+	UnusedLabelCheck
+)
+
+var lintCheckNames = []string{"delay-slot", "zero-write", "reserved-register", "uninitialized",
+	"unreachable", "misaligned-stack", "callee-saved", "unused-label"}
+
+// ParseLintCheck finds the check with a given name, such as "delay-slot".
+func ParseLintCheck(name string) (LintCheck, error) {
+	for i, x := range lintCheckNames {
+		if x == name {
+			return LintCheck(i), nil
+		}
+	}
+	return 0, errors.New("unknown check: " + name + " (expected one of " +
+		strings.Join(lintCheckNames, ", ") + ")")
+}
+
+// ParseLintChecks parses a comma-separated list of check names.
+// The name "all" selects every check.
+func ParseLintChecks(list string) (map[LintCheck]bool, error) {
+	res := map[LintCheck]bool{}
+	for _, name := range strings.Split(list, ",") {
+		name = strings.TrimSpace(name)
+		if name == "" {
+			continue
+		} else if name == "all" {
+			return AllLintChecks(), nil
+		}
+		check, err := ParseLintCheck(name)
+		if err != nil {
+			return nil, err
+		}
+		res[check] = true
+	}
+	return res, nil
+}
+
+// AllLintChecks returns a set containing every check.
+func AllLintChecks() map[LintCheck]bool {
+	res := map[LintCheck]bool{}
+	for i := range lintCheckNames {
+		res[LintCheck(i)] = true
+	}
+	return res
+}
+
+func (c LintCheck) String() string {
+	if int(c) < len(lintCheckNames) {
+		return lintCheckNames[c]
+	}
+	return "LintCheck(" + strconv.Itoa(int(c)) + ")"
+}
+
+// MarshalText encodes the check as its name, so that it reads well in JSON.
+func (c LintCheck) MarshalText() ([]byte, error) {
+	return []byte(c.String()), nil
+}
+
+// A LintWarning is a likely mistake found by Lint.
+type LintWarning struct {
+	Check LintCheck
+
+	// Address is the address of the instruction which the warning is about.
+	Address uint32
+
+	// Position is the source position of the instruction, if the executable has a line table.
+	Position *SourcePosition `json:",omitempty"`
+
+	Message string
+
+	// Hint is an optional suggestion for fixing the problem.
+	Hint string `json:",omitempty"`
+}
+
+// String formats the warning like a Diagnostic, followed by the name of its check, as in
+// "prog.s:4:5: warning: $t0 may be used before it is set [uninitialized]".
+// Warnings without a source position use the instruction's address as their location.
+func (w *LintWarning) String() string {
+	location := hexAddress(w.Address)
+	if w.Position != nil {
+		d := Diagnostic{File: w.Position.File, Line: w.Position.Line, Column: w.Position.Column}
+		location = d.Location()
+	}
+	res := location + ": warning: " + w.Message + " [" + w.Check.String() + "]"
+	if w.Hint != "" {
+		res += "\n" + location + ": note: " + w.Hint
+	}
+	return res
+}
+
+// lintInitialRegisters are the registers which hold meaningful values when a function starts:
+// $zero, the arguments, the callee-saved registers, $gp, $sp, $fp, and $ra. The reserved
+// registers are included so that they are only reported by ReservedRegisterCheck.
+const lintInitialRegisters RegisterSet = 1<<0 | 1<<1 | 0xf<<4 | 0xff<<16 | 0xf<<26 | 1<<30 | 1<<31
+
+// lintCalleeSaved lists the registers which a function must restore before it returns.
+var lintCalleeSaved = []int{16, 17, 18, 19, 20, 21, 22, 23, 30}
+
+// Lint looks for likely mistakes in an executable, and returns the warnings in order of
+// address.
+//
+// Only the code which can be reached from the entry point is checked. Control flow is followed
+// as it is by a flowGraph, so code which is only reached through jump tables is skipped.
+// Instructions which the assembler generated itself, such as relaxed branches through $at, are
+// not reported.
+func (e *Executable) Lint(checks map[LintCheck]bool) []LintWarning {
+	l := &linter{exc: e, graph: e.flowGraph()}
+	if checks[DelaySlotCheck] {
+		l.checkDelaySlots()
+	}
+	if checks[ZeroWriteCheck] {
+		l.checkZeroWrites()
+	}
+	if checks[ReservedRegisterCheck] {
+		l.checkReservedRegisters()
+	}
+	if checks[UninitializedCheck] {
+		l.checkUninitialized()
+	}
+	if checks[UnreachableCheck] {
+		l.checkUnreachable()
+	}
+	if checks[MisalignedStackCheck] {
+		l.checkMisalignedStack()
+	}
+	if checks[CalleeSavedCheck] {
+		l.checkCalleeSaved()
+	}
+	if checks[UnusedLabelCheck] {
+		l.checkUnusedLabels()
+	}
+	sort.SliceStable(l.warnings, func(i, j int) bool {
+		return l.warnings[i].Address < l.warnings[j].Address
+	})
+	return l.warnings
+}
+
+type linter struct {
+	exc      *Executable
+	graph    *flowGraph
+	warnings []LintWarning
+}
+
+func (l *linter) warn(check LintCheck, addr uint32, message, hint string) {
+	w := LintWarning{Check: check, Address: addr, Message: message, Hint: hint}
+	if pos, ok := l.exc.LineTable[addr]; ok {
+		w.Position = &pos
+	}
+	l.warnings = append(l.warnings, w)
+}
+
+// generated returns true if the instruction at an address was added by the assembler rather
+// than written in the source code.
+func (l *linter) generated(addr uint32) bool {
+	return l.exc.LineTable[addr].Expansion != ""
+}
+
+func (l *linter) checkDelaySlots() {
+	for _, slot := range l.graph.addresses() {
+		branch, ok := l.graph.delaySlots[slot]
+		inst := l.exc.Get(slot)
+		if !ok || !inst.HasDelaySlot() {
+			continue
+		}
+		branchName := l.exc.Get(branch).Name
+		l.warn(DelaySlotCheck, slot, inst.Name+" in the delay slot of "+branchName,
+			"insert a NOP after the "+branchName)
+	}
+}
+
+func (l *linter) checkZeroWrites() {
+	for _, addr := range l.graph.addresses() {
+		inst := l.exc.Get(addr)
+		if inst.Name == "SLL" && inst.Registers[0] == 0 && inst.Registers[1] == 0 &&
+			inst.Constant5 == 0 {
+			// This is the encoding of NOP.
+			continue
+		}
+		if containsRegister(inst.WrittenRegisters(), 0) {
+			l.warn(ZeroWriteCheck, addr, inst.Name+" writes to $zero, so it has no effect", "")
+		}
+	}
+}
+
+func (l *linter) checkReservedRegisters() {
+	for _, addr := range l.graph.addresses() {
+		if l.generated(addr) {
+			continue
+		}
+		inst := l.exc.Get(addr)
+		used := append(inst.ReadRegisters(), inst.WrittenRegisters()...)
+		if containsRegister(used, 1) {
+			l.warn(ReservedRegisterCheck, addr, "$at is reserved for the assembler",
+				"the assembler may overwrite it when it expands instructions or relaxes branches")
+		} else if containsRegister(used, 26) || containsRegister(used, 27) {
+			l.warn(ReservedRegisterCheck, addr, "$k0 and $k1 are reserved for the kernel",
+				"interrupt and exception handlers may overwrite them at any time")
+		}
+	}
+}
+
+// checkUninitialized finds reads of registers whose values may come from the start of a
+// function, other than the registers which are expected to hold values there.
+func (l *linter) checkUninitialized() {
+	flow := l.exc.DataFlow()
+	for _, addr := range l.graph.addresses() {
+		for _, reg := range flow.reads(addr).Registers() {
+			if lintInitialRegisters.Contains(reg) {
+				continue
+			}
+			for _, def := range flow.ReachingDefinitions(addr, reg) {
+				if def.Kind == EntryDefinition {
+					l.warn(UninitializedCheck, addr, "$"+abiRegisterNames[reg]+
+						" may be used before it is set", "")
+					break
+				}
+			}
+		}
+	}
+}
+
+func (l *linter) checkUnreachable() {
+	labeled := map[uint32]bool{}
+	for _, addr := range l.exc.Symbols {
+		labeled[addr] = true
+	}
+	for _, addr := range l.graph.addresses() {
+		inst := l.exc.Get(addr)
+		if inst.Name != "J" && inst.Name != "JR" && !alwaysBranches(inst) {
+			continue
+		}
+		next := addr + 8
+		nextInst := l.exc.Get(next)
+		if nextInst == nil || nextInst.Name == ".word" || labeled[next] {
+			continue
+		} else if _, reached := l.graph.successors[next]; reached {
+			continue
+		}
+		l.warn(UnreachableCheck, next, "unreachable code after "+inst.Name,
+			"remove it, or give it a label if it is reached some other way")
+	}
+}
+
+func (l *linter) checkMisalignedStack() {
+	for _, addr := range l.graph.addresses() {
+		inst := l.exc.Get(addr)
+		if inst.Name != "LW" && inst.Name != "SW" {
+			continue
+		}
+		ref := inst.MemoryReference
+		if (ref.Register == 28 || ref.Register == 29) && ref.Offset&3 != 0 {
+			l.warn(MisalignedStackCheck, addr, "misaligned "+inst.Name+" offset from $"+
+				abiRegisterNames[ref.Register]+": "+strconv.Itoa(int(ref.Offset)),
+				"$sp and $gp are word aligned, so word offsets from them must be multiples of 4")
+		}
+	}
+}
+
+// checkCalleeSaved finds functions which change a callee-saved register, unless they store it
+// relative to $sp or $fp and load it back somewhere in the function.
+// The entry point is not checked, since it is not called by other code.
+func (l *linter) checkCalleeSaved() {
+	for _, start := range l.graph.functions[1:] {
+		body := l.graph.function(start)
+		var addrs uint32List
+		for addr := range body {
+			addrs = append(addrs, addr)
+		}
+		sort.Sort(addrs)
+
+		for _, reg := range lintCalleeSaved {
+			var saved, restored, changed bool
+			var firstChange uint32
+			for _, addr := range addrs {
+				inst := l.exc.Get(addr)
+				stackRef := inst.MemoryReference.Register == 29 ||
+					inst.MemoryReference.Register == 30
+				if inst.Name == "SW" && inst.Registers[0] == reg && stackRef {
+					saved = true
+				} else if inst.Name == "LW" && inst.Registers[0] == reg && stackRef {
+					restored = true
+				} else if containsRegister(inst.WrittenRegisters(), reg) && !changed {
+					changed = true
+					firstChange = addr
+				}
+			}
+			if changed && !(saved && restored) {
+				name := "$" + abiRegisterNames[reg]
+				l.warn(CalleeSavedCheck, firstChange, l.functionName(start)+" changes "+name+
+					" without saving and restoring it",
+					"callers expect "+name+" to be unchanged; store it on the stack first and "+
+						"load it back before returning")
+			}
+		}
+	}
+}
+
+func (l *linter) checkUnusedLabels() {
+	used := map[uint32]bool{l.exc.Entry: true}
+	usedNames := map[string]bool{}
+	for _, f := range l.graph.functions {
+		used[f] = true
+	}
+	for _, start := range l.exc.sortedSegmentAddresses() {
+		insts := l.exc.Segments[start]
+		for i := range insts {
+			inst := &insts[i]
+			addr := start + uint32(i*4)
+			if inst.AddressHalf != nil {
+				usedNames[inst.AddressHalf.Symbol] = true
+			}
+			if inst.IsBranch() || inst.Name == "J" || inst.Name == "JAL" {
+				if inst.CodePointer.IsSymbol {
+					usedNames[inst.CodePointer.Symbol] = true
+				} else if target, ok := codeTarget(inst, addr, l.exc.Symbols); ok {
+					used[target] = true
+				}
+			}
+			// Data like ".word label" holds the addresses of the labels it uses. Words which
+			// are never run are treated as data, even if they decode as instructions.
+			_, reached := l.graph.successors[addr]
+			if inst.Name == ".word" || !reached {
+				if word, err := inst.Encode(addr, l.exc.Symbols); err == nil {
+					used[word] = true
+				}
+			}
+			// A LUI followed by an ORI or ADDIU of the same register loads an address, as
+			// "la" does.
+			if inst.Name == "LUI" && i+1 < len(insts) {
+				next := &insts[i+1]
+				if (next.Name == "ORI" || next.Name == "ADDIU") &&
+					next.Registers[1] == inst.Registers[0] {
+					constants := map[int]uint32{}
+					trackConstant(inst, constants)
+					trackConstant(next, constants)
+					if value, ok := constants[next.Registers[0]]; ok {
+						used[value] = true
+					}
+				}
+			}
+		}
+	}
+	for _, pair := range l.exc.sortedSymbolAddrPairs() {
+		if strings.HasPrefix(pair.Symbol, ".") || usedNames[pair.Symbol] ||
+			used[pair.Address] || l.exc.Get(pair.Address) == nil {
+			continue
+		}
+		l.warn(UnusedLabelCheck, pair.Address, "label "+pair.Symbol+" is never used", "")
+	}
+}
+
+// functionName returns a symbol for the start of a function, or its address if it has none.
+func (l *linter) functionName(addr uint32) string {
+	var name string
+	for symbol, symbolAddr := range l.exc.Symbols {
+		if symbolAddr == addr && (name == "" || symbol < name) {
+			name = symbol
+		}
+	}
+	if name == "" {
+		return hexAddress(addr)
+	}
+	return name
+}
@@ -0,0 +1,140 @@
+package mips32
+
+import "testing"
+
+func TestLint(t *testing.T) {
+	lines, err := TokenizeSource(`.text 0x400100
+main:
+	ADDIU $t0, $0, 3
+	ADDU $v0, $t1, $t0
+	ADDIU $0, $t0, 1
+	LW $t2, 6($sp)
+	ADDU $at, $t0, $t0
+	JAL helper
+	NOP
+	ADDU $a0, $v0, $0
+	JAL saver
+	NOP
+	BEQ $t0, $0, main
+	J done
+	NOP
+	J done
+	NOP
+	ADDIU $t3, $0, 1
+unused:
+	NOP
+done:
+	J done
+	NOP
+helper:
+	ADDIU $s0, $0, 5
+	JR $ra
+	NOP
+saver:
+	SW $s1, 0($sp)
+	ADDIU $s1, $0, 5
+	LW $s1, 0($sp)
+	JR $ra
+	NOP`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	exc, err := ParseExecutable(lines)
+	if err != nil {
+		t.Fatal(err)
+	}
+	exc.Entry = exc.Symbols["main"]
+
+	expected := []struct {
+		check LintCheck
+		line  int
+	}{
+		{UninitializedCheck, 4},
+		{ZeroWriteCheck, 5},
+		{MisalignedStackCheck, 6},
+		{ReservedRegisterCheck, 7},
+		{DelaySlotCheck, 14},
+		{UnreachableCheck, 18},
+		{UnusedLabelCheck, 20},
+		{CalleeSavedCheck, 25},
+	}
+	warnings := exc.Lint(AllLintChecks())
+	if len(warnings) != len(expected) {
+		t.Fatal("unexpected warnings:", warnings)
+	}
+	for i, x := range expected {
+		w := warnings[i]
+		if w.Check != x.check || w.Position == nil || w.Position.Line != x.line {
+			t.Errorf("warning %d: expected %s on line %d but got %s", i, x.check, x.line,
+				w.String())
+		}
+	}
+
+	warnings = exc.Lint(map[LintCheck]bool{ZeroWriteCheck: true})
+	if len(warnings) != 1 || warnings[0].Check != ZeroWriteCheck {
+		t.Error("unexpected warnings:", warnings)
+	}
+}
+
+func TestParseLintChecks(t *testing.T) {
+	checks, err := ParseLintChecks("zero-write, unused-label")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(checks) != 2 || !checks[ZeroWriteCheck] || !checks[UnusedLabelCheck] {
+		t.Error("unexpected result:", checks)
+	}
+	if checks, err := ParseLintChecks("all"); err != nil || len(checks) != 8 {
+		t.Error("unexpected result:", checks, err)
+	}
+	if _, err := ParseLintChecks("zero-write,bogus"); err == nil {
+		t.Error("expected an error")
+	}
+}
+
+func TestLintUnusedLabels(t *testing.T) {
+	// The encoding of "JR $ra" is 0x03e00008, which must not count as a use of lonely.
+	exc := parseTestExecutable(t, `.text 0x400100
+main:
+	LUI $t0, 0x40
+	ORI $t0, $t0, 0x118
+	JR $ra
+	NOP
+	.word target
+	NOP
+loaded:
+	NOP
+target:
+	NOP
+.text 0x3e00008
+lonely:
+	NOP`)
+	warnings := exc.Lint(map[LintCheck]bool{UnusedLabelCheck: true})
+	if len(warnings) != 1 || warnings[0].Address != 0x3e00008 {
+		t.Error("unexpected warnings:", warnings)
+	}
+}
+
+func TestLintConstantJump(t *testing.T) {
+	exc := parseTestExecutable(t, `.text 0x400100
+main:
+	LUI $t0, 0x40
+	ORI $t0, $t0, 0x114
+	JR $t0
+	NOP
+	ADDIU $t1, $0, 1
+target:
+	ADDIU $v0, $t2, 1
+	JR $ra
+	NOP`)
+	warnings := exc.Lint(map[LintCheck]bool{UnreachableCheck: true, UninitializedCheck: true})
+	if len(warnings) != 2 {
+		t.Fatal("unexpected warnings:", warnings)
+	}
+	if warnings[0].Check != UnreachableCheck || warnings[0].Address != 0x400110 {
+		t.Error("unexpected warning:", warnings[0])
+	}
+	if warnings[1].Check != UninitializedCheck || warnings[1].Address != 0x400114 {
+		t.Error("unexpected warning:", warnings[1])
+	}
+}
@@ -0,0 +1,184 @@
+package mips32
+
+import (
+	"errors"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// A Listing relates each line of a program to the instructions it produced, in the style of a
+// classic assembler listing.
+type Listing struct {
+	Lines []ListingLine
+
+	// Symbols is sorted by name.
+	Symbols []ListingSymbol
+}
+
+// A ListingLine is a source line and the words it was assembled into.
+type ListingLine struct {
+	Source *TokenizedLine
+
+	// Addresses and Words give the address and encoding of each instruction produced by the
+	// line, in order of address. A line may produce several instructions (for example, a branch
+	// followed by a NOP in ".set reorder" mode), or none at all.
+	Addresses []uint32
+	Words     []uint32
+}
+
+// A ListingSymbol is a symbol along with the lines which define and use it.
+type ListingSymbol struct {
+	Name       string
+	Address    uint32
+	Definition *TokenizedLine
+	References []*TokenizedLine
+}
+
+// ParseExecutableListing is like ParseExecutableOptions, but it also produces a listing of the
+// program.
+func ParseExecutableListing(lines []TokenizedLine, options ParseOptions) (*Executable, *Listing,
+	error) {
+	p, err := parseExecutable(lines, options)
+	if err != nil {
+		return nil, nil, err
+	}
+	listing, err := p.listing(lines)
+	if err != nil {
+		return nil, nil, err
+	}
+	return p.res, listing, nil
+}
+
+func (p *executableParser) listing(lines []TokenizedLine) (*Listing, error) {
+	lineAddresses := map[*TokenizedLine][]uint32{}
+	for addr, line := range p.instructionLines {
+		lineAddresses[line] = append(lineAddresses[line], addr)
+	}
+
+	references := map[string][]*TokenizedLine{}
+	addReference := func(symbol string, line *TokenizedLine) {
+		refs := references[symbol]
+		if len(refs) == 0 || refs[len(refs)-1] != line {
+			references[symbol] = append(refs, line)
+		}
+	}
+	wordSymbols := map[uint32]string{}
+	for _, ws := range p.wordSymbols {
+		wordSymbols[ws.addr] = ws.symbol
+	}
+
+	res := &Listing{}
+	for i := range lines {
+		line := &lines[i]
+		listingLine := ListingLine{Source: line, Addresses: lineAddresses[line]}
+		sort.Sort(uint32List(listingLine.Addresses))
+		for _, addr := range listingLine.Addresses {
+			inst := p.res.Get(addr)
+			word, err := inst.Encode(addr, p.res.Symbols)
+			if err != nil {
+				return nil, errors.New(line.Location() + ": " + err.Error())
+			}
+			listingLine.Words = append(listingLine.Words, word)
+			if inst.CodePointer.IsSymbol {
+				addReference(inst.CodePointer.Symbol, line)
+			} else if inst.AddressHalf != nil {
+				addReference(inst.AddressHalf.Symbol, line)
+			} else if symbol, ok := wordSymbols[addr]; ok {
+				addReference(symbol, line)
+			}
+		}
+		res.Lines = append(res.Lines, listingLine)
+	}
+
+	for name, addr := range p.res.Symbols {
+		res.Symbols = append(res.Symbols, ListingSymbol{
+			Name:       name,
+			Address:    addr,
+			Definition: p.symbolLines[name],
+			References: references[name],
+		})
+	}
+	sort.Slice(res.Symbols, func(i, j int) bool {
+		return res.Symbols[i].Name < res.Symbols[j].Name
+	})
+	return res, nil
+}
+
+// String formats the listing as text.
+//
+// Each source line is given with the address and encoding of its first instruction, and any
+// further instructions follow on lines of their own. The symbol table at the end gives the
+// address of each symbol, the line which defines it, and the lines which use it.
+func (l *Listing) String() string {
+	locationWidth := len("Line")
+	for _, line := range l.Lines {
+		if n := len(listingLocation(line.Source)); n > locationWidth {
+			locationWidth = n
+		}
+	}
+
+	var res strings.Builder
+	res.WriteString("Address   Word      " + padRight("Line", locationWidth) + "  Source\n")
+	for _, line := range l.Lines {
+		addr, word := strings.Repeat(" ", 8), strings.Repeat(" ", 8)
+		if len(line.Addresses) > 0 {
+			addr, word = hexWord(line.Addresses[0]), hexWord(line.Words[0])
+		}
+		text := line.Source.Text
+		if text == "" {
+			text = line.Source.String()
+		}
+		res.WriteString(strings.TrimRight(addr+"  "+word+"  "+
+			padRight(listingLocation(line.Source), locationWidth)+"  "+text, " ") + "\n")
+		for i := 1; i < len(line.Addresses); i++ {
+			res.WriteString(hexWord(line.Addresses[i]) + "  " + hexWord(line.Words[i]) + "\n")
+		}
+	}
+
+	if len(l.Symbols) == 0 {
+		return res.String()
+	}
+	nameWidth := len("Symbol")
+	for _, sym := range l.Symbols {
+		if len(sym.Name) > nameWidth {
+			nameWidth = len(sym.Name)
+		}
+	}
+	definedWidth := locationWidth
+	if definedWidth < len("Defined") {
+		definedWidth = len("Defined")
+	}
+	res.WriteString("\n" + padRight("Symbol", nameWidth) + "  Address   " +
+		padRight("Defined", definedWidth) + "  Referenced\n")
+	for _, sym := range l.Symbols {
+		defined := ""
+		if sym.Definition != nil {
+			defined = listingLocation(sym.Definition)
+		}
+		var refs []string
+		for _, ref := range sym.References {
+			refs = append(refs, listingLocation(ref))
+		}
+		res.WriteString(strings.TrimRight(padRight(sym.Name, nameWidth)+"  "+
+			hexWord(sym.Address)+"  "+padRight(defined, definedWidth)+"  "+
+			strings.Join(refs, ", "), " ") + "\n")
+	}
+	return res.String()
+}
+
+// listingLocation is like TokenizedLine.Location, but gives a bare line number for lines which
+// have no file.
+func listingLocation(line *TokenizedLine) string {
+	if line.File == "" {
+		return strconv.Itoa(line.LineNumber)
+	}
+	return line.File + ":" + strconv.Itoa(line.LineNumber)
+}
+
+func padRight(s string, width int) string {
+	if len(s) >= width {
+		return s
+	}
+	return s + strings.Repeat(" ", width-len(s))
+}
@@ -0,0 +1,58 @@
+package mips32
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestParseExecutableListing(t *testing.T) {
+	lines, err := TokenizeSource(`.text 0x80000000
+main: ORI $t0, $0, 3 # counter
+.set reorder
+1:    ADDIU $t0, $t0, -1
+      BNE $t0, $0, 1b
+      LUI $t1, %hi(table)
+      J main
+table: .word main`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, listing, err := ParseExecutableListing(lines, ParseOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := `Address   Word      Line  Source
+                    1     .text 0x80000000
+80000000  34080003  2     main: ORI $t0, $0, 3 # counter
+                    3     .set reorder
+80000004  2508ffff  4     1:    ADDIU $t0, $t0, -1
+80000008  1500fffe  5           BNE $t0, $0, 1b
+8000000c  00000000
+80000010  3c098000  6           LUI $t1, %hi(table)
+80000014  08000000  7           J main
+80000018  00000000
+8000001c  80000000  8     table: .word main
+
+Symbol  Address   Defined  Referenced
+.L1.1   80000004  4        5
+main    80000000  2        7, 8
+table   8000001c  8        6
+`
+	if actual := listing.String(); actual != expected {
+		t.Errorf("unexpected listing:\n%s", actual)
+	}
+	if len(listing.Lines) != 8 || len(listing.Lines[4].Addresses) != 2 {
+		t.Error("unexpected listing lines:", listing.Lines)
+	}
+}
+
+func TestParseExecutableListingErrors(t *testing.T) {
+	lines, err := TokenizeSource("J nowhere")
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, _, err = ParseExecutableListing(lines, ParseOptions{})
+	if err == nil || !strings.Contains(err.Error(), "nowhere") {
+		t.Error("unexpected error:", err)
+	}
+}
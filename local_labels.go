@@ -0,0 +1,74 @@
+package mips32
+
+import (
+	"errors"
+	"regexp"
+	"strconv"
+)
+
+var (
+	localLabelRegexp     = regexp.MustCompile("^[0-9]+$")
+	localReferenceRegexp = regexp.MustCompile("^([0-9]+)([bf])$")
+)
+
+// localLabels tracks GNU-style numeric local labels while a program is parsed.
+//
+// A numeric label like "1:" may be declared any number of times.
+// The reference "1b" refers to the closest preceding declaration of "1", and "1f" refers to the
+// closest following one.
+// Every declaration is given a unique symbol name, so that the resulting Executable still has a
+// unique name for every symbol.
+type localLabels struct {
+	seen  map[string]int
+	total map[string]int
+}
+
+func newLocalLabels(lines []TokenizedLine) *localLabels {
+	res := &localLabels{seen: map[string]int{}, total: map[string]int{}}
+	for _, line := range lines {
+		if line.SymbolMarker != nil && localLabelRegexp.MatchString(*line.SymbolMarker) {
+			res.total[*line.SymbolMarker]++
+		}
+	}
+	return res
+}
+
+// isLocal returns true if the symbol marker is a numeric local label.
+func (l *localLabels) isLocal(marker string) bool {
+	return localLabelRegexp.MatchString(marker)
+}
+
+// declare records the next declaration of a numeric label and returns its unique name.
+func (l *localLabels) declare(label string) string {
+	l.seen[label]++
+	return localLabelName(label, l.seen[label])
+}
+
+// resolve translates a code pointer which refers to a local label into one which refers to the
+// label's unique name.
+// Code pointers which do not refer to local labels are returned unchanged.
+func (l *localLabels) resolve(ptr CodePointer) (CodePointer, error) {
+	if !ptr.IsSymbol {
+		return ptr, nil
+	}
+	match := localReferenceRegexp.FindStringSubmatch(ptr.Symbol)
+	if match == nil {
+		return ptr, nil
+	}
+	label := match[1]
+	index := l.seen[label]
+	if match[2] == "f" {
+		index++
+	}
+	if index == 0 || index > l.total[label] {
+		return ptr, errors.New("undefined local label: " + ptr.Symbol)
+	}
+	ptr.Symbol = localLabelName(label, index)
+	return ptr, nil
+}
+
+// localLabelName generates the symbol name for the n-th declaration of a numeric label.
+// These names cannot collide with numeric labels or references to them.
+func localLabelName(label string, n int) string {
+	return ".L" + label + "." + strconv.Itoa(n)
+}
@@ -7,12 +7,67 @@ import (
 	"os"
 
 	"github.com/unixpickle/mips32"
+	"github.com/unixpickle/mips32/internal/cli"
 )
 
 func main() {
 	var littleEndian bool
 	flag.BoolVar(&littleEndian, "little", false, "encode instructions as little endian")
 
+	var hoistDelaySlots bool
+	flag.BoolVar(&hoistDelaySlots, "hoist", false,
+		"fill delay slots in .set reorder mode with preceding instructions when safe")
+
+	var relaxBranches bool
+	flag.BoolVar(&relaxBranches, "relax", false,
+		"rewrite out-of-range branches and jumps to jump through $at")
+
+	var relocatable bool
+	flag.BoolVar(&relocatable, "c", false, "write a relocatable object for mips-ld")
+
+	var outputFormat string
+	flag.StringVar(&outputFormat, "format", "",
+		"output format: raw, elf, ihex, srec, memh, or c (with -c: object or elf)")
+
+	var elfOutput bool
+	flag.BoolVar(&elfOutput, "elf", false, "same as -format elf")
+
+	var baseAddress uint64
+	flag.Uint64Var(&baseAddress, "base", 0,
+		"address where raw output starts; pass the same -base to mips-run and mips-disas")
+
+	var entrySymbol string
+	flag.StringVar(&entrySymbol, "entry", "",
+		"symbol to use as the ELF entry point (default: 0, or main, start, or the lowest "+
+			"address if there is no code at 0)")
+
+	var listingFile string
+	flag.StringVar(&listingFile, "listing", "", "write an assembly listing to a file")
+
+	var lineTableFile string
+	flag.StringVar(&lineTableFile, "lines", "",
+		"write a table of source positions for mips-run -lines")
+
+	var optimize bool
+	flag.BoolVar(&optimize, "O", false,
+		"remove and combine instructions with the peephole optimizer (see also -abi)")
+
+	var optimizeOptions mips32.OptimizeOptions
+	flag.BoolVar(&optimizeOptions.AssumeCallingConvention, "abi", false,
+		"with -O, assume every function follows the standard calling convention, so that "+
+			"writes to registers it need not preserve can be removed")
+
+	var verifyTrials int
+	flag.IntVar(&verifyTrials, "verify", 0,
+		"with -O, run the original and optimized programs in this many random trials and fail "+
+			"if they differ")
+
+	var includePaths cli.StringList
+	flag.Var(&includePaths, "I", "add a directory to the .include search path")
+
+	var defines cli.StringList
+	flag.Var(&defines, "D", "define NAME=value (or NAME as 1) for conditional assembly")
+
 	flag.Parse()
 	if len(flag.Args()) != 2 {
 		dieUsage()
@@ -21,52 +76,193 @@ func main() {
 	inFile := flag.Args()[0]
 	outFile := flag.Args()[1]
 
-	source, err := ioutil.ReadFile(inFile)
+	if elfOutput {
+		if outputFormat != "" && outputFormat != "elf" {
+			fmt.Fprintln(os.Stderr, "-elf cannot be used with -format "+outputFormat)
+			os.Exit(1)
+		}
+		outputFormat = "elf"
+	}
+
+	preprocessor := &mips32.Preprocessor{IncludePaths: includePaths}
+	if littleEndian {
+		preprocessor.Define("__MIPSEL__")
+	} else {
+		preprocessor.Define("__MIPSEB__")
+	}
+	for _, definition := range defines {
+		if err := preprocessor.Define(definition); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+	}
+	tokenized, err := preprocessor.TokenizeFile(inFile)
 	if err != nil {
 		fmt.Fprintln(os.Stderr, err)
 		os.Exit(1)
 	}
 
-	tokenized, err := mips32.TokenizeSource(string(source))
+	options := mips32.ParseOptions{
+		HoistDelaySlots: hoistDelaySlots,
+		WarningHandler:  printWarning,
+		RelaxBranches:   relaxBranches,
+	}
+	if (verifyTrials != 0 || optimizeOptions.AssumeCallingConvention) && !optimize {
+		fmt.Fprintln(os.Stderr, "-verify and -abi require -O")
+		os.Exit(1)
+	} else if optimize && listingFile != "" {
+		fmt.Fprintln(os.Stderr, "listings cannot be written for optimized programs")
+		os.Exit(1)
+	}
+	if relocatable {
+		if listingFile != "" || lineTableFile != "" {
+			fmt.Fprintln(os.Stderr, "listings and line tables cannot be written for relocatable "+
+				"objects")
+			os.Exit(1)
+		} else if optimize {
+			fmt.Fprintln(os.Stderr, "relocatable objects cannot be optimized")
+			os.Exit(1)
+		}
+		writeObject(tokenized, options, outFile, outputFormat, littleEndian)
+		return
+	}
+
+	var executable *mips32.Executable
+	if listingFile != "" {
+		var listing *mips32.Listing
+		executable, listing, err = mips32.ParseExecutableListing(tokenized, options)
+		if err == nil {
+			err = ioutil.WriteFile(listingFile, []byte(listing.String()), 0644)
+		}
+	} else {
+		executable, err = mips32.ParseExecutableOptions(tokenized, options)
+	}
 	if err != nil {
 		fmt.Fprintln(os.Stderr, err)
 		os.Exit(1)
 	}
 
-	executable, err := mips32.ParseExecutable(tokenized)
+	if entrySymbol != "" {
+		addr, ok := executable.Symbols[entrySymbol]
+		if !ok {
+			fmt.Fprintln(os.Stderr, "undefined entry symbol:", entrySymbol)
+			os.Exit(1)
+		}
+		executable.Entry = addr
+	}
+
+	if optimize {
+		optimizeExecutable(executable, optimizeOptions, verifyTrials)
+	}
+
+	if lineTableFile != "" {
+		writeLineTable(executable.LineTable, lineTableFile)
+	}
+
+	format := mips32.RawFormat
+	if outputFormat != "" {
+		format, err = mips32.ParseImageFormat(outputFormat)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+	}
+	if baseAddress > 0xffffffff {
+		fmt.Fprintln(os.Stderr, "base address out of range:", baseAddress)
+		os.Exit(1)
+	} else if baseAddress != 0 && format != mips32.RawFormat {
+		fmt.Fprintln(os.Stderr, "-base only applies to raw output")
+		os.Exit(1)
+	}
+
+	output, err := os.Create(outFile)
 	if err != nil {
 		fmt.Fprintln(os.Stderr, err)
 		os.Exit(1)
 	}
+	defer output.Close()
 
+	if format == mips32.RawFormat {
+		err = mips32.WriteRawImage(output, executable, uint32(baseAddress), littleEndian)
+	} else {
+		err = mips32.WriteImage(output, executable, format, littleEndian)
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+}
+
+func writeLineTable(table mips32.LineTable, outFile string) {
 	output, err := os.Create(outFile)
 	if err != nil {
 		fmt.Fprintln(os.Stderr, err)
 		os.Exit(1)
 	}
 	defer output.Close()
+	if err := mips32.WriteLineTable(output, table); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+}
 
-	for addr := uint32(0); addr < executable.End(); addr += 4 {
-		inst := executable.Get(addr)
-		if inst == nil {
-			output.Write([]byte{0, 0, 0, 0})
-			continue
-		}
-		enc, err := inst.Encode(addr, executable.Symbols)
-		if err != nil {
-			fmt.Fprintln(os.Stderr, err)
+// optimizeExecutable runs the optimizer, and checks the result against the original program if
+// trials is not 0.
+// If the entry point is not code, the optimizer starts from main, start, or the lowest address.
+func optimizeExecutable(exc *mips32.Executable, options mips32.OptimizeOptions, trials int) {
+	entry := exc.Entry
+	noEntry := exc.EntryPoint() != entry
+	if noEntry {
+		exc.Entry = exc.EntryPoint()
+	}
+	original := exc.Copy()
+	mips32.OptimizeWithOptions(exc, options)
+	if trials != 0 {
+		if err := mips32.VerifyOptimization(original, exc, options, trials, 1); err != nil {
+			fmt.Fprintln(os.Stderr, "optimization changed the program:", err)
 			os.Exit(1)
 		}
-		if littleEndian {
-			output.Write([]byte{byte(enc), byte(enc >> 8), byte(enc >> 16), byte(enc >> 24)})
-		} else {
-			output.Write([]byte{byte(enc >> 24), byte(enc >> 16), byte(enc >> 8), byte(enc)})
-		}
+	}
+	if noEntry {
+		exc.Entry = entry
+	}
+}
+
+func writeObject(tokenized []mips32.TokenizedLine, options mips32.ParseOptions, outFile string,
+	outputFormat string, littleEndian bool) {
+	if outputFormat != "" && outputFormat != "object" && outputFormat != "elf" {
+		fmt.Fprintln(os.Stderr, "relocatable objects can only be written as object or elf")
+		os.Exit(1)
+	}
+	object, err := mips32.ParseObject(tokenized, options)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	output, err := os.Create(outFile)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	defer output.Close()
+	if outputFormat == "elf" {
+		err = mips32.WriteELFObject(output, object, littleEndian)
+	} else {
+		err = mips32.WriteObject(output, object)
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
 	}
 }
 
 func dieUsage() {
-	fmt.Fprintln(os.Stderr, "Usage:", os.Args[0], "[flags] <in.s> <out.bin>")
+	fmt.Fprintln(os.Stderr, "Usage:", os.Args[0], "[flags] <in.s> <out.bin|out.o|image>")
 	flag.PrintDefaults()
 	os.Exit(1)
 }
+
+// printWarning prints a warning in the same format as errors, without stopping assembly.
+func printWarning(d mips32.Diagnostic) {
+	fmt.Fprintln(os.Stderr, d.String())
+}
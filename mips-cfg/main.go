@@ -0,0 +1,126 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/unixpickle/mips32"
+	"github.com/unixpickle/mips32/cfg"
+	"github.com/unixpickle/mips32/internal/cli"
+)
+
+func main() {
+	var functionNames cli.StringList
+	flag.Var(&functionNames, "function", "only draw the named function (repeatable)")
+
+	var littleEndian bool
+	flag.BoolVar(&littleEndian, "little", false,
+		"read binaries as little endian (ELF files give their own byte order)")
+
+	var inputFormat string
+	flag.StringVar(&inputFormat, "format", "",
+		"input format: asm, raw, elf, ihex, srec, memh, or c (default: guess from the file)")
+
+	var baseAddress uint64
+	flag.Uint64Var(&baseAddress, "base", 0, "load address for raw binaries")
+
+	var entryPoint string
+	flag.StringVar(&entryPoint, "entry", "",
+		"address or symbol where the program starts (default: the program's entry point, or "+
+			"main, start, or the lowest address if there is no code there)")
+
+	var symbolFile string
+	flag.StringVar(&symbolFile, "symbols", "",
+		"name functions and branch targets using a symbol file in nm format")
+
+	var includePaths cli.StringList
+	flag.Var(&includePaths, "I", "add a directory to the .include search path")
+
+	var defines cli.StringList
+	flag.Var(&defines, "D", "define NAME=value (or NAME as 1) for conditional assembly")
+
+	flag.Parse()
+	if len(flag.Args()) != 1 && len(flag.Args()) != 2 {
+		dieUsage()
+	}
+	file := flag.Args()[0]
+	if baseAddress > 0xffffffff {
+		fmt.Fprintln(os.Stderr, "base address out of range:", baseAddress)
+		os.Exit(1)
+	}
+
+	image, err := mips32.LoadProgram(file, mips32.ProgramOptions{
+		Format:       inputFormat,
+		LittleEndian: littleEndian,
+		Base:         uint32(baseAddress),
+		IncludePaths: includePaths,
+		Defines:      defines,
+	})
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	exc := image.Executable
+	if symbolFile != "" {
+		for name, addr := range readSymbolFile(symbolFile) {
+			exc.Symbols[name] = addr
+		}
+	}
+	if entryPoint != "" {
+		exc.Entry, err = exc.ResolveEntry(entryPoint)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+	} else {
+		exc.Entry = exc.EntryPoint()
+	}
+
+	graph := cfg.Build(exc)
+	var functions []*cfg.Function
+	for _, name := range functionNames {
+		f := graph.Function(name)
+		if f == nil {
+			fmt.Fprintln(os.Stderr, "unknown function:", name)
+			os.Exit(1)
+		}
+		functions = append(functions, f)
+	}
+
+	output := os.Stdout
+	if len(flag.Args()) == 2 {
+		f, err := os.Create(flag.Args()[1])
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		defer f.Close()
+		output = f
+	}
+	if err := graph.WriteDOT(output, functions); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+}
+
+func readSymbolFile(file string) map[string]uint32 {
+	f, err := os.Open(file)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	defer f.Close()
+	symbols, err := mips32.ReadSymbolFile(f)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, file+":", err)
+		os.Exit(1)
+	}
+	return symbols
+}
+
+func dieUsage() {
+	fmt.Fprintln(os.Stderr, "Usage:", os.Args[0], "[flags] <file.s|image> [output.dot]")
+	flag.PrintDefaults()
+	os.Exit(1)
+}
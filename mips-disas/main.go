@@ -1,17 +1,62 @@
 package main
 
 import (
+	"bytes"
 	"flag"
 	"fmt"
 	"io/ioutil"
 	"os"
+	"sort"
 
 	"github.com/unixpickle/mips32"
 )
 
 func main() {
 	var littleEndian bool
-	flag.BoolVar(&littleEndian, "little", false, "decode instructions as little endian")
+	flag.BoolVar(&littleEndian, "little", false,
+		"decode instructions as little endian (ELF files give their own byte order)")
+
+	var inputFormat string
+	flag.StringVar(&inputFormat, "format", "",
+		"input format: raw, elf, ihex, srec, memh, or c (default: guess from the file)")
+
+	var baseAddress uint64
+	flag.Uint64Var(&baseAddress, "base", 0, "load address for raw binaries")
+
+	var symbolFile string
+	flag.StringVar(&symbolFile, "symbols", "",
+		"name branch and jump targets using a symbol file in nm format")
+
+	var annotateDelaySlots bool
+	flag.BoolVar(&annotateDelaySlots, "delayslots", false, "mark instructions in delay slots")
+
+	var trace bool
+	flag.BoolVar(&trace, "trace", false,
+		"follow control flow from the entry point and symbols, and print unreached words as data")
+
+	var registerStyle string
+	flag.StringVar(&registerStyle, "registers", "numeric",
+		"register names: numeric ($8), abi ($t0), or r ($r8)")
+
+	var hexArguments string
+	flag.StringVar(&hexArguments, "hex", "", "comma-separated kinds of constants to print in "+
+		"hexadecimal (signed16, unsigned16, shift5, target, label, offset, constant32, or all)")
+
+	var renderOptions mips32.RenderOptions
+	flag.BoolVar(&renderOptions.FoldIdioms, "pseudo", false,
+		"print common instruction sequences as pseudo-instructions like MOVE, LI and B")
+	flag.BoolVar(&renderOptions.HexDirectives, "hexdirectives", false,
+		"print directive constants in hexadecimal")
+	flag.BoolVar(&renderOptions.LowercaseMnemonics, "lower", false, "print lower case mnemonics")
+	flag.IntVar(&renderOptions.MnemonicWidth, "align", 0,
+		"pad mnemonics to this width so that operands line up")
+	flag.BoolVar(&renderOptions.CompactOperands, "compact", false,
+		"separate operands with commas but no spaces")
+	flag.IntVar(&renderOptions.CommentColumn, "commentcolumn", 0, "column to start comments in")
+
+	var objdump bool
+	flag.BoolVar(&objdump, "objdump", false,
+		"print addresses, encodings and resolved targets in columns instead of assembly")
 
 	flag.Parse()
 	if len(flag.Args()) != 2 {
@@ -20,28 +65,93 @@ func main() {
 
 	inFile := flag.Args()[0]
 	outFile := flag.Args()[1]
+	var err error
+	renderOptions.Registers, err = mips32.ParseRegisterStyle(registerStyle)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	renderOptions.HexArguments, err = mips32.ParseHexArguments(hexArguments)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	if baseAddress > 0xffffffff {
+		fmt.Fprintln(os.Stderr, "base address out of range:", baseAddress)
+		os.Exit(1)
+	}
 
 	binary, err := ioutil.ReadFile(inFile)
 	if err != nil {
 		fmt.Fprintln(os.Stderr, err)
 		os.Exit(1)
-	} else if len(binary)&3 != 0 {
-		fmt.Fprintln(os.Stderr, "file is invalid length (must be multiple of 4)")
-		os.Exit(1)
 	}
 
-	instructions := []*mips32.Instruction{}
-	for i := 0; i < len(binary); i += 4 {
-		var instNum uint32
-		if littleEndian {
-			instNum = uint32(binary[i]) | (uint32(binary[i+1]) << 8) | (uint32(binary[i+2]) << 16) |
-				(uint32(binary[i+3]) << 24)
-		} else {
-			instNum = (uint32(binary[i]) << 24) | (uint32(binary[i+1]) << 16) |
-				(uint32(binary[i+2]) << 8) | uint32(binary[i+3])
+	format, ok := mips32.GuessImageFormat(inFile, binary)
+	if inputFormat != "" {
+		format, err = mips32.ParseImageFormat(inputFormat)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+	} else if !ok {
+		format = mips32.RawFormat
+	}
+
+	var executable *mips32.Executable
+	if format == mips32.RawFormat {
+		if len(binary)&3 != 0 {
+			fmt.Fprintln(os.Stderr, "file is invalid length (must be multiple of 4)")
+			os.Exit(1)
+		}
+		executable = mips32.LoadRawImage(binary, uint32(baseAddress), littleEndian).Executable
+	} else {
+		image, err := mips32.ReadImage(bytes.NewReader(binary), format, littleEndian)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, inFile+":", err)
+			os.Exit(1)
+		}
+		executable = image.Executable
+	}
+
+	if symbolFile != "" {
+		for name, addr := range readSymbolFile(symbolFile) {
+			executable.Symbols[name] = addr
+		}
+	}
+
+	unresolved := map[uint32]bool{}
+	if trace {
+		codeTrace := executable.TraceFromSymbols()
+		if err := executable.MarkData(codeTrace); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		for _, addr := range codeTrace.UnresolvedJumps {
+			fmt.Fprintf(os.Stderr, "warning: could not resolve the target of the jump at 0x%08x\n",
+				addr)
+			unresolved[addr] = true
+		}
+	}
+
+	if objdump {
+		listing, err := executable.Objdump()
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
 		}
-		inst := mips32.DecodeInstruction(instNum)
-		instructions = append(instructions, inst)
+		if err := ioutil.WriteFile(outFile, []byte(listing), 0644); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		return
+	}
+
+	executable.SymbolizeTargets()
+	lines, err := executable.RenderWithOptions(renderOptions)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
 	}
 
 	output, err := os.Create(outFile)
@@ -51,19 +161,72 @@ func main() {
 	}
 	defer output.Close()
 
-	for _, inst := range instructions {
-		rendering, err := inst.Render()
-		if err != nil {
-			fmt.Fprintln(os.Stderr, err)
-			os.Exit(1)
+	addresses := orderedAddresses(executable)
+	var instIndex int
+	for _, line := range lines {
+		if line.Instruction != nil || (line.Directive != nil && line.Directive.Name == "word") {
+			addr := addresses[instIndex]
+			if unresolved[addr] {
+				comment := " unresolved jump target"
+				line.Comment = &comment
+			} else if annotateDelaySlots && instIndex > 0 &&
+				executable.Get(addresses[instIndex-1]).HasDelaySlot() {
+				comment := " delay slot"
+				line.Comment = &comment
+			}
+			instIndex += renderedSize(line)
 		}
-		output.WriteString(rendering.String())
+		output.WriteString(line.Format(renderOptions))
 		output.WriteString("\n")
 	}
 }
 
+func readSymbolFile(file string) map[string]uint32 {
+	f, err := os.Open(file)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	defer f.Close()
+	symbols, err := mips32.ReadSymbolFile(f)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, file+":", err)
+		os.Exit(1)
+	}
+	return symbols
+}
+
+// renderedSize counts the instructions which a rendered line stands for.
+func renderedSize(line mips32.TokenizedLine) int {
+	if line.Instruction != nil {
+		if inst, err := mips32.ParseTokenizedInstruction(line.Instruction); err == nil {
+			return len(inst.Expand())
+		}
+	}
+	return 1
+}
+
+// orderedAddresses lists the addresses of an executable's instructions in the order they are
+// rendered.
+func orderedAddresses(e *mips32.Executable) []uint32 {
+	var starts []uint32
+	for addr := range e.Segments {
+		starts = append(starts, addr)
+	}
+	sort.Slice(starts, func(i, j int) bool {
+		return starts[i] < starts[j]
+	})
+	var res []uint32
+	for _, start := range starts {
+		for i := range e.Segments[start] {
+			res = append(res, start+uint32(i*4))
+		}
+	}
+	return res
+}
+
 func dieUsage() {
-	fmt.Fprintln(os.Stderr, "Usage:", os.Args[0], "[flags] <in.bin> <out.s>")
+	fmt.Fprintln(os.Stderr, "Usage:", os.Args[0], "[flags] <in.bin|image> <out.s>")
 	flag.PrintDefaults()
 	os.Exit(1)
 }
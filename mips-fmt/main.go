@@ -0,0 +1,185 @@
+package main
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"reflect"
+
+	"github.com/unixpickle/mips32"
+	"github.com/unixpickle/mips32/internal/cli"
+)
+
+func main() {
+	var write bool
+	flag.BoolVar(&write, "w", false, "write the result back to each file instead of printing it")
+
+	var list bool
+	flag.BoolVar(&list, "l", false, "list the files whose formatting differs")
+
+	var registerStyle string
+	flag.StringVar(&registerStyle, "registers", "abi",
+		"register names: numeric ($8), abi ($t0), or r ($r8)")
+
+	options := mips32.RenderOptions{PreserveConstants: true}
+	flag.BoolVar(&options.LowercaseMnemonics, "lower", false, "write lower case mnemonics")
+	flag.IntVar(&options.MnemonicWidth, "align", 7,
+		"pad mnemonics to this width so that operands line up")
+	flag.IntVar(&options.CommentColumn, "commentcolumn", 33,
+		"column to start comments in, counted after the indentation")
+
+	var littleEndian bool
+	flag.BoolVar(&littleEndian, "little", false,
+		"assemble as little endian when checking that the output is unchanged")
+
+	var includePaths cli.StringList
+	flag.Var(&includePaths, "I", "add a directory to the .include search path")
+
+	var defines cli.StringList
+	flag.Var(&defines, "D", "define NAME=value (or NAME as 1) for conditional assembly")
+
+	flag.Parse()
+
+	var err error
+	options.Registers, err = mips32.ParseRegisterStyle(registerStyle)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+
+	preprocessor := &mips32.Preprocessor{IncludePaths: includePaths}
+	if littleEndian {
+		preprocessor.Define("__MIPSEL__")
+	} else {
+		preprocessor.Define("__MIPSEB__")
+	}
+	for _, definition := range defines {
+		if err := preprocessor.Define(definition); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+	}
+
+	if len(flag.Args()) == 0 {
+		if write || list {
+			dieUsage()
+		}
+		source, err := ioutil.ReadAll(os.Stdin)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		formatted := formatSource(preprocessor, "<stdin>", string(source), options)
+		os.Stdout.WriteString(formatted)
+		return
+	}
+
+	for _, file := range flag.Args() {
+		source, err := ioutil.ReadFile(file)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		formatted := formatSource(preprocessor, file, string(source), options)
+		if list && formatted != string(source) {
+			fmt.Println(file)
+		}
+		if write {
+			if formatted != string(source) {
+				if err := ioutil.WriteFile(file, []byte(formatted), 0644); err != nil {
+					fmt.Fprintln(os.Stderr, err)
+					os.Exit(1)
+				}
+			}
+		} else if !list {
+			os.Stdout.WriteString(formatted)
+		}
+	}
+}
+
+// formatSource formats a source file, and makes sure that it assembles to the same program
+// before and after.
+func formatSource(preprocessor *mips32.Preprocessor, file, source string,
+	options mips32.RenderOptions) string {
+	lines, err := mips32.TokenizeSource(source)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, file+":", err)
+		os.Exit(1)
+	}
+	for i := range lines {
+		lines[i].File = file
+	}
+	formatted, err := mips32.FormatSource(lines, options)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+
+	before, err := assemble(preprocessor, file, source)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "warning: "+file+": cannot assemble to check the result "+
+			"(only the tokens were compared):", err)
+		return formatted
+	}
+	after, err := assemble(preprocessor, file, formatted)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, file+": formatted source does not assemble:", err)
+		os.Exit(1)
+	} else if !reflect.DeepEqual(before, after) {
+		fmt.Fprintln(os.Stderr, file+": formatted source assembles to a different program")
+		os.Exit(1)
+	}
+	return formatted
+}
+
+// assembledProgram is the part of an assembled file which formatting must not change.
+type assembledProgram struct {
+	Words   map[uint32][]uint32
+	Symbols map[string]uint32
+	Object  []byte
+}
+
+// assemble assembles a source file as an executable, or as a relocatable object if it cannot be
+// assembled on its own.
+func assemble(preprocessor *mips32.Preprocessor, file, source string) (*assembledProgram,
+	error) {
+	lines, err := preprocessor.TokenizeSource(file, source)
+	if err != nil {
+		return nil, err
+	}
+	executable, execErr := mips32.ParseExecutable(lines)
+	if execErr == nil {
+		res := &assembledProgram{
+			Words:   map[uint32][]uint32{},
+			Symbols: executable.Symbols,
+		}
+		for start, insts := range executable.Segments {
+			for i := range insts {
+				addr := start + uint32(i*4)
+				word, err := insts[i].Encode(addr, executable.Symbols)
+				if err != nil {
+					return nil, err
+				}
+				res.Words[start] = append(res.Words[start], word)
+			}
+		}
+		return res, nil
+	}
+	object, err := mips32.ParseObject(lines, mips32.ParseOptions{})
+	if err != nil {
+		return nil, execErr
+	}
+	var buf bytes.Buffer
+	if err := mips32.WriteObject(&buf, object); err != nil {
+		return nil, err
+	}
+	return &assembledProgram{Object: buf.Bytes()}, nil
+}
+
+func dieUsage() {
+	fmt.Fprintln(os.Stderr, "Usage:", os.Args[0], "[flags] [file.s ...]")
+	flag.PrintDefaults()
+	os.Exit(1)
+}
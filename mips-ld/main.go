@@ -0,0 +1,120 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+
+	"github.com/unixpickle/mips32"
+)
+
+func main() {
+	var littleEndian bool
+	flag.BoolVar(&littleEndian, "little", false, "encode instructions as little endian")
+
+	var scriptFile string
+	flag.StringVar(&scriptFile, "T", "", "linker script with section addresses and ENTRY(symbol)")
+
+	var outputFormat string
+	flag.StringVar(&outputFormat, "format", "raw",
+		"output format: raw, elf, ihex, srec, memh, or c")
+
+	var elfOutput bool
+	flag.BoolVar(&elfOutput, "elf", false, "same as -format elf")
+
+	var baseAddress uint64
+	flag.Uint64Var(&baseAddress, "base", 0,
+		"address where raw output starts; pass the same -base to mips-run and mips-disas")
+
+	var outFile string
+	flag.StringVar(&outFile, "o", "a.bin", "output file")
+
+	flag.Parse()
+	if len(flag.Args()) == 0 {
+		dieUsage()
+	}
+
+	if elfOutput {
+		if outputFormat != "raw" && outputFormat != "elf" {
+			fmt.Fprintln(os.Stderr, "-elf cannot be used with -format "+outputFormat)
+			os.Exit(1)
+		}
+		outputFormat = "elf"
+	}
+	format, err := mips32.ParseImageFormat(outputFormat)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	if baseAddress > 0xffffffff {
+		fmt.Fprintln(os.Stderr, "base address out of range:", baseAddress)
+		os.Exit(1)
+	} else if baseAddress != 0 && format != mips32.RawFormat {
+		fmt.Fprintln(os.Stderr, "-base only applies to raw output")
+		os.Exit(1)
+	}
+
+	var script *mips32.LinkerScript
+	if scriptFile != "" {
+		source, err := ioutil.ReadFile(scriptFile)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		script, err = mips32.ParseLinkerScript(string(source))
+		if err != nil {
+			fmt.Fprintln(os.Stderr, scriptFile+":", err)
+			os.Exit(1)
+		}
+	}
+
+	var objects []*mips32.Object
+	for _, path := range flag.Args() {
+		object, err := readObject(path)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, path+":", err)
+			os.Exit(1)
+		}
+		object.Name = path
+		objects = append(objects, object)
+	}
+
+	executable, err := mips32.Link(objects, script)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+
+	output, err := os.Create(outFile)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	defer output.Close()
+
+	if format == mips32.RawFormat {
+		err = mips32.WriteRawImage(output, executable, uint32(baseAddress), littleEndian)
+	} else {
+		err = mips32.WriteImage(output, executable, format, littleEndian)
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+}
+
+func readObject(path string) (*mips32.Object, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return mips32.ReadObject(f)
+}
+
+func dieUsage() {
+	fmt.Fprintln(os.Stderr, "Usage:", os.Args[0], "[flags] <in.o> [in.o ...]")
+	flag.PrintDefaults()
+	os.Exit(1)
+}
@@ -0,0 +1,129 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/unixpickle/mips32"
+	"github.com/unixpickle/mips32/internal/cli"
+)
+
+func main() {
+	var checkList string
+	flag.StringVar(&checkList, "checks", "all",
+		"comma-separated checks to run: delay-slot, zero-write, reserved-register, "+
+			"uninitialized, unreachable, misaligned-stack, callee-saved, unused-label, or all")
+
+	var disableList string
+	flag.StringVar(&disableList, "disable", "", "comma-separated checks to leave out")
+
+	var jsonOutput bool
+	flag.BoolVar(&jsonOutput, "json", false, "print the warnings as a JSON array")
+
+	var littleEndian bool
+	flag.BoolVar(&littleEndian, "little", false,
+		"read binaries as little endian (ELF files give their own byte order)")
+
+	var inputFormat string
+	flag.StringVar(&inputFormat, "format", "",
+		"input format: asm, raw, elf, ihex, srec, memh, or c (default: guess from the file)")
+
+	var baseAddress uint64
+	flag.Uint64Var(&baseAddress, "base", 0, "load address for raw binaries")
+
+	var entryPoint string
+	flag.StringVar(&entryPoint, "entry", "",
+		"address or symbol where the program starts (default: the program's entry point, or "+
+			"main, start, or the lowest address if there is no code there)")
+
+	var lineTableFile string
+	flag.StringVar(&lineTableFile, "lines", "",
+		"read source positions written by mips-as -lines, to report warnings in the source")
+
+	var includePaths cli.StringList
+	flag.Var(&includePaths, "I", "add a directory to the .include search path")
+
+	var defines cli.StringList
+	flag.Var(&defines, "D", "define NAME=value (or NAME as 1) for conditional assembly")
+
+	flag.Parse()
+	if len(flag.Args()) != 1 {
+		dieUsage()
+	}
+	file := flag.Args()[0]
+	if baseAddress > 0xffffffff {
+		fmt.Fprintln(os.Stderr, "base address out of range:", baseAddress)
+		os.Exit(1)
+	}
+
+	checks, err := mips32.ParseLintChecks(checkList)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	disabled, err := mips32.ParseLintChecks(disableList)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	for check := range disabled {
+		delete(checks, check)
+	}
+
+	image, err := mips32.LoadProgram(file, mips32.ProgramOptions{
+		Format:       inputFormat,
+		LittleEndian: littleEndian,
+		Base:         uint32(baseAddress),
+		IncludePaths: includePaths,
+		Defines:      defines,
+	})
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	exc := image.Executable
+	if lineTableFile != "" {
+		exc.LineTable, err = mips32.ReadLineTableFile(lineTableFile)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+	}
+	if entryPoint != "" {
+		exc.Entry, err = exc.ResolveEntry(entryPoint)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+	} else {
+		exc.Entry = exc.EntryPoint()
+	}
+
+	warnings := exc.Lint(checks)
+	if jsonOutput {
+		if warnings == nil {
+			warnings = []mips32.LintWarning{}
+		}
+		data, err := json.MarshalIndent(warnings, "", "  ")
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		fmt.Println(string(data))
+	} else {
+		for _, w := range warnings {
+			fmt.Println(w.String())
+		}
+	}
+	if len(warnings) > 0 {
+		os.Exit(1)
+	}
+}
+
+func dieUsage() {
+	fmt.Fprintln(os.Stderr, "Usage:", os.Args[0], "[flags] <file.s|image>")
+	flag.PrintDefaults()
+	os.Exit(1)
+}
@@ -6,15 +6,18 @@ import (
 	"io/ioutil"
 	"os"
 	"strconv"
+	"strings"
 
 	"github.com/unixpickle/mips32"
+	"github.com/unixpickle/mips32/internal/cli"
 )
 
 const MemoryDumpColumns = 16
 
 func main() {
 	var littleEndian bool
-	flag.BoolVar(&littleEndian, "little", false, "use little endian memory")
+	flag.BoolVar(&littleEndian, "little", false,
+		"use little endian memory (ELF files give their own byte order)")
 
 	var relaxAlignment bool
 	flag.BoolVar(&relaxAlignment, "misaligned", false, "allow misaligned memory access")
@@ -25,35 +28,99 @@ func main() {
 	var memoryDumpStart uint64
 	flag.Uint64Var(&memoryDumpStart, "dumpstart", 0, "base address for memory dump")
 
+	var hoistDelaySlots bool
+	flag.BoolVar(&hoistDelaySlots, "hoist", false,
+		"fill delay slots in .set reorder mode with preceding instructions when safe")
+
+	var relaxBranches bool
+	flag.BoolVar(&relaxBranches, "relax", false,
+		"rewrite out-of-range branches and jumps to jump through $at")
+
+	var inputFormat string
+	flag.StringVar(&inputFormat, "format", "",
+		"input format: asm, raw, elf, ihex, srec, memh, or c (default: guess from the file)")
+
+	var binaryFile string
+	flag.StringVar(&binaryFile, "binary", "", "run a raw binary (the same as -format raw <file>)")
+
+	var baseAddress uint64
+	flag.Uint64Var(&baseAddress, "base", 0, "load address for raw binaries")
+
+	var entryPoint string
+	flag.StringVar(&entryPoint, "entry", "", "address or symbol to start executing at")
+
+	var loads cli.StringList
+	flag.Var(&loads, "load", "preload a data file into memory, as file@address (repeatable)")
+
+	var lineTableFile string
+	flag.StringVar(&lineTableFile, "lines", "",
+		"read source positions written by mips-as -lines, for error messages")
+
+	var includePaths cli.StringList
+	flag.Var(&includePaths, "I", "add a directory to the .include search path")
+
+	var defines cli.StringList
+	flag.Var(&defines, "D", "define NAME=value (or NAME as 1) for conditional assembly")
+
 	flag.Parse()
-	if len(flag.Args()) != 1 {
+	var file string
+	if binaryFile != "" && len(flag.Args()) == 0 {
+		file = binaryFile
+		inputFormat = "raw"
+	} else if binaryFile == "" && len(flag.Args()) == 1 {
+		file = flag.Args()[0]
+	} else {
 		dieUsage()
 	}
-
-	file := flag.Args()[0]
-	contents, err := ioutil.ReadFile(file)
-	if err != nil {
-		fmt.Fprintln(os.Stderr, err)
+	if baseAddress > 0xffffffff {
+		fmt.Fprintln(os.Stderr, "base address out of range:", baseAddress)
 		os.Exit(1)
 	}
 
-	tokens, err := mips32.TokenizeSource(string(contents))
+	image, err := mips32.LoadProgram(file, mips32.ProgramOptions{
+		Format:       inputFormat,
+		LittleEndian: littleEndian,
+		Base:         uint32(baseAddress),
+		IncludePaths: includePaths,
+		Defines:      defines,
+		ParseOptions: mips32.ParseOptions{
+			HoistDelaySlots: hoistDelaySlots,
+			WarningHandler:  printWarning,
+			RelaxBranches:   relaxBranches,
+		},
+	})
 	if err != nil {
 		fmt.Fprintln(os.Stderr, err)
 		os.Exit(1)
 	}
+	exc := image.Executable
+	memory := image.Memory
+	littleEndian = image.LittleEndian
 
-	exc, err := mips32.ParseExecutable(tokens)
-	if err != nil {
-		fmt.Fprintln(os.Stderr, err)
-		os.Exit(1)
+	if lineTableFile != "" {
+		exc.LineTable, err = mips32.ReadLineTableFile(lineTableFile)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+	}
+	if entryPoint != "" {
+		exc.Entry, err = exc.ResolveEntry(entryPoint)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+	}
+	for _, load := range loads {
+		preload(memory, load)
 	}
 
 	emu := &mips32.Emulator{
-		Memory:            mips32.NewLazyMemory(),
+		Memory:            memory,
 		Executable:        exc,
 		LittleEndian:      littleEndian,
 		ForceMemAlignment: !relaxAlignment,
+		ProgramCounter:    exc.Entry,
 	}
 	for !emu.Done() {
 		if err := emu.Step(); err != nil {
@@ -70,8 +137,35 @@ func main() {
 	}
 }
 
+// preload copies a file into memory for a -load flag like "table.bin@0x80010000".
+func preload(memory mips32.Memory, load string) {
+	idx := strings.LastIndex(load, "@")
+	if idx < 0 {
+		fmt.Fprintln(os.Stderr, "invalid -load (expected file@address):", load)
+		os.Exit(1)
+	}
+	addr, err := strconv.ParseUint(load[idx+1:], 0, 32)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "invalid -load address:", load[idx+1:])
+		os.Exit(1)
+	}
+	data, err := ioutil.ReadFile(load[:idx])
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	if addr+uint64(len(data)) > 1<<32 {
+		fmt.Fprintln(os.Stderr, load[:idx]+": does not fit in memory at", load[idx+1:])
+		os.Exit(1)
+	}
+	for i, b := range data {
+		memory.Set(uint32(addr)+uint32(i), b)
+	}
+}
+
 func dieUsage() {
-	fmt.Fprintln(os.Stderr, "Usage:", os.Args[0], "[flags] <file.s>")
+	fmt.Fprintln(os.Stderr, "Usage:", os.Args[0], "[flags] <file.s|image>")
+	fmt.Fprintln(os.Stderr, "      ", os.Args[0], "[flags] -binary <file.bin>")
 	flag.PrintDefaults()
 	os.Exit(1)
 }
@@ -106,3 +200,8 @@ func dumpMemory(mem mips32.Memory, start, size uint32) {
 		fmt.Println("")
 	}
 }
+
+// printWarning prints a warning in the same format as errors, without stopping assembly.
+func printWarning(d mips32.Diagnostic) {
+	fmt.Fprintln(os.Stderr, d.String())
+}
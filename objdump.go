@@ -0,0 +1,157 @@
+package mips32
+
+import (
+	"errors"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// objdumpMnemonicWidth is the width of the mnemonic column in objdump-style output.
+const objdumpMnemonicWidth = 8
+
+// An ObjdumpFormatter prints instructions in the column layout used by objdump: the address,
+// the raw encoding, the mnemonic and the operands.
+//
+// Registers are given their ABI names.
+// Branch and jump targets are printed as absolute addresses, followed by the nearest symbol at
+// or before the target, like "80000120 <loop+0x8>".
+type ObjdumpFormatter struct {
+	symbols       map[string]uint32
+	sortedSymbols symbolAddrPairList
+}
+
+// NewObjdumpFormatter creates an ObjdumpFormatter which names addresses using the given
+// symbol table.
+func NewObjdumpFormatter(symbols map[string]uint32) *ObjdumpFormatter {
+	res := &ObjdumpFormatter{symbols: symbols}
+	for name, addr := range symbols {
+		res.sortedSymbols = append(res.sortedSymbols, symbolAddrPair{name, addr})
+	}
+	sort.Sort(res.sortedSymbols)
+	return res
+}
+
+// FormatInstruction formats one line for an instruction at the given address.
+//
+// If delaySlot is set, the mnemonic is indented by one space to mark the instruction as being
+// in the delay slot of the one before it.
+func (f *ObjdumpFormatter) FormatInstruction(inst *Instruction, addr uint32,
+	delaySlot bool) (string, error) {
+	word, err := inst.Encode(addr, f.symbols)
+	if err != nil {
+		return "", err
+	}
+	operands, err := f.FormatOperands(inst, addr)
+	if err != nil {
+		return "", err
+	}
+	mnemonic := strings.ToLower(inst.Name)
+	if delaySlot {
+		mnemonic = " " + mnemonic
+	}
+	res := hexWord(addr) + ":  " + hexWord(word) + "  " + mnemonic
+	if operands != "" {
+		res = padRight(res, len(res)-len(mnemonic)+objdumpMnemonicWidth) + " " + operands
+	}
+	return res, nil
+}
+
+// FormatOperands formats the operands of an instruction at the given address.
+func (f *ObjdumpFormatter) FormatOperands(inst *Instruction, addr uint32) (string, error) {
+	if inst.Name == ".word" {
+		return "0x" + hexWord(inst.RawWord), nil
+	}
+	template, err := inst.template()
+	if err != nil {
+		return "", err
+	}
+	var operands []string
+	regIndex := 0
+	for _, arg := range template.Arguments {
+		switch arg {
+		case Register:
+			operands = append(operands, abiRegisterNames[inst.Registers[regIndex]&31])
+			regIndex++
+		case SignedConstant16:
+			operands = append(operands, strconv.Itoa(int(inst.SignedConstant16)))
+		case UnsignedConstant16:
+			operand := strconv.FormatUint(uint64(inst.UnsignedConstant16), 16)
+			operands = append(operands, "0x"+operand)
+		case Constant5:
+			operands = append(operands, strconv.Itoa(int(inst.Constant5)))
+		case AbsoluteCodePointer, RelativeCodePointer:
+			target, ok := codeTarget(inst, addr, f.symbols)
+			if !ok {
+				return "", unknownSymbolError(inst.CodePointer.Symbol)
+			}
+			operand := strconv.FormatUint(uint64(target), 16)
+			if name := f.SymbolOffset(target); name != "" {
+				operand += " <" + name + ">"
+			}
+			operands = append(operands, operand)
+		case MemoryAddress:
+			ref := inst.MemoryReference
+			operands = append(operands, strconv.Itoa(int(ref.Offset))+"("+
+				abiRegisterNames[ref.Register&31]+")")
+		}
+	}
+	return strings.Join(operands, ","), nil
+}
+
+// SymbolOffset names an address relative to the nearest symbol at or before it, like "main" or
+// "main+0x1c".
+// It returns an empty string if no symbol comes before the address.
+func (f *ObjdumpFormatter) SymbolOffset(addr uint32) string {
+	idx := sort.Search(len(f.sortedSymbols), func(i int) bool {
+		return f.sortedSymbols[i].Address > addr
+	})
+	if idx == 0 {
+		return ""
+	}
+	sym := f.sortedSymbols[idx-1]
+	// Several symbols may share an address; use the first one alphabetically.
+	for idx > 1 && f.sortedSymbols[idx-2].Address == sym.Address {
+		idx--
+		sym = f.sortedSymbols[idx-1]
+	}
+	if sym.Address == addr {
+		return sym.Symbol
+	}
+	return sym.Symbol + "+0x" + strconv.FormatUint(uint64(addr-sym.Address), 16)
+}
+
+// Objdump disassembles the executable in the column layout of an ObjdumpFormatter.
+//
+// Every symbol which names an instruction starts a new block with a header like
+// "80000000 <main>:".
+func (e *Executable) Objdump() (string, error) {
+	formatter := NewObjdumpFormatter(e.Symbols)
+	names := map[uint32][]string{}
+	for _, pair := range formatter.sortedSymbols {
+		names[pair.Address] = append(names[pair.Address], pair.Symbol)
+	}
+
+	var lines []string
+	for _, start := range e.sortedSegmentAddresses() {
+		for i := range e.Segments[start] {
+			inst := &e.Segments[start][i]
+			addr := start + uint32(i*4)
+			if i == 0 || len(names[addr]) > 0 {
+				if len(lines) > 0 {
+					lines = append(lines, "")
+				}
+				for _, name := range names[addr] {
+					lines = append(lines, hexWord(addr)+" <"+name+">:")
+				}
+			}
+			delaySlot := i > 0 && e.Segments[start][i-1].HasDelaySlot()
+			line, err := formatter.FormatInstruction(inst, addr, delaySlot)
+			if err != nil {
+				return "", errors.New("at " + hexWord(addr) + ": " + err.Error())
+			}
+			lines = append(lines, line)
+		}
+	}
+	return strings.Join(lines, "\n") + "\n", nil
+}
@@ -0,0 +1,78 @@
+package mips32
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestObjdump(t *testing.T) {
+	lines, err := TokenizeSource(`.text 0x10400100
+main: LUI $t0, 0x8000
+	ORI $t0, $t0, 0xbeef
+loop: ADDIU $t0, $t0, -1
+	SW $ra, -8($sp)
+	BNE $t0, $0, loop
+	SLL $t1, $t0, 2
+	JAL sub
+	NOP
+sub: JR $ra
+	NOP
+	.word 0xffffffff`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	exc, err := ParseExecutable(lines)
+	if err != nil {
+		t.Fatal(err)
+	}
+	actual, err := exc.Objdump()
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := `10400100 <main>:
+10400100:  3c088000  lui      t0,0x8000
+10400104:  3508beef  ori      t0,t0,0xbeef
+
+10400108 <loop>:
+10400108:  2508ffff  addiu    t0,t0,-1
+1040010c:  afbffff8  sw       ra,-8(sp)
+10400110:  1500fffd  bne      t0,zero,10400108 <loop>
+10400114:  00084880   sll     t1,t0,2
+10400118:  0c100048  jal      10400120 <sub>
+1040011c:  00000000   nop
+
+10400120 <sub>:
+10400120:  03e00008  jr       ra
+10400124:  00000000   nop
+10400128:  ffffffff  .word    0xffffffff
+`
+	if actual != expected {
+		t.Errorf("expected:\n%s\ngot:\n%s", expected, actual)
+	}
+
+	// Decoded instructions have constant targets, which are named after the nearest symbol.
+	var raw bytes.Buffer
+	if err := WriteRawImage(&raw, exc, 0x10400100, false); err != nil {
+		t.Fatal(err)
+	}
+	decoded := LoadRawImage(raw.Bytes(), 0x10400100, false).Executable
+	formatter := NewObjdumpFormatter(map[string]uint32{"main": 0x10400100, "alias": 0x10400100})
+	for _, test := range []struct {
+		addr     uint32
+		expected string
+	}{
+		{0x10400110, "10400110:  1500fffd  bne      t0,zero,10400108 <alias+0x8>"},
+		{0x10400118, "10400118:  0c100048  jal      10400120 <alias+0x20>"},
+	} {
+		line, err := formatter.FormatInstruction(decoded.Get(test.addr), test.addr, false)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if line != test.expected {
+			t.Errorf("expected %q but got %q", test.expected, line)
+		}
+	}
+	if name := formatter.SymbolOffset(0x10400000); name != "" {
+		t.Errorf("unexpected symbol for address before main: %s", name)
+	}
+}
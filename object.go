@@ -0,0 +1,223 @@
+package mips32
+
+import (
+	"debug/elf"
+	"encoding/json"
+	"errors"
+	"io"
+	"sort"
+)
+
+// objectFormat identifies the files written by WriteObject.
+const objectFormat = "mips32-object"
+
+// An Object is a relocatable object file: the assembled sections of one source file, whose final
+// addresses are chosen later by Link.
+type Object struct {
+	// Name identifies the object in linker errors, and is usually its file name.
+	Name string
+
+	Sections []*ObjectSection
+
+	// Symbols lists every symbol defined by the object.
+	Symbols []ObjectSymbol
+}
+
+// An ObjectSection is a named, contiguous block of words which is placed as a unit by the
+// linker.
+type ObjectSection struct {
+	Name  string
+	Words []uint32
+
+	// Relocations lists the places in Words which depend on the final address of a symbol.
+	Relocations []Relocation
+}
+
+// An ObjectSymbol is a symbol defined in one of an object's sections.
+type ObjectSymbol struct {
+	Name    string
+	Section string
+	Offset  uint32
+
+	// Global is set if the symbol was declared with .globl, so that other objects may refer to
+	// it. Other symbols can only be referenced from the object which defines them.
+	Global bool
+}
+
+// A Relocation describes a word of a section which must be patched with the address of a
+// symbol, S, once the section's address is known.
+//
+// The supported types are:
+//
+//	R_MIPS_32    the word is S+Addend.
+//	R_MIPS_26    the low 26 bits are (S+Addend)>>2, for a J or JAL instruction.
+//	R_MIPS_HI16  the low 16 bits are %hi(S+Addend).
+//	R_MIPS_LO16  the low 16 bits are %lo(S+Addend).
+//	R_MIPS_PC16  the low 16 bits are (S+Addend-P-4)>>2, where P is the address of the branch.
+type Relocation struct {
+	// Offset is the offset of the word in its section, in bytes.
+	Offset uint32
+
+	Type   elf.R_MIPS
+	Symbol string
+	Addend int32
+}
+
+// Section finds a section by name, or returns nil if the object has no such section.
+func (o *Object) Section(name string) *ObjectSection {
+	for _, section := range o.Sections {
+		if section.Name == name {
+			return section
+		}
+	}
+	return nil
+}
+
+// ParseObject turns a tokenized source file into a relocatable object.
+//
+// Code starts in the ".text" section, and the ".text", ".data", and ".section NAME" directives
+// switch between sections. Symbols are local to the object unless they are declared with
+// ".globl", and symbols which the object does not define are left for the linker to resolve.
+//
+// The RelaxBranches option has no effect, since addresses are not known until the object is
+// linked. Errors are reported as a DiagnosticList, like ParseExecutableOptions.
+func ParseObject(lines []TokenizedLine, options ParseOptions) (*Object, error) {
+	p := newExecutableParser(lines, options, newRelaxation())
+	p.object = true
+	for i := range lines {
+		p.parseLine(&lines[i])
+	}
+	p.flushRelaxTail()
+	p.switchSection(p.section)
+	res := p.buildObject()
+	if len(lines) > 0 {
+		res.Name = lines[0].File
+	}
+	if p.diagnostics.HasErrors() {
+		return nil, p.diagnostics
+	}
+	if options.WarningHandler != nil {
+		for _, d := range p.diagnostics {
+			options.WarningHandler(d)
+		}
+	}
+	return res, nil
+}
+
+// buildObject encodes every section, recording a relocation for each reference to a symbol
+// whose address depends on where sections are placed.
+func (p *executableParser) buildObject() *Object {
+	res := &Object{}
+	for _, name := range p.sectionOrder {
+		state := p.sections[name]
+		insts := state.res.Segments[0]
+		section := &ObjectSection{Name: name, Words: make([]uint32, len(insts))}
+		for i := range insts {
+			offset := uint32(i * 4)
+			word, reloc, err := encodeObjectInstruction(&insts[i], offset, state.res.Symbols)
+			if err != nil {
+				line := state.instructionLines[offset]
+				column := 0
+				if line.Instruction != nil {
+					column = lastArgumentColumn(line.Instruction)
+				}
+				p.diagnostics.addError(line, column, err.Error(), "")
+			}
+			section.Words[i] = word
+			if reloc != nil {
+				reloc.Offset = offset
+				section.Relocations = append(section.Relocations, *reloc)
+			}
+		}
+		for _, word := range state.wordSymbols {
+			section.Words[word.addr/4] = 0
+			section.Relocations = append(section.Relocations, Relocation{
+				Offset: word.addr,
+				Type:   elf.R_MIPS_32,
+				Symbol: word.symbol,
+			})
+		}
+		sort.SliceStable(section.Relocations, func(i, j int) bool {
+			return section.Relocations[i].Offset < section.Relocations[j].Offset
+		})
+		if len(section.Words) > 0 || name == ".text" {
+			res.Sections = append(res.Sections, section)
+		}
+		for _, pair := range state.res.sortedSymbolAddrPairs() {
+			_, global := p.globals[pair.Symbol]
+			res.Symbols = append(res.Symbols, ObjectSymbol{
+				Name:    pair.Symbol,
+				Section: name,
+				Offset:  pair.Address,
+				Global:  global,
+			})
+		}
+	}
+	return res
+}
+
+// encodeObjectInstruction encodes an instruction at an offset in a section.
+// If the instruction refers to a symbol which is not in the same section, it is encoded as if the
+// symbol were at address 0, and a relocation (without an offset) is returned.
+func encodeObjectInstruction(inst *Instruction, offset uint32,
+	symbols map[string]uint32) (uint32, *Relocation, error) {
+	if inst.AddressHalf != nil {
+		word, err := inst.Encode(offset, symbols)
+		reloc := &Relocation{Type: elf.R_MIPS_LO16, Symbol: inst.AddressHalf.Symbol}
+		if inst.AddressHalf.High {
+			reloc.Type = elf.R_MIPS_HI16
+		}
+		return word, reloc, err
+	}
+
+	ptr := inst.CodePointer
+	if inst.IsBranch() && ptr.IsSymbol {
+		if _, ok := symbols[ptr.Symbol]; ok {
+			// Both the branch and its target move with the section.
+			word, err := inst.Encode(offset, symbols)
+			return word, nil, err
+		}
+		unresolved := *inst
+		unresolved.CodePointer = CodePointer{}
+		word, err := unresolved.Encode(offset, nil)
+		return word, &Relocation{Type: elf.R_MIPS_PC16, Symbol: ptr.Symbol}, err
+	} else if inst.Name == "J" || inst.Name == "JAL" {
+		if ptr.IsSymbol {
+			unresolved := *inst
+			unresolved.CodePointer = CodePointer{Absolute: true}
+			word, err := unresolved.Encode(0, nil)
+			return word, &Relocation{Type: elf.R_MIPS_26, Symbol: ptr.Symbol}, err
+		}
+		// The final address is unknown, so assume the jump is in the region of its target.
+		word, err := inst.Encode(ptr.Constant&jumpRegionMask, nil)
+		return word, nil, err
+	}
+
+	word, err := inst.Encode(offset, symbols)
+	return word, nil, err
+}
+
+type objectFile struct {
+	Format string
+	Object *Object
+}
+
+// WriteObject writes an object in a format which can be read with ReadObject.
+func WriteObject(w io.Writer, o *Object) error {
+	data, err := json.MarshalIndent(&objectFile{Format: objectFormat, Object: o}, "", "  ")
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(append(data, '\n'))
+	return err
+}
+
+// ReadObject reads an object which was written by WriteObject.
+func ReadObject(r io.Reader) (*Object, error) {
+	var file objectFile
+	if err := json.NewDecoder(r).Decode(&file); err != nil || file.Format != objectFormat ||
+		file.Object == nil {
+		return nil, errors.New("not a mips32 object file")
+	}
+	return file.Object, nil
+}
@@ -0,0 +1,480 @@
+package mips32
+
+// OptimizeOptions controls what OptimizeWithOptions may assume about a program.
+type OptimizeOptions struct {
+	// AssumeCallingConvention lets the optimizer assume that every function follows the usual
+	// calling convention, as DataFlow does: a function may change $at, $v0-$v1, $a0-$a3,
+	// $t0-$t9 and $ra, reads nothing but its arguments, $gp, $sp and $ra, and leaves only its
+	// results and the registers it preserves to its caller. This lets more writes be removed,
+	// but it breaks programs which pass values between functions in other registers.
+	//
+	// Otherwise, a call may read and keep any register, and every register is live when a
+	// function returns.
+	AssumeCallingConvention bool
+}
+
+// Optimize is like OptimizeWithOptions, with the default options.
+func Optimize(e *Executable) {
+	OptimizeWithOptions(e, OptimizeOptions{})
+}
+
+// OptimizeWithOptions rewrites the code of an executable so that it does the same work with
+// fewer instructions. These passes are run until none of them finds anything more to do:
+//
+//   - NOPs outside of delay slots are removed, along with instructions which do nothing, like
+//     "ADDU $t0, $t0, $0", writes to $zero, and moves which undo the move before them.
+//   - Chains of LUI, ORI and ADDIU which build a constant in a register are replaced by the
+//     shortest sequence which loads the same constant.
+//   - Instructions are removed if they write a register which is never read afterwards, as
+//     found by the analysis of DataFlow (see OptimizeOptions).
+//   - Branches and jumps to an unconditional jump with a NOP in its delay slot go straight to
+//     the jump's target.
+//   - A NOP in a delay slot is replaced by the instruction before the branch or jump, if the
+//     two do not depend on each other and nothing else jumps to the branch.
+//
+// Instructions in delay slots are replaced by NOPs rather than removed.
+// Only the code which DataFlow reaches is changed.
+//
+// Removing instructions moves the code after them to lower addresses. Symbols, the entry point,
+// branch and jump targets, "%hi" and "%lo" operands, and the line table are updated to match.
+// Symbols which pointed to a removed instruction point to the instruction after it.
+// Code addresses stored in other ways, such as jump tables made of ".word" directives or
+// addresses built from constants, are not updated, so programs which use them should not be
+// optimized. VerifyOptimization can check an optimized program against the original.
+func OptimizeWithOptions(e *Executable, options OptimizeOptions) {
+	passes := []func(o *optimizer){
+		(*optimizer).removeNoOps,
+		(*optimizer).foldConstants,
+		(*optimizer).removeDeadWrites,
+		(*optimizer).shortenBranchChains,
+		(*optimizer).fillDelaySlots,
+	}
+	for changed := true; changed; {
+		changed = false
+		for _, pass := range passes {
+			o := newOptimizer(e, options)
+			pass(o)
+			if o.edit.empty() {
+				continue
+			}
+			e.applyEdit(o.edit)
+			changed = true
+		}
+	}
+}
+
+// pureInstructions only change their destination register, and cannot fail.
+var pureInstructions = map[string]bool{
+	"ADDIU": true, "ANDI": true, "ORI": true, "XORI": true, "SLTI": true, "SLTIU": true,
+	"SLL": true, "SRA": true, "SRL": true, "ADDU": true, "AND": true, "NOR": true, "OR": true,
+	"SUBU": true, "XOR": true, "SLT": true, "SLTU": true, "SLLV": true, "SRAV": true,
+	"SRLV": true, "MOVN": true, "MOVZ": true, "LUI": true,
+}
+
+// A codeEdit lists changes to the instructions of an executable, by their current addresses.
+type codeEdit struct {
+	replace map[uint32]Instruction
+	remove  map[uint32]bool
+
+	// retarget gives branches and jumps new targets.
+	retarget map[uint32]CodePointer
+
+	// moved maps the address of an instruction which was moved to the address it came from, so
+	// that it keeps its source position.
+	moved map[uint32]uint32
+}
+
+func (c *codeEdit) empty() bool {
+	return len(c.replace) == 0 && len(c.remove) == 0 && len(c.retarget) == 0
+}
+
+type optimizer struct {
+	exc  *Executable
+	flow *DataFlow
+	edit *codeEdit
+
+	// labels contains the addresses which control may reach other than from the instruction
+	// before them: symbols, functions, and the targets of branches and jumps.
+	labels map[uint32]bool
+}
+
+func newOptimizer(e *Executable, options OptimizeOptions) *optimizer {
+	o := &optimizer{
+		exc:  e,
+		flow: e.dataFlow(options.AssumeCallingConvention),
+		edit: &codeEdit{
+			replace:  map[uint32]Instruction{},
+			remove:   map[uint32]bool{},
+			retarget: map[uint32]CodePointer{},
+			moved:    map[uint32]uint32{},
+		},
+		labels: map[uint32]bool{e.Entry: true},
+	}
+	for _, addr := range e.Symbols {
+		o.labels[addr] = true
+	}
+	for _, addr := range o.flow.graph.functions {
+		o.labels[addr] = true
+	}
+	for addr, succs := range o.flow.graph.successors {
+		for _, next := range succs {
+			if next != addr+4 {
+				o.labels[next] = true
+			}
+		}
+	}
+	for start, insts := range e.Segments {
+		for i := range insts {
+			if target, ok := o.target(start + uint32(i*4)); ok {
+				o.labels[target] = true
+			}
+		}
+	}
+	return o
+}
+
+// removeNoOps removes instructions which have no effect.
+func (o *optimizer) removeNoOps() {
+	for _, addr := range o.flow.graph.addresses() {
+		inst := o.exc.Get(addr)
+		if isNoOp(inst) {
+			o.removeInstruction(addr)
+			continue
+		}
+
+		// A move which undoes the move before it, as in "MOVE $t0, $t1; MOVE $t1, $t0".
+		next := o.exc.Get(addr + 4)
+		if !o.flow.Analyzed(addr+4) || o.labels[addr+4] || o.inDelaySlot(addr) {
+			continue
+		}
+		dest1, source1, ok1 := moveRegisters(inst)
+		dest2, source2, ok2 := moveRegisters(next)
+		if ok1 && ok2 && dest1 == source2 && source1 == dest2 {
+			o.removeInstruction(addr + 4)
+		}
+	}
+}
+
+// foldConstants replaces chains of instructions which build a constant with the shortest
+// sequence which loads the constant.
+func (o *optimizer) foldConstants() {
+	addrs := o.flow.graph.addresses()
+	for i := 0; i < len(addrs); i++ {
+		addr := addrs[i]
+		inst := o.exc.Get(addr)
+		value, ok := constantStep(inst, 0, false)
+		if !ok || o.inDelaySlot(addr) {
+			continue
+		}
+		reg := inst.Registers[0]
+		length := 1
+		for {
+			next := addr + uint32(length*4)
+			nextInst := o.exc.Get(next)
+			if !o.flow.Analyzed(next) || o.labels[next] || o.inDelaySlot(next) ||
+				nextInst.HasDelaySlot() || len(nextInst.Registers) == 0 ||
+				nextInst.Registers[0] != reg {
+				break
+			}
+			nextValue, ok := constantStep(nextInst, value, true)
+			if !ok {
+				break
+			}
+			value = nextValue
+			length++
+		}
+
+		expanded := loadImmediate(reg, value)
+		if len(expanded) < length {
+			for j := 0; j < length; j++ {
+				chainAddr := addr + uint32(j*4)
+				if j < len(expanded) {
+					o.edit.replace[chainAddr] = expanded[j]
+				} else {
+					o.edit.remove[chainAddr] = true
+				}
+			}
+		}
+		i += length - 1
+	}
+}
+
+// removeDeadWrites removes instructions whose results are never used.
+func (o *optimizer) removeDeadWrites() {
+	dead := map[uint32]RegisterSet{}
+	for _, def := range o.flow.DeadDefinitions() {
+		dead[def.Address] |= 1 << uint(def.Register)
+	}
+	for addr, regs := range dead {
+		inst := o.exc.Get(addr)
+		if pureInstructions[inst.Name] && o.flow.writes(addr)&^regs == 0 {
+			o.removeInstruction(addr)
+		}
+	}
+}
+
+// shortenBranchChains makes branches and jumps skip over unconditional jumps which they lead
+// to.
+func (o *optimizer) shortenBranchChains() {
+	for _, addr := range o.flow.graph.addresses() {
+		inst := o.exc.Get(addr)
+		target, ok := o.target(addr)
+		if !ok || o.inDelaySlot(addr) {
+			continue
+		}
+
+		visited := map[uint32]bool{target: true}
+		final := target
+		var finalPointer CodePointer
+		for o.isTrampoline(final) {
+			next, _ := o.target(final)
+			if visited[next] {
+				// The jumps form a loop, which is left alone.
+				final = target
+				break
+			}
+			visited[next] = true
+			finalPointer = o.exc.Get(final).CodePointer
+			final = next
+		}
+		if final == target || !canReach(inst, addr, final) {
+			continue
+		}
+
+		pointer := CodePointer{Absolute: inst.CodePointer.Absolute}
+		if finalPointer.IsSymbol {
+			pointer.IsSymbol = true
+			pointer.Symbol = finalPointer.Symbol
+		} else {
+			pointer.Constant = final
+		}
+		o.edit.retarget[addr] = pointer
+	}
+}
+
+// fillDelaySlots moves instructions into the delay slots of the branches and jumps after them,
+// in place of NOPs.
+func (o *optimizer) fillDelaySlots() {
+	for _, addr := range o.flow.graph.addresses() {
+		branch := o.exc.Get(addr)
+		prevAddr, slot := addr-4, addr+4
+		if !branch.HasDelaySlot() || o.inDelaySlot(addr) || o.labels[addr] || o.labels[slot] ||
+			!o.flow.Analyzed(prevAddr) || o.inDelaySlot(prevAddr) ||
+			o.exc.Get(slot) == nil || o.exc.Get(slot).Name != "NOP" {
+			continue
+		}
+		prev := o.exc.Get(prevAddr)
+		if prev.Name == ".word" || prev.HasDelaySlot() || o.edit.remove[prevAddr] ||
+			!independentOfBranch(prev, branch) {
+			continue
+		}
+		o.edit.replace[slot] = *prev
+		o.edit.moved[slot] = prevAddr
+		o.edit.remove[prevAddr] = true
+	}
+}
+
+// removeInstruction removes an instruction which does nothing, or replaces it with a NOP if it
+// is in a delay slot.
+func (o *optimizer) removeInstruction(addr uint32) {
+	if !o.inDelaySlot(addr) {
+		o.edit.remove[addr] = true
+	} else if o.exc.Get(addr).Name != "NOP" {
+		o.edit.replace[addr] = Instruction{Name: "NOP"}
+	}
+}
+
+func (o *optimizer) inDelaySlot(addr uint32) bool {
+	_, ok := o.flow.graph.delaySlots[addr]
+	return ok
+}
+
+// target finds the target of the branch or jump at an address.
+func (o *optimizer) target(addr uint32) (uint32, bool) {
+	inst := o.exc.Get(addr)
+	if inst == nil || !(inst.IsBranch() || inst.Name == "J" || inst.Name == "JAL") {
+		return 0, false
+	}
+	return codeTarget(inst, addr, o.exc.Symbols)
+}
+
+// isTrampoline checks if the instruction at an address is an unconditional jump with a NOP in
+// its delay slot.
+func (o *optimizer) isTrampoline(addr uint32) bool {
+	inst, slot := o.exc.Get(addr), o.exc.Get(addr+4)
+	if inst == nil || slot == nil || slot.Name != "NOP" {
+		return false
+	}
+	return inst.Name == "J" || (inst.IsBranch() && alwaysBranches(inst))
+}
+
+// canReach checks if a branch or jump can be given a new target.
+func canReach(inst *Instruction, addr, target uint32) bool {
+	if inst.IsBranch() {
+		distance := int64(int32(target - (addr + 4)))
+		return distance >= -maxBranchDistance && distance < maxBranchDistance
+	}
+	return target&jumpRegionMask == (addr+4)&jumpRegionMask
+}
+
+// isNoOp checks if an instruction has no effect.
+func isNoOp(inst *Instruction) bool {
+	if inst.Name == "NOP" {
+		return true
+	} else if !pureInstructions[inst.Name] {
+		return false
+	} else if inst.Registers[0] == 0 {
+		return true
+	}
+	regs := inst.Registers
+	switch inst.Name {
+	case "ADDU", "OR", "XOR":
+		return (regs[0] == regs[1] && regs[2] == 0) || (regs[0] == regs[2] && regs[1] == 0)
+	case "SUBU":
+		return regs[0] == regs[1] && regs[2] == 0
+	case "AND":
+		return regs[0] == regs[1] && regs[0] == regs[2]
+	case "ADDIU", "ORI", "XORI", "SLL", "SRA", "SRL":
+		return regs[0] == regs[1] && inst.UnsignedConstant16 == 0 &&
+			inst.SignedConstant16 == 0 && inst.Constant5 == 0 && inst.AddressHalf == nil
+	case "MOVN", "MOVZ":
+		return regs[0] == regs[1]
+	}
+	return false
+}
+
+// moveRegisters checks if an instruction copies one register to another, and returns the
+// registers if it does.
+func moveRegisters(inst *Instruction) (dest, source int, ok bool) {
+	if inst == nil || !pureInstructions[inst.Name] || inst.AddressHalf != nil {
+		return
+	}
+	regs := inst.Registers
+	switch inst.Name {
+	case "ADDU", "OR", "XOR":
+		if regs[2] == 0 {
+			return regs[0], regs[1], true
+		} else if regs[1] == 0 {
+			return regs[0], regs[2], true
+		}
+	case "SUBU":
+		if regs[2] == 0 {
+			return regs[0], regs[1], true
+		}
+	case "ADDIU", "ORI", "XORI":
+		if inst.SignedConstant16 == 0 && inst.UnsignedConstant16 == 0 {
+			return regs[0], regs[1], true
+		}
+	}
+	return
+}
+
+// constantStep finds the value of a register after an instruction which loads a constant into
+// it, or which modifies the known value of the register by a constant.
+func constantStep(inst *Instruction, value uint32, known bool) (uint32, bool) {
+	if inst.AddressHalf != nil || len(inst.Registers) == 0 || inst.Registers[0] == 0 {
+		return 0, false
+	}
+	reg := inst.Registers[0]
+	switch inst.Name {
+	case "LUI":
+		return uint32(inst.UnsignedConstant16) << 16, true
+	case "ORI":
+		if inst.Registers[1] == 0 {
+			return uint32(inst.UnsignedConstant16), true
+		} else if known && inst.Registers[1] == reg {
+			return value | uint32(inst.UnsignedConstant16), true
+		}
+	case "ADDIU":
+		if inst.Registers[1] == 0 {
+			return uint32(int32(inst.SignedConstant16)), true
+		} else if known && inst.Registers[1] == reg {
+			return value + uint32(int32(inst.SignedConstant16)), true
+		}
+	}
+	return 0, false
+}
+
+// applyEdit makes the changes in a codeEdit, and moves the remaining instructions of each
+// segment together.
+func (e *Executable) applyEdit(edit *codeEdit) {
+	newAddrs := map[uint32]uint32{}
+	newEnds := map[uint32]uint32{}
+	type pointerFix struct {
+		segment uint32
+		index   int
+		target  uint32
+	}
+	var fixes []pointerFix
+	newLines := LineTable{}
+
+	for start, insts := range e.Segments {
+		var res []Instruction
+		for i, inst := range insts {
+			addr := start + uint32(i*4)
+			newAddr := start + uint32(len(res)*4)
+			newAddrs[addr] = newAddr
+			if edit.remove[addr] {
+				continue
+			}
+			if replacement, ok := edit.replace[addr]; ok {
+				inst = replacement
+			}
+			if pointer, ok := edit.retarget[addr]; ok {
+				inst.CodePointer = pointer
+			}
+			if (inst.IsBranch() || inst.Name == "J" || inst.Name == "JAL") &&
+				!inst.CodePointer.IsSymbol {
+				target, _ := codeTarget(&inst, addr, e.Symbols)
+				if pointer, ok := edit.retarget[addr]; ok {
+					target = pointer.Constant
+				}
+				fixes = append(fixes, pointerFix{start, len(res), target})
+			}
+			source := addr
+			if from, ok := edit.moved[addr]; ok {
+				source = from
+			}
+			if pos, ok := e.LineTable[source]; ok {
+				newLines[newAddr] = pos
+			}
+			res = append(res, inst)
+		}
+		newEnds[start+uint32(len(insts)*4)] = start + uint32(len(res)*4)
+		e.Segments[start] = res
+	}
+
+	mapAddr := func(addr uint32) uint32 {
+		if newAddr, ok := newAddrs[addr]; ok {
+			return newAddr
+		} else if newEnd, ok := newEnds[addr]; ok {
+			return newEnd
+		}
+		return addr
+	}
+	for _, fix := range fixes {
+		inst := &e.Segments[fix.segment][fix.index]
+		target := mapAddr(fix.target)
+		if inst.IsBranch() {
+			inst.CodePointer.Constant = target - (fix.segment + uint32(fix.index*4) + 4)
+		} else {
+			inst.CodePointer.Constant = target
+		}
+	}
+	for name, addr := range e.Symbols {
+		e.Symbols[name] = mapAddr(addr)
+	}
+	e.Entry = mapAddr(e.Entry)
+	for _, insts := range e.Segments {
+		for i := range insts {
+			if half := insts[i].AddressHalf; half != nil {
+				if addr, ok := e.Symbols[half.Symbol]; ok {
+					insts[i].setAddressHalfValue(half.Value(addr))
+				}
+			}
+		}
+	}
+	if e.LineTable != nil {
+		e.LineTable = newLines
+	}
+}
@@ -0,0 +1,189 @@
+package mips32
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestOptimize(t *testing.T) {
+	exc := parseTestExecutable(t, `.text 0x400100
+main:
+	NOP
+	ADDU $t0, $t0, $0
+	LUI $t1, 0
+	ORI $t1, $t1, 5
+	ADDIU $t2, $0, 7
+	ADDIU $t2, $0, 8
+	BEQ $0, $0, hop
+	NOP
+back:
+	ADDU $v0, $t1, $t2
+	JR $ra
+	NOP
+hop:
+	J back
+	NOP`)
+	original := exc.Copy()
+	Optimize(exc)
+
+	expected := parseTestExecutable(t, `.text 0x400100
+main:
+	ADDIU $t1, $0, 5
+	BEQ $0, $0, back
+	ADDIU $t2, $0, 8
+back:
+	JR $ra
+	ADDU $v0, $t1, $t2
+hop:
+	J back
+	NOP`)
+	if !reflect.DeepEqual(exc.Segments, expected.Segments) {
+		t.Errorf("unexpected code: %v", exc.Segments)
+	}
+	if !reflect.DeepEqual(exc.Symbols, expected.Symbols) {
+		t.Errorf("unexpected symbols: %v", exc.Symbols)
+	}
+	if exc.LineTable[0x400110] != original.LineTable[0x400120] {
+		t.Errorf("unexpected line table: %v", exc.LineTable)
+	}
+	if len(original.Segments[0x400100]) != 13 {
+		t.Error("original was changed")
+	}
+	if err := VerifyOptimization(original, exc, OptimizeOptions{}, 20, 1); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestOptimizeMoves(t *testing.T) {
+	exc := parseTestExecutable(t, `.text 0x400100
+	ADDU $t0, $a0, $0
+	OR $a0, $0, $t0
+	NOP
+	BNE $t0, $0, 8
+	NOP
+	ADDIU $v0, $0, 1
+	ADDIU $v1, $0, 2
+	JR $ra
+	NOP`)
+	original := exc.Copy()
+	Optimize(exc)
+
+	insts := exc.Segments[0x400100]
+	if len(insts) != 6 {
+		t.Fatalf("unexpected code: %v", insts)
+	}
+	if insts[1].Name != "BNE" || insts[1].CodePointer.Constant != 8 {
+		t.Errorf("unexpected branch: %v", insts[1])
+	}
+	if err := VerifyOptimization(original, exc, OptimizeOptions{}, 20, 1); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestOptimizeCallingConvention(t *testing.T) {
+	// The helper returns its result in $t0 instead of $v0.
+	exc := parseTestExecutable(t, `.text 0x400100
+	JAL helper
+	NOP
+	SW $t0, 0($sp)
+	J done
+	NOP
+helper:
+	ADDIU $t0, $0, 42
+	JR $ra
+	NOP
+done:`)
+	original := exc.Copy()
+	Optimize(exc)
+	if !containsInstruction(exc, "ADDIU") {
+		t.Errorf("helper's result was removed: %v", exc.Segments)
+	}
+	if err := VerifyOptimization(original, exc, OptimizeOptions{}, 20, 1); err != nil {
+		t.Error(err)
+	}
+
+	options := OptimizeOptions{AssumeCallingConvention: true}
+	OptimizeWithOptions(exc, options)
+	if containsInstruction(exc, "ADDIU") {
+		t.Errorf("helper's result should be dead under the calling convention: %v",
+			exc.Segments)
+	}
+	if err := VerifyOptimization(original, exc, options, 20, 1); err == nil {
+		t.Error("expected the changed result to be caught")
+	}
+}
+
+func TestOptimizeConstantJump(t *testing.T) {
+	// The code at target is only reached through the JR, but it is optimized all the same.
+	exc := parseTestExecutable(t, `.text 0x400100
+main:
+	LUI $t0, %hi(target)
+	ORI $t0, $t0, %lo(target)
+	JR $t0
+	NOP
+	NOP
+target:
+	ADDU $t1, $t1, $0
+	ADDIU $v0, $0, 1
+	JR $ra
+	NOP`)
+	original := exc.Copy()
+	Optimize(exc)
+
+	target := exc.Symbols["target"]
+	if target != 0x400114 || exc.Get(target).Name != "JR" || exc.Get(target+4).Name != "ADDIU" {
+		t.Errorf("unexpected code: %v", exc.Segments)
+	}
+	if err := VerifyOptimization(original, exc, OptimizeOptions{}, 20, 1); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestVerifyOptimization(t *testing.T) {
+	original := parseTestExecutable(t, `.text 0x400100
+	ADDIU $sp, $sp, -8
+	SW $a0, 0($sp)
+	ADDU $v0, $a0, $a1
+	JR $ra
+	ADDIU $sp, $sp, 8`)
+
+	changed := original.Copy()
+	changed.Segments[0x400100][2].Registers[2] = 6
+	if err := VerifyOptimization(original, changed, OptimizeOptions{}, 10, 1); err == nil {
+		t.Error("expected error for changed result")
+	}
+
+	changed = original.Copy()
+	changed.Segments[0x400100][1].Registers[0] = 5
+	if err := VerifyOptimization(original, changed, OptimizeOptions{}, 10, 1); err == nil {
+		t.Error("expected error for changed memory")
+	}
+
+	if err := VerifyOptimization(original, original.Copy(), OptimizeOptions{}, 10, 1); err != nil {
+		t.Error(err)
+	}
+}
+
+func containsInstruction(e *Executable, name string) bool {
+	for _, insts := range e.Segments {
+		for _, inst := range insts {
+			if inst.Name == name {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func parseTestExecutable(t *testing.T, source string) *Executable {
+	lines, err := TokenizeSource(source)
+	if err != nil {
+		t.Fatal(err)
+	}
+	exc, err := ParseExecutable(lines)
+	if err != nil {
+		t.Fatal(err)
+	}
+	exc.Entry = 0x400100
+	return exc
+}
@@ -0,0 +1,476 @@
+package mips32
+
+import (
+	"errors"
+	"strconv"
+	"strings"
+)
+
+// ParseOptions configures optional behavior of ParseExecutableOptions.
+type ParseOptions struct {
+	// HoistDelaySlots allows the assembler to fill a delay slot in ".set reorder" mode by moving
+	// the preceding instruction after the branch or jump, when doing so cannot change what the
+	// program does. Otherwise, delay slots are always filled with NOPs.
+	HoistDelaySlots bool
+
+	// WarningHandler, if non-nil, is called with each warning found while parsing a program
+	// which has no errors. If there are errors, the warnings are included in the returned
+	// DiagnosticList instead.
+	WarningHandler func(d Diagnostic)
+
+	// RelaxBranches rewrites branches and jumps which cannot reach their targets into longer
+	// sequences which jump through the $at register, instead of reporting errors.
+	RelaxBranches bool
+}
+
+// executableParser stores the state of ParseExecutableOptions as it walks through the lines.
+type executableParser struct {
+	options     ParseOptions
+	res         *Executable
+	locals      *localLabels
+	diagnostics DiagnosticList
+
+	// symbolLines maps each symbol to the line which declared it.
+	symbolLines map[string]*TokenizedLine
+
+	// instructionLines maps the address of each instruction to the line which produced it.
+	instructionLines map[uint32]*TokenizedLine
+
+	// instructionExpansions describes each instruction which was generated by the assembler
+	// rather than written in the source, and expansion is the description given to the
+	// instructions which are currently being emitted, if any.
+	instructionExpansions map[uint32]string
+	expansion             string
+
+	relax *relaxation
+
+	// relaxTail is the part of a relaxed branch which follows the branch's delay slot.
+	relaxTail          []Instruction
+	relaxTailLine      *TokenizedLine
+	relaxTailExpansion string
+
+	segmentStart    uint32
+	instructionAddr uint32
+
+	// wordSymbols lists the ".word" directives whose values are symbol addresses.
+	wordSymbols []wordSymbol
+
+	// object is set when parsing a relocatable object, which may have several sections.
+	object bool
+
+	// section is the name of the current section, and sections stores the state of every other
+	// section which has been used.
+	section      string
+	sections     map[string]*sectionState
+	sectionOrder []string
+
+	// globals maps each symbol declared with .globl to its declaration.
+	globals map[string]*TokenizedLine
+
+	// reorder is set while in ".set reorder" mode.
+	reorder bool
+
+	// hoistable is set if the last instruction may be moved into the delay slot of the next
+	// instruction without any changes to the program's behavior.
+	hoistable bool
+
+	// delaySlotNext is set if the last instruction was a branch or jump whose delay slot is
+	// the next instruction in the source.
+	delaySlotNext bool
+}
+
+func newExecutableParser(lines []TokenizedLine, options ParseOptions,
+	relax *relaxation) *executableParser {
+	return &executableParser{
+		options: options,
+		res: &Executable{
+			Segments: map[uint32][]Instruction{},
+			Symbols:  map[string]uint32{},
+		},
+		locals:                newLocalLabels(lines),
+		symbolLines:           map[string]*TokenizedLine{},
+		instructionLines:      map[uint32]*TokenizedLine{},
+		instructionExpansions: map[uint32]string{},
+		relax:                 relax,
+		section:               ".text",
+		sections:              map[string]*sectionState{},
+		sectionOrder:          []string{".text"},
+		globals:               map[string]*TokenizedLine{},
+	}
+}
+
+// A wordSymbol is a ".word" directive whose value is the address of a symbol.
+type wordSymbol struct {
+	addr   uint32
+	symbol string
+	line   *TokenizedLine
+}
+
+// A sectionState stores the layout of a section which is not currently being parsed.
+type sectionState struct {
+	res                   *Executable
+	instructionLines      map[uint32]*TokenizedLine
+	instructionExpansions map[uint32]string
+	wordSymbols           []wordSymbol
+	instructionAddr       uint32
+}
+
+// switchSection saves the layout of the current section and continues in another one.
+// Every section of an object starts at address 0, since its final address is chosen when it is
+// linked.
+func (p *executableParser) switchSection(name string) {
+	p.sections[p.section] = &sectionState{
+		res:                   p.res,
+		instructionLines:      p.instructionLines,
+		instructionExpansions: p.instructionExpansions,
+		wordSymbols:           p.wordSymbols,
+		instructionAddr:       p.instructionAddr,
+	}
+	state, ok := p.sections[name]
+	if !ok {
+		state = &sectionState{
+			res: &Executable{
+				Segments: map[uint32][]Instruction{},
+				Symbols:  map[string]uint32{},
+			},
+			instructionLines:      map[uint32]*TokenizedLine{},
+			instructionExpansions: map[uint32]string{},
+		}
+		p.sectionOrder = append(p.sectionOrder, name)
+	}
+	p.section = name
+	p.res = state.res
+	p.instructionLines = state.instructionLines
+	p.instructionExpansions = state.instructionExpansions
+	p.wordSymbols = state.wordSymbols
+	p.instructionAddr = state.instructionAddr
+	p.hoistable = false
+	p.delaySlotNext = false
+}
+
+// parseLine adds a line to the executable.
+// Problems with the line are added to p.diagnostics, and the rest of the line is skipped.
+func (p *executableParser) parseLine(line *TokenizedLine) {
+	if line.SymbolMarker != nil {
+		p.parseSymbol(line)
+	}
+	if line.Instruction != nil {
+		parsed, err := parseSourceInstruction(line.Instruction)
+		if err != nil {
+			column, hint := instructionProblem(line.Instruction)
+			p.diagnostics.addError(line, column, err.Error(), hint)
+			// Keep the addresses of the following lines correct.
+			p.emit(line, Instruction{Name: "NOP"})
+			p.delaySlotNext = false
+			return
+		}
+		parsed.CodePointer, err = p.locals.resolve(parsed.CodePointer)
+		if err != nil {
+			p.diagnostics.addError(line, lastArgumentColumn(line.Instruction), err.Error(), "")
+			parsed = &Instruction{Name: "NOP"}
+		}
+		tail := p.relaxTail
+		p.relaxTail = nil
+		if p.relax.lines[line] {
+			p.parseRelaxed(line, parsed)
+		} else {
+			p.parseInstruction(line, parsed)
+		}
+		if tail != nil {
+			newTail := p.relaxTail
+			p.relaxTail = tail
+			p.flushRelaxTail()
+			p.relaxTail = newTail
+		}
+	} else if line.Directive != nil {
+		p.flushRelaxTail()
+		p.parseDirective(line)
+	}
+}
+
+func (p *executableParser) parseSymbol(line *TokenizedLine) {
+	sym := *line.SymbolMarker
+	if p.locals.isLocal(sym) {
+		sym = p.locals.declare(sym)
+	} else if prev, ok := p.symbolLines[sym]; ok {
+		p.diagnostics.addError(line, line.SymbolMarkerSpan.Column,
+			"repeated symbol declaration: "+sym, "previously declared at "+prev.Location())
+		return
+	}
+	p.res.Symbols[sym] = p.instructionAddr
+	p.symbolLines[sym] = line
+	p.hoistable = false
+}
+
+func (p *executableParser) parseInstruction(line *TokenizedLine, inst *Instruction) {
+	inDelaySlot := p.delaySlotNext
+	p.delaySlotNext = false
+	if inDelaySlot && inst.HasDelaySlot() {
+		p.diagnostics.addWarning(line, line.Instruction.NameSpan.Column,
+			inst.Name+" in the delay slot of another branch or jump",
+			"insert a NOP after the previous branch or jump")
+	}
+	if !p.reorder || !inst.HasDelaySlot() {
+		p.emit(line, *inst)
+		p.hoistable = p.reorder && !inDelaySlot && !inst.HasDelaySlot()
+		p.delaySlotNext = !p.reorder && inst.HasDelaySlot()
+		return
+	}
+
+	if p.options.HoistDelaySlots && p.hoistable {
+		insts := p.res.Segments[p.segmentStart]
+		prev := insts[len(insts)-1]
+		if canHoistIntoDelaySlot(&prev, inst) {
+			prevAddr := p.instructionAddr - 4
+			prevLine := p.instructionLines[prevAddr]
+			insts[len(insts)-1] = *inst
+			p.instructionLines[prevAddr] = line
+			delete(p.instructionExpansions, prevAddr)
+			if p.expansion != "" {
+				p.instructionExpansions[prevAddr] = p.expansion
+			}
+			p.hoistable = false
+			p.emitExpanded(prevLine, prev, "hoisted into delay slot")
+			return
+		}
+	}
+	p.emit(line, *inst)
+	p.hoistable = false
+	p.emitExpanded(line, Instruction{Name: "NOP"}, "delay slot NOP")
+}
+
+func (p *executableParser) parseDirective(line *TokenizedLine) {
+	p.hoistable = false
+	p.delaySlotNext = false
+	dir := line.Directive
+	switch dir.Name {
+	case "word":
+		if dir.Argument == "" {
+			p.emit(line, *decodeInstructionAt(dir.Constant, p.instructionAddr))
+			break
+		}
+		ptr, err := p.locals.resolve(CodePointer{IsSymbol: true, Symbol: dir.Argument})
+		if err != nil {
+			p.diagnostics.addError(line, dir.Span.Column, err.Error(), "")
+		}
+		p.wordSymbols = append(p.wordSymbols, wordSymbol{
+			addr:   p.instructionAddr,
+			symbol: ptr.Symbol,
+			line:   line,
+		})
+		// The value is filled in once every symbol's address is known.
+		p.emit(line, Instruction{Name: "NOP"})
+	case "globl":
+		p.globals[dir.Argument] = line
+	case "section":
+		if !p.object {
+			p.diagnostics.addError(line, dir.Span.Column,
+				"sections are only supported in relocatable objects",
+				"use .text with an address to place code in an executable")
+			return
+		}
+		p.switchSection(dir.Argument)
+	case "text":
+		if p.object {
+			p.diagnostics.addError(line, dir.Span.Column,
+				"addresses cannot be given in relocatable objects",
+				"use .text without an address, and choose addresses when linking")
+			return
+		} else if dir.Constant&3 != 0 {
+			p.diagnostics.addError(line, dir.Span.Column, "misaligned segment",
+				"segment addresses must be multiples of 4")
+			return
+		}
+		p.segmentStart = dir.Constant
+		p.instructionAddr = dir.Constant
+	case "set":
+		switch dir.Argument {
+		case "reorder":
+			p.reorder = true
+		case "noreorder":
+			p.reorder = false
+		default:
+			p.diagnostics.addError(line, dir.Span.Column, "unknown .set option: "+dir.Argument,
+				"supported options are reorder and noreorder")
+		}
+	default:
+		p.diagnostics.addError(line, dir.Span.Column, "unknown directive: ."+dir.Name, "")
+	}
+}
+
+// emit adds an instruction at the current address.
+// If the address is already in use, an error is recorded and the instruction is dropped.
+func (p *executableParser) emit(line *TokenizedLine, inst Instruction) {
+	if p.res.addressInUse(p.instructionAddr) {
+		hexStr := "0x" + strconv.FormatUint(uint64(p.instructionAddr), 16)
+		p.diagnostics.addError(line, 0, "overwriting address "+hexStr, "")
+	} else {
+		p.res.Segments[p.segmentStart] = append(p.res.Segments[p.segmentStart], inst)
+		p.instructionLines[p.instructionAddr] = line
+		if p.expansion != "" {
+			p.instructionExpansions[p.instructionAddr] = p.expansion
+		}
+	}
+	p.instructionAddr += 4
+}
+
+// emitExpanded is like emit, but for an instruction which the assembler generated.
+// If the instruction is part of a larger expansion, the larger expansion's description is kept.
+func (p *executableParser) emitExpanded(line *TokenizedLine, inst Instruction, expansion string) {
+	outer := p.expansion
+	if outer == "" {
+		p.expansion = expansion
+	}
+	p.emit(line, inst)
+	p.expansion = outer
+}
+
+// canHoistIntoDelaySlot checks if an instruction can be moved from before a branch or jump into
+// its delay slot.
+// This is not the case if the branch depends on a register that the instruction changes, or if
+// the branch changes a register that the instruction uses.
+func canHoistIntoDelaySlot(inst, branch *Instruction) bool {
+	if inst.Name == ".word" || inst.HasDelaySlot() {
+		return false
+	}
+	if branch.IsBranch() && !branch.CodePointer.IsSymbol {
+		// Moving a branch with a hard-coded offset would change its destination.
+		return false
+	}
+	return independentOfBranch(inst, branch)
+}
+
+// independentOfBranch checks if an instruction and a branch or jump can run in either order:
+// the branch does not depend on a register that the instruction changes, and the branch does
+// not change a register that the instruction uses.
+func independentOfBranch(inst, branch *Instruction) bool {
+	written := inst.WrittenRegisters()
+	for _, reg := range branch.ReadRegisters() {
+		if reg != 0 && containsRegister(written, reg) {
+			return false
+		}
+	}
+	used := append(inst.ReadRegisters(), written...)
+	for _, reg := range branch.WrittenRegisters() {
+		if containsRegister(used, reg) {
+			return false
+		}
+	}
+	return true
+}
+
+func containsRegister(list []int, reg int) bool {
+	for _, x := range list {
+		if x == reg {
+			return true
+		}
+	}
+	return false
+}
+
+// parseSourceInstruction parses an instruction from assembly source.
+//
+// Pseudo-instructions are only used to render executables, so they are rejected.
+func parseSourceInstruction(inst *TokenizedInstruction) (*Instruction, error) {
+	if isPseudoName(inst.Name) {
+		return nil, errors.New("pseudo-instruction cannot be assembled: " + inst.Name)
+	}
+	return ParseTokenizedInstruction(inst)
+}
+
+// instructionProblem finds the column and a hint for an instruction which could not be parsed.
+func instructionProblem(inst *TokenizedInstruction) (column int, hint string) {
+	column = inst.NameSpan.Column
+	if isPseudoName(inst.Name) {
+		return column, pseudoInstructionHint(inst)
+	}
+	var usages []string
+	worstArg := -1
+	for _, template := range Templates {
+		if template.Name != inst.Name {
+			continue
+		}
+		usages = append(usages, template.String())
+		if idx := template.mismatchedArgument(inst); idx > worstArg {
+			worstArg = idx
+		}
+	}
+	if len(usages) == 0 {
+		if name := closestInstructionName(inst.Name); name != "" {
+			hint = "did you mean " + name + "?"
+		}
+		return
+	}
+	if worstArg >= 0 && worstArg < len(inst.ArgumentSpans) {
+		column = inst.ArgumentSpans[worstArg].Column
+	}
+	return column, "expected " + strings.Join(usages, " or ")
+}
+
+// pseudoInstructionHint suggests the real instructions which a pseudo-instruction stands for.
+func pseudoInstructionHint(inst *TokenizedInstruction) string {
+	parsed, err := ParseTokenizedInstruction(inst)
+	if err != nil {
+		return "write the real instructions which it stands for"
+	}
+	var expansion []string
+	for _, x := range parsed.Expand() {
+		line, err := x.Render()
+		if err != nil {
+			return "write the real instructions which it stands for"
+		}
+		expansion = append(expansion, line.String())
+	}
+	return "write " + strings.Join(expansion, " and ") + " instead"
+}
+
+// lastArgumentColumn returns the column of an instruction's last argument, or the column of its
+// name if its arguments have no spans.
+func lastArgumentColumn(inst *TokenizedInstruction) int {
+	if len(inst.ArgumentSpans) == 0 {
+		return inst.NameSpan.Column
+	}
+	return inst.ArgumentSpans[len(inst.ArgumentSpans)-1].Column
+}
+
+// closestInstructionName finds the known instruction name with the smallest edit distance to a
+// misspelled name. It returns "" if no name is reasonably close.
+func closestInstructionName(name string) string {
+	name = strings.ToUpper(name)
+	var best string
+	bestDistance := 3
+	for _, template := range Templates {
+		if d := editDistance(name, template.Name); d < bestDistance {
+			best = template.Name
+			bestDistance = d
+		}
+	}
+	return best
+}
+
+func editDistance(a, b string) int {
+	row := make([]int, len(b)+1)
+	for j := range row {
+		row[j] = j
+	}
+	for i := 1; i <= len(a); i++ {
+		diagonal := row[0]
+		row[0] = i
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+			next := diagonal + cost
+			if row[j]+1 < next {
+				next = row[j] + 1
+			}
+			if row[j-1]+1 < next {
+				next = row[j-1] + 1
+			}
+			diagonal = row[j]
+			row[j] = next
+		}
+	}
+	return row[len(b)]
+}
@@ -0,0 +1,272 @@
+package mips32
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// A Preprocessor tokenizes source files and expands the directives which operate on source text
+// rather than on instructions, such as ".include" and the conditional assembly directives ".if",
+// ".ifdef", ".ifndef", ".elseif", ".else", and ".endif".
+//
+// Every line produced by a Preprocessor carries the name of the file it came from, so errors can
+// be reported as "lib/util.s:42" rather than just "line 42".
+type Preprocessor struct {
+	// IncludePaths lists directories which are searched for an included file when it cannot be
+	// found relative to the including file.
+	IncludePaths []string
+
+	// Defines maps names to values for use in conditional assembly directives.
+	Defines map[string]int64
+
+	// ReadFile is used to read source files.
+	// If this is nil, ioutil.ReadFile is used.
+	ReadFile func(path string) ([]byte, error)
+}
+
+// Define adds a definition of the form "NAME=value" or "NAME" to p.Defines.
+// If no value is given, the name is defined as 1.
+func (p *Preprocessor) Define(definition string) error {
+	name := definition
+	value := int64(1)
+	if idx := strings.Index(definition, "="); idx >= 0 {
+		name = definition[:idx]
+		var err error
+		value, err = strconv.ParseInt(definition[idx+1:], 0, 64)
+		if err != nil {
+			return errors.New("invalid value in definition: " + definition)
+		}
+	}
+	if name == "" || !symbolRegexp.MatchString(name) {
+		return errors.New("invalid name in definition: " + definition)
+	}
+	if p.Defines == nil {
+		p.Defines = map[string]int64{}
+	}
+	p.Defines[name] = value
+	return nil
+}
+
+// TokenizeFile reads a source file, tokenizes it, and expands its directives.
+func (p *Preprocessor) TokenizeFile(path string) ([]TokenizedLine, error) {
+	source, err := p.readFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return p.TokenizeSource(path, string(source))
+}
+
+// TokenizeSource tokenizes source code and expands its directives.
+//
+// The file argument names the source for error messages and is used to resolve relative
+// ".include" paths. It may be empty, in which case includes are resolved relative to the working
+// directory.
+//
+// If any errors are found, the returned error is a DiagnosticList describing all of them.
+func (p *Preprocessor) TokenizeSource(file, source string) ([]TokenizedLine, error) {
+	var stack []includedFile
+	if file != "" {
+		stack = append(stack, includedFile{includeKey(file), file})
+	}
+	var diagnostics DiagnosticList
+	lines := p.expandSource(file, source, stack, &diagnostics)
+	if err := diagnostics.Err(); err != nil {
+		return nil, err
+	}
+	return lines, nil
+}
+
+func (p *Preprocessor) expandSource(file, source string, stack []includedFile,
+	diagnostics *DiagnosticList) []TokenizedLine {
+	lines, lineDiagnostics := tokenizeSourceDiagnostics(file, source)
+	res := make([]TokenizedLine, 0, len(lines))
+	var conditions []conditionState
+
+	// Syntax errors are only reported for lines which are assembled, so that code in inactive
+	// conditional blocks can use syntax which this assembler does not understand.
+	addLineDiagnostics := func(beforeLine int) {
+		for len(lineDiagnostics) > 0 && lineDiagnostics[0].Line < beforeLine {
+			if len(conditions) == 0 || conditions[len(conditions)-1].active {
+				*diagnostics = append(*diagnostics, lineDiagnostics[0])
+			}
+			lineDiagnostics = lineDiagnostics[1:]
+		}
+	}
+
+	for _, line := range lines {
+		addLineDiagnostics(line.LineNumber)
+		if line.Directive != nil && isConditionalDirective(line.Directive.Name) {
+			conditions = p.updateConditions(&line, conditions, diagnostics)
+			continue
+		}
+		if len(conditions) > 0 && !conditions[len(conditions)-1].active {
+			continue
+		}
+		if line.Directive == nil || line.Directive.Name != "include" {
+			res = append(res, line)
+			continue
+		}
+		res = append(res, p.include(&line, stack, diagnostics)...)
+	}
+	addLineDiagnostics(strings.Count(source, "\n") + 2)
+	for i := len(conditions) - 1; i >= 0; i-- {
+		cond := &conditions[i]
+		diagnostics.addError(&cond.line, cond.line.Directive.Span.Column,
+			"."+cond.line.Directive.Name+" without .endif", "")
+	}
+	return res
+}
+
+// updateConditions applies a conditional assembly directive to a stack of conditions.
+//
+// Errors are added to the diagnostics. A condition which cannot be evaluated is treated as false,
+// so that the rest of the file can still be checked.
+func (p *Preprocessor) updateConditions(line *TokenizedLine, conditions []conditionState,
+	diagnostics *DiagnosticList) []conditionState {
+	dir := line.Directive
+	enclosingActive := true
+	if dir.Name == "if" || dir.Name == "ifdef" || dir.Name == "ifndef" {
+		if len(conditions) > 0 {
+			enclosingActive = conditions[len(conditions)-1].active
+		}
+	} else {
+		if len(conditions) == 0 {
+			diagnostics.addError(line, dir.Span.Column, "."+dir.Name+" without .if", "")
+			return conditions
+		} else if dir.Name != "endif" && conditions[len(conditions)-1].sawElse {
+			diagnostics.addError(line, dir.Span.Column, "."+dir.Name+" after .else", "")
+			return conditions
+		}
+		if len(conditions) > 1 {
+			enclosingActive = conditions[len(conditions)-2].active
+		}
+	}
+
+	var result bool
+	switch dir.Name {
+	case "endif":
+		return conditions[:len(conditions)-1]
+	case "else":
+		result = true
+	case "ifdef", "ifndef":
+		_, defined := p.Defines[dir.Argument]
+		result = defined == (dir.Name == "ifdef")
+	case "if", "elseif":
+		if enclosingActive && (dir.Name == "if" || !conditions[len(conditions)-1].taken) {
+			value, err := evaluateExpression(dir.Argument, p.Defines)
+			if err != nil {
+				diagnostics.addError(line, dir.Span.Column, err.Error(), "")
+			}
+			result = value != 0
+		}
+	}
+
+	if dir.Name == "elseif" || dir.Name == "else" {
+		state := &conditions[len(conditions)-1]
+		state.active = enclosingActive && result && !state.taken
+		state.taken = state.taken || state.active
+		state.sawElse = dir.Name == "else"
+		return conditions
+	}
+	active := enclosingActive && result
+	return append(conditions, conditionState{
+		line:   *line,
+		active: active,
+		taken:  active,
+	})
+}
+
+func (p *Preprocessor) include(line *TokenizedLine, stack []includedFile,
+	diagnostics *DiagnosticList) []TokenizedLine {
+	column := line.Directive.Span.Column
+	path, source, err := p.findInclude(line.File, line.Directive.Argument)
+	if err != nil {
+		diagnostics.addError(line, column, err.Error(), "")
+		return nil
+	}
+	key := includeKey(path)
+	for i, entry := range stack {
+		if entry.key == key {
+			var cycle []string
+			for _, f := range stack[i:] {
+				cycle = append(cycle, f.name)
+			}
+			cycle = append(cycle, path)
+			diagnostics.addError(line, column, "include cycle: "+strings.Join(cycle, " -> "), "")
+			return nil
+		}
+	}
+	return p.expandSource(path, string(source), append(stack, includedFile{key, path}),
+		diagnostics)
+}
+
+// findInclude locates an included file, first relative to the including file and then in each
+// of the include paths.
+func (p *Preprocessor) findInclude(includer, name string) (path string, source []byte,
+	err error) {
+	if name == "" {
+		return "", nil, errors.New("empty include path")
+	}
+	candidates := []string{name}
+	if !filepath.IsAbs(name) {
+		candidates = []string{filepath.Join(filepath.Dir(includer), name)}
+		for _, dir := range p.IncludePaths {
+			candidates = append(candidates, filepath.Join(dir, name))
+		}
+	}
+	for _, candidate := range candidates {
+		source, err = p.readFile(candidate)
+		if err == nil {
+			return candidate, source, nil
+		} else if !os.IsNotExist(err) {
+			return "", nil, err
+		}
+	}
+	return "", nil, errors.New("cannot find included file: " + name)
+}
+
+func (p *Preprocessor) readFile(path string) ([]byte, error) {
+	if p.ReadFile != nil {
+		return p.ReadFile(path)
+	}
+	return ioutil.ReadFile(path)
+}
+
+func isConditionalDirective(name string) bool {
+	switch name {
+	case "if", "ifdef", "ifndef", "elseif", "else", "endif":
+		return true
+	}
+	return false
+}
+
+// A conditionState tracks one level of conditional assembly.
+type conditionState struct {
+	// line is the directive which opened the conditional.
+	line TokenizedLine
+
+	// active is set if lines in the current branch should be assembled.
+	active bool
+
+	// taken is set if any branch of this conditional has been active.
+	taken bool
+
+	sawElse bool
+}
+
+type includedFile struct {
+	key  string
+	name string
+}
+
+// includeKey returns a canonical name for a file, used to detect include cycles.
+func includeKey(path string) string {
+	if abs, err := filepath.Abs(path); err == nil {
+		return abs
+	}
+	return filepath.Clean(path)
+}
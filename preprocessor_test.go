@@ -0,0 +1,204 @@
+package mips32
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestPreprocessorInclude(t *testing.T) {
+	files := map[string]string{
+		"main.s": `NOP
+			.include "lib/util.s"
+			J UTIL`,
+		filepath.Join("lib", "util.s"): `UTIL:
+			.include "consts.s"
+			JR $ra`,
+		filepath.Join("inc", "consts.s"): `LUI $t0, 0x1337`,
+	}
+	p := &Preprocessor{
+		IncludePaths: []string{"inc"},
+		ReadFile:     testReadFile(files),
+	}
+	lines, err := p.TokenizeFile("main.s")
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []struct {
+		file string
+		line int
+		text string
+	}{
+		{"main.s", 1, "NOP"},
+		{filepath.Join("lib", "util.s"), 1, "UTIL:"},
+		{filepath.Join("inc", "consts.s"), 1, "LUI $8, 4919"},
+		{filepath.Join("lib", "util.s"), 3, "JR $31"},
+		{"main.s", 3, "J UTIL"},
+	}
+	if len(lines) != len(expected) {
+		t.Fatal("unexpected number of lines:", len(lines))
+	}
+	for i, x := range expected {
+		line := lines[i]
+		if line.File != x.file || line.LineNumber != x.line || line.String() != x.text {
+			t.Errorf("line %d: expected %s:%d %q but got %s %q", i, x.file, x.line, x.text,
+				line.Location(), line.String())
+		}
+	}
+}
+
+func TestPreprocessorIncludeErrors(t *testing.T) {
+	files := map[string]string{
+		"a.s":       `.include "b.s"`,
+		"b.s":       "NOP\n.include \"a.s\"",
+		"missing.s": "NOP\n\n.include \"nothing.s\"",
+		"bad.s":     "NOP\n.include \"broken.s\"",
+		"broken.s":  "LUI $r5 0xDEAD",
+	}
+	p := &Preprocessor{ReadFile: testReadFile(files)}
+
+	expected := map[string]string{
+		"a.s":       "b.s:2:1: error: include cycle: a.s -> b.s -> a.s",
+		"missing.s": "missing.s:3:1: error: cannot find included file: nothing.s",
+		"bad.s":     "broken.s:1:9: ",
+	}
+	for file, prefix := range expected {
+		_, err := p.TokenizeFile(file)
+		if err == nil {
+			t.Error("expected error for", file)
+		} else if !strings.HasPrefix(err.Error(), prefix) {
+			t.Error("unexpected error for", file+":", err)
+		}
+	}
+}
+
+func testReadFile(files map[string]string) func(path string) ([]byte, error) {
+	return func(path string) ([]byte, error) {
+		if contents, ok := files[filepath.Clean(path)]; ok {
+			return []byte(contents), nil
+		}
+		return nil, &os.PathError{Op: "open", Path: path, Err: os.ErrNotExist}
+	}
+}
+
+func TestPreprocessorConditionals(t *testing.T) {
+	source := `.ifdef DEBUG
+		ORI $t0, $0, 1
+		.if LEVEL > 1 && defined(VERBOSE)
+			ORI $t0, $0, 2
+		.elseif LEVEL > 1
+			ORI $t0, $0, 3
+		.else
+			ORI $t0, $0, 4
+		.endif
+	.else
+		ORI $t0, $0, 5
+	.endif
+	.if 0
+		.if UNDEFINED_NAME
+			ORI $t0, $0, 7
+		.endif
+	.endif
+	.ifndef __MIPSEL__
+		ORI $t1, $0, 6
+	.endif`
+	tests := []struct {
+		defines  []string
+		expected []string
+	}{
+		{nil, []string{"ORI $8, $0, 5", "ORI $9, $0, 6"}},
+		{[]string{"DEBUG", "LEVEL=0x2"}, []string{"ORI $8, $0, 1", "ORI $8, $0, 3",
+			"ORI $9, $0, 6"}},
+		{[]string{"DEBUG", "LEVEL=2", "VERBOSE", "__MIPSEL__"}, []string{"ORI $8, $0, 1",
+			"ORI $8, $0, 2"}},
+		{[]string{"DEBUG", "LEVEL=-1"}, []string{"ORI $8, $0, 1", "ORI $8, $0, 4",
+			"ORI $9, $0, 6"}},
+	}
+	for _, test := range tests {
+		p := &Preprocessor{}
+		for _, d := range test.defines {
+			if err := p.Define(d); err != nil {
+				t.Fatal(err)
+			}
+		}
+		lines, err := p.TokenizeSource("test.s", source)
+		if err != nil {
+			t.Error(test.defines, err)
+			continue
+		}
+		var actual []string
+		for _, line := range lines {
+			actual = append(actual, line.String())
+		}
+		if strings.Join(actual, "\n") != strings.Join(test.expected, "\n") {
+			t.Error("unexpected output for", test.defines, "-", actual)
+		}
+	}
+}
+
+func TestPreprocessorConditionalErrors(t *testing.T) {
+	failures := []string{
+		".if 1\nNOP",
+		".endif",
+		".if 1\n.else\n.else\n.endif",
+		".if 1\n.else\n.elseif 1\n.endif",
+		".if FOO\n.endif",
+		".if (1\n.endif",
+		".ifdef 1+2\n.endif",
+		".else 1",
+	}
+	for _, failure := range failures {
+		if _, err := (&Preprocessor{}).TokenizeSource("", failure); err == nil {
+			t.Error("expected error for:", failure)
+		}
+	}
+}
+
+func TestPreprocessorInactiveSyntax(t *testing.T) {
+	source := `.ifdef FANCY_SYNTAX
+	ADDIU $t0, $0, @hi(x) ~~
+.else
+	NOP
+.endif`
+	lines, err := (&Preprocessor{}).TokenizeSource("test.s", source)
+	if err != nil {
+		t.Fatal(err)
+	} else if len(lines) != 1 || lines[0].String() != "NOP" {
+		t.Error("unexpected lines:", lines)
+	}
+
+	p := &Preprocessor{}
+	p.Define("FANCY_SYNTAX")
+	if _, err := p.TokenizeSource("test.s", source); err == nil {
+		t.Error("expected error for active line")
+	}
+}
+
+func TestEvaluateExpression(t *testing.T) {
+	defines := map[string]int64{"A": 5, "B": 0x10}
+	tests := map[string]int64{
+		"1 + 2 * 3":              7,
+		"(1 + 2) * 3":            9,
+		"-A + ~0":                -6,
+		"B >> 2 | 1 << 8":        0x104,
+		"A % 3 == 2 && B != 0":   1,
+		"!A || 0":                0,
+		"defined A + defined(C)": 1,
+		"A >= 5 ^ B < 0x10":      1,
+		"0x10 / -2":              -8,
+	}
+	for expr, expected := range tests {
+		actual, err := evaluateExpression(expr, defines)
+		if err != nil {
+			t.Error(expr, err)
+		} else if actual != expected {
+			t.Error("unexpected value for", expr, "-", actual)
+		}
+	}
+	for _, failure := range []string{"", "1 +", "1 / 0", "C", "1 2", "$t0", "defined"} {
+		if _, err := evaluateExpression(failure, defines); err == nil {
+			t.Error("expected error for:", failure)
+		}
+	}
+}
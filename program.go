@@ -0,0 +1,115 @@
+package mips32
+
+import (
+	"bytes"
+	"errors"
+	"io/ioutil"
+	"os"
+	"strconv"
+)
+
+// ProgramOptions controls how LoadProgram reads a program.
+type ProgramOptions struct {
+	// Format is "asm" for assembly source, the name of an ImageFormat, or "" to guess the format
+	// from the file. Files which are not recognized as images are assembled.
+	Format string
+
+	// LittleEndian is the byte order of images which do not give their own.
+	// Source files are assembled with __MIPSEL__ or __MIPSEB__ defined to match.
+	LittleEndian bool
+
+	// Base is the load address of raw binaries.
+	Base uint32
+
+	// IncludePaths is the .include search path for source files.
+	IncludePaths []string
+
+	// Defines lists preprocessor definitions for source files, like "NAME=value" or "NAME".
+	Defines []string
+
+	// ParseOptions is used to assemble source files.
+	ParseOptions ParseOptions
+}
+
+// LoadProgram reads a program from a file, which may be assembly source or an image in one of
+// the formats of ReadImage.
+//
+// For source files, the image's Memory is empty.
+func LoadProgram(file string, options ProgramOptions) (*Image, error) {
+	data, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	format, isImage := GuessImageFormat(file, data)
+	if options.Format == "asm" {
+		isImage = false
+	} else if options.Format != "" {
+		format, err = ParseImageFormat(options.Format)
+		if err != nil {
+			return nil, err
+		}
+		isImage = true
+	}
+
+	if !isImage {
+		exc, err := assembleProgram(file, options)
+		if err != nil {
+			return nil, err
+		}
+		return &Image{Executable: exc, Memory: NewLazyMemory(), LittleEndian: options.LittleEndian},
+			nil
+	} else if format == RawFormat {
+		return LoadRawImage(data, options.Base, options.LittleEndian), nil
+	}
+	image, err := ReadImage(bytes.NewReader(data), format, options.LittleEndian)
+	if err != nil {
+		return nil, errors.New(file + ": " + err.Error())
+	}
+	return image, nil
+}
+
+func assembleProgram(file string, options ProgramOptions) (*Executable, error) {
+	preprocessor := &Preprocessor{IncludePaths: options.IncludePaths}
+	if options.LittleEndian {
+		preprocessor.Define("__MIPSEL__")
+	} else {
+		preprocessor.Define("__MIPSEB__")
+	}
+	for _, definition := range options.Defines {
+		if err := preprocessor.Define(definition); err != nil {
+			return nil, err
+		}
+	}
+	tokens, err := preprocessor.TokenizeFile(file)
+	if err != nil {
+		return nil, err
+	}
+	return ParseExecutableOptions(tokens, options.ParseOptions)
+}
+
+// ReadLineTableFile reads a line table from a file written by WriteLineTable.
+func ReadLineTableFile(file string) (LineTable, error) {
+	f, err := os.Open(file)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	table, err := ReadLineTable(f)
+	if err != nil {
+		return nil, errors.New(file + ": " + err.Error())
+	}
+	return table, nil
+}
+
+// ResolveEntry finds the address of an entry point given by the user, which may be a symbol or
+// a number (in decimal, or in hexadecimal with a "0x" prefix).
+func (e *Executable) ResolveEntry(entry string) (uint32, error) {
+	if addr, ok := e.Symbols[entry]; ok {
+		return addr, nil
+	}
+	addr, err := strconv.ParseUint(entry, 0, 32)
+	if err != nil {
+		return 0, errors.New("invalid entry point (expected address or symbol): " + entry)
+	}
+	return uint32(addr), nil
+}
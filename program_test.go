@@ -0,0 +1,58 @@
+package mips32
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestLoadProgram(t *testing.T) {
+	dir, err := ioutil.TempDir("", "mips32")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	source := filepath.Join(dir, "prog.s")
+	program := ".text 0x400000\n.if defined(__MIPSEL__) && LIMIT == 7\nORI $t0, $0, 7\n.endif\n"
+	if err := ioutil.WriteFile(source, []byte(program), 0644); err != nil {
+		t.Fatal(err)
+	}
+	options := ProgramOptions{LittleEndian: true, Defines: []string{"LIMIT=7"}}
+	image, err := LoadProgram(source, options)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if inst := image.Executable.Get(0x400000); inst == nil || inst.UnsignedConstant16 != 7 {
+		t.Error("unexpected program:", image.Executable.Segments)
+	}
+
+	binary := filepath.Join(dir, "prog.bin")
+	if err := ioutil.WriteFile(binary, []byte{0x34, 0x08, 0x00, 0x05}, 0644); err != nil {
+		t.Fatal(err)
+	}
+	image, err = LoadProgram(binary, ProgramOptions{Base: 0xbfc00000})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if inst := image.Executable.Get(0xbfc00000); inst == nil || inst.Name != "ORI" {
+		t.Error("unexpected image:", image.Executable.Segments)
+	}
+	if _, err := LoadProgram(binary, ProgramOptions{Format: "asm"}); err == nil {
+		t.Error("expected error when assembling a binary")
+	}
+}
+
+func TestResolveEntry(t *testing.T) {
+	exc := &Executable{Symbols: map[string]uint32{"reset": 0xbfc00000}}
+	for entry, expected := range map[string]uint32{"reset": 0xbfc00000, "0x400000": 0x400000,
+		"16": 16} {
+		if addr, err := exc.ResolveEntry(entry); err != nil || addr != expected {
+			t.Errorf("entry %s: got 0x%x (%v)", entry, addr, err)
+		}
+	}
+	if _, err := exc.ResolveEntry("missing"); err == nil {
+		t.Error("expected error for unknown symbol")
+	}
+}
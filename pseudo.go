@@ -0,0 +1,170 @@
+package mips32
+
+// IsPseudo returns true if this is a pseudo-instruction, which must be expanded into real
+// instructions before it can be encoded or executed.
+func (i *Instruction) IsPseudo() bool {
+	return isPseudoName(i.Name)
+}
+
+func isPseudoName(name string) bool {
+	for _, template := range PseudoTemplates {
+		if template.Name == name {
+			return true
+		}
+	}
+	return false
+}
+
+// Expand returns the real instructions which a pseudo-instruction stands for.
+// Other instructions are returned unchanged.
+//
+// LI uses the shortest sequence which loads its constant: ADDIU for signed 16-bit values, ORI
+// for unsigned 16-bit values, LUI when the low 16 bits are zero, and LUI followed by ORI
+// otherwise.
+func (i *Instruction) Expand() []Instruction {
+	switch i.Name {
+	case "B":
+		return []Instruction{{Name: "BEQ", Registers: []int{0, 0}, CodePointer: i.CodePointer}}
+	case "BEQZ", "BNEZ":
+		return []Instruction{{
+			Name:        i.Name[:3],
+			Registers:   []int{i.Registers[0], 0},
+			CodePointer: i.CodePointer,
+		}}
+	case "LI":
+		return loadImmediate(i.Registers[0], i.Constant32)
+	case "MOVE":
+		return []Instruction{{Name: "ADDU", Registers: []int{i.Registers[0], i.Registers[1], 0}}}
+	case "NEGU":
+		return []Instruction{{Name: "SUBU", Registers: []int{i.Registers[0], 0, i.Registers[1]}}}
+	case "NOT":
+		return []Instruction{{Name: "NOR", Registers: []int{i.Registers[0], i.Registers[1], 0}}}
+	}
+	return []Instruction{*i}
+}
+
+func loadImmediate(reg int, value uint32) []Instruction {
+	if value == uint32(int32(int16(value))) {
+		return []Instruction{{
+			Name:             "ADDIU",
+			Registers:        []int{reg, 0},
+			SignedConstant16: int16(value),
+		}}
+	} else if value <= 0xffff {
+		return []Instruction{{
+			Name:               "ORI",
+			Registers:          []int{reg, 0},
+			UnsignedConstant16: uint16(value),
+		}}
+	}
+	res := []Instruction{{
+		Name:               "LUI",
+		Registers:          []int{reg},
+		UnsignedConstant16: uint16(value >> 16),
+	}}
+	if value&0xffff != 0 {
+		res = append(res, Instruction{
+			Name:               "ORI",
+			Registers:          []int{reg, reg},
+			UnsignedConstant16: uint16(value),
+		})
+	}
+	return res
+}
+
+// FoldIdioms replaces common instructions and pairs of instructions with the pseudo-instructions
+// which expand into them, like "MOVE $v0, $a0" for "ADDU $v0, $a0, $0" or "B target" for
+// "BEQ $0, $0, target". This makes disassembled compiler output easier to read.
+//
+// The instructions start at the given address.
+// A pair is never folded if a symbol points to its second instruction, since the symbol would be
+// lost, or if its first instruction is in a delay slot.
+// Only sequences which the pseudo-instruction expands back into are folded, so the result
+// assembles into the same code.
+func FoldIdioms(insts []Instruction, start uint32, symbols map[string]uint32) []Instruction {
+	labeled := map[uint32]bool{}
+	for _, addr := range symbols {
+		labeled[addr] = true
+	}
+	var res []Instruction
+	for i := 0; i < len(insts); i++ {
+		inDelaySlot := i > 0 && insts[i-1].HasDelaySlot()
+		if i+1 < len(insts) && !inDelaySlot && !labeled[start+uint32(i+1)*4] {
+			if folded, ok := foldPair(&insts[i], &insts[i+1]); ok {
+				res = append(res, folded)
+				i++
+				continue
+			}
+		}
+		res = append(res, foldSingle(&insts[i]))
+	}
+	return res
+}
+
+// foldPair turns "LUI $r, hi" and "ORI $r, $r, lo" into "LI $r, value".
+func foldPair(first, second *Instruction) (Instruction, bool) {
+	if first.Name != "LUI" || second.Name != "ORI" || first.AddressHalf != nil ||
+		second.AddressHalf != nil || first.UnsignedConstant16 == 0 ||
+		second.UnsignedConstant16 == 0 {
+		return Instruction{}, false
+	}
+	reg := first.Registers[0]
+	if second.Registers[0] != reg || second.Registers[1] != reg {
+		return Instruction{}, false
+	}
+	return Instruction{
+		Name:       "LI",
+		Registers:  []int{reg},
+		Constant32: uint32(first.UnsignedConstant16)<<16 | uint32(second.UnsignedConstant16),
+	}, true
+}
+
+// foldSingle turns a single instruction into a pseudo-instruction, or returns it unchanged.
+func foldSingle(inst *Instruction) Instruction {
+	if inst.AddressHalf != nil {
+		return *inst
+	}
+	regs := inst.Registers
+	switch inst.Name {
+	case "ADDIU":
+		if regs[1] == 0 {
+			return Instruction{
+				Name:       "LI",
+				Registers:  []int{regs[0]},
+				Constant32: uint32(int32(inst.SignedConstant16)),
+			}
+		}
+	case "ORI":
+		// Smaller values would be loaded with ADDIU.
+		if regs[1] == 0 && inst.UnsignedConstant16 >= 0x8000 {
+			return Instruction{
+				Name:       "LI",
+				Registers:  []int{regs[0]},
+				Constant32: uint32(inst.UnsignedConstant16),
+			}
+		}
+	case "ADDU":
+		if regs[2] == 0 {
+			return Instruction{Name: "MOVE", Registers: []int{regs[0], regs[1]}}
+		}
+	case "NOR":
+		if regs[2] == 0 {
+			return Instruction{Name: "NOT", Registers: []int{regs[0], regs[1]}}
+		}
+	case "SUBU":
+		if regs[1] == 0 {
+			return Instruction{Name: "NEGU", Registers: []int{regs[0], regs[2]}}
+		}
+	case "BEQ", "BNE":
+		if regs[0] == 0 && regs[1] == 0 && inst.Name == "BEQ" {
+			return Instruction{Name: "B", CodePointer: inst.CodePointer}
+		} else if regs[0] != 0 && regs[1] == 0 {
+			return Instruction{
+				Name:        inst.Name + "Z",
+				Registers:   []int{regs[0]},
+				CodePointer: inst.CodePointer,
+			}
+		}
+	}
+	return *inst
+}
@@ -0,0 +1,111 @@
+package mips32
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestPseudoInstructions(t *testing.T) {
+	lines, err := TokenizeSource(`.text 0x400100
+main: ADDU $v0, $a0, $0
+	LUI $t0, 0x1234
+	ORI $t0, $t0, 0x5678
+	ADDIU $t1, $0, -5
+	ORI $t2, $0, 0xbeef
+	LUI $t3, 1
+	NOR $t4, $t5, $0
+	SUBU $t6, $0, $t7
+	BEQ $t0, $0, main
+	NOP
+	BNE $t0, $0, main
+	NOP
+	BEQ $0, $0, main
+	NOP`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assembled, err := ParseExecutable(lines)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var buf bytes.Buffer
+	if err := WriteRawImage(&buf, assembled, 0x400100, false); err != nil {
+		t.Fatal(err)
+	}
+
+	exc := LoadRawImage(buf.Bytes(), 0x400100, false).Executable
+	exc.Symbols["main"] = 0x400100
+	exc.SymbolizeTargets()
+	rendered, err := exc.RenderWithOptions(RenderOptions{FoldIdioms: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var source string
+	for _, line := range rendered {
+		source += line.String() + "\n"
+	}
+	expected := `.text 4194560
+main:
+MOVE $2, $4
+LI $8, 305419896
+LI $9, -5
+LI $10, 48879
+LUI $11, 1
+NOT $12, $13
+NEGU $14, $15
+BEQZ $8, main
+NOP
+BNEZ $8, main
+NOP
+B main
+NOP
+`
+	if source != expected {
+		t.Errorf("expected:\n%s\ngot:\n%s", expected, source)
+	}
+}
+
+func TestFoldIdioms(t *testing.T) {
+	insts := []Instruction{
+		{Name: "LUI", Registers: []int{8}, UnsignedConstant16: 0x1234},
+		{Name: "ORI", Registers: []int{8, 8}, UnsignedConstant16: 0x5678},
+		{Name: "LUI", Registers: []int{9}, UnsignedConstant16: 0x1234},
+		{Name: "ORI", Registers: []int{9, 9}, UnsignedConstant16: 0x5678},
+		{Name: "LUI", Registers: []int{10}, UnsignedConstant16: 0x1234},
+		{Name: "ORI", Registers: []int{10, 11}, UnsignedConstant16: 0x5678},
+		{Name: "ORI", Registers: []int{10, 0}, UnsignedConstant16: 0x10},
+		{Name: "JR", Registers: []int{31}},
+		{Name: "LUI", Registers: []int{2}, UnsignedConstant16: 0x1234},
+		{Name: "ORI", Registers: []int{2, 2}, UnsignedConstant16: 0x5678},
+	}
+	// A label on the second ORI keeps its pair from being folded.
+	folded := FoldIdioms(insts, 0x100, map[string]uint32{"x": 0x10c})
+	names := []string{"LI", "LUI", "ORI", "LUI", "ORI", "ORI", "JR", "LUI", "ORI"}
+	if len(folded) != len(names) {
+		t.Fatal("unexpected result:", folded)
+	}
+	for i, name := range names {
+		if folded[i].Name != name {
+			t.Errorf("instruction %d: expected %s but got %s", i, name, folded[i].Name)
+		}
+	}
+	if folded[0].Constant32 != 0x12345678 {
+		t.Errorf("unexpected LI value: 0x%x", folded[0].Constant32)
+	}
+}
+
+func TestPseudoInstructionsNotAssembled(t *testing.T) {
+	lines, err := TokenizeSource("MOVE $v0, $a0\nLI $t0, 0x12345678")
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = ParseExecutable(lines)
+	list, ok := err.(DiagnosticList)
+	if !ok || len(list) != 2 {
+		t.Fatal("unexpected error:", err)
+	}
+	if list[0].Hint != "write ADDU $2, $4, $0 instead" ||
+		list[1].Hint != "write LUI $8, 4660 and ORI $8, $8, 22136 instead" {
+		t.Error("unexpected hints:", list)
+	}
+}
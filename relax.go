@@ -0,0 +1,155 @@
+package mips32
+
+// atRegister is the assembler temporary register, which relaxed branches and jumps overwrite.
+const atRegister = 1
+
+// invertedBranches maps each branch to the branch with the opposite condition.
+var invertedBranches = map[string]string{
+	"BEQ":  "BNE",
+	"BNE":  "BEQ",
+	"BGEZ": "BLTZ",
+	"BLTZ": "BGEZ",
+	"BGTZ": "BLEZ",
+	"BLEZ": "BGTZ",
+}
+
+// A relaxation tracks which branches and jumps must be rewritten because they cannot reach
+// their targets.
+//
+// Rewriting a branch makes the program longer, which moves other targets and may push more
+// branches out of range, so the program is laid out repeatedly until nothing changes.
+type relaxation struct {
+	// lines contains the lines whose branch or jump is rewritten.
+	lines map[*TokenizedLine]bool
+
+	// changed is set if lines were added during the current pass.
+	changed bool
+
+	// symbols is the symbol table from the previous pass, used to find the targets of the
+	// rewritten instructions.
+	symbols map[string]uint32
+
+	// used records the target addresses which the current pass took from symbols.
+	used map[string]uint32
+}
+
+func newRelaxation() *relaxation {
+	return &relaxation{
+		lines:   map[*TokenizedLine]bool{},
+		symbols: map[string]uint32{},
+		used:    map[string]uint32{},
+	}
+}
+
+// add marks a line for relaxation in the next pass.
+func (r *relaxation) add(line *TokenizedLine) {
+	if !r.lines[line] {
+		r.lines[line] = true
+		r.changed = true
+	}
+}
+
+// target finds the absolute address which a rewritten instruction at addr should reach.
+func (r *relaxation) target(inst *Instruction, addr uint32) uint32 {
+	ptr := inst.CodePointer
+	if ptr.IsSymbol {
+		target := r.symbols[ptr.Symbol]
+		r.used[ptr.Symbol] = target
+		return target
+	} else if inst.IsBranch() {
+		return addr + 4 + ptr.Constant
+	}
+	return ptr.Constant
+}
+
+// nextPass prepares for another layout pass using the symbols from the pass which just ended.
+// It returns false if the layout has converged, in which case no more passes are needed.
+func (r *relaxation) nextPass(symbols map[string]uint32) bool {
+	stale := r.changed
+	for name, addr := range r.used {
+		if symbols[name] != addr {
+			stale = true
+		}
+	}
+	r.changed = false
+	r.symbols = symbols
+	r.used = map[string]uint32{}
+	return stale
+}
+
+// parseRelaxed emits a branch or jump which cannot reach its target as a longer sequence which
+// loads the target into $at and jumps to it with JR or JALR.
+//
+// A branch is inverted so that it skips over the jump:
+//
+//	BNE $a, $b, skip    # was BEQ $a, $b, target
+//	<delay slot>
+//	LUI $at, %hi(target)
+//	ORI $at, $at, %lo(target)
+//	JR $at
+//	NOP
+//	skip:
+//
+// The original delay slot instruction still runs whether or not the branch is taken, and the
+// inverted branch lands on skip, just past the NOP in the delay slot of JR.
+func (p *executableParser) parseRelaxed(line *TokenizedLine, inst *Instruction) {
+	target := p.relax.target(inst, p.instructionAddr)
+	expansion := "relaxed " + inst.Name
+	p.expansion = expansion
+	defer func() {
+		p.expansion = ""
+	}()
+	loadTarget := []Instruction{
+		{
+			Name:               "LUI",
+			Registers:          []int{atRegister},
+			UnsignedConstant16: uint16(target >> 16),
+		},
+		{
+			Name:               "ORI",
+			Registers:          []int{atRegister, atRegister},
+			UnsignedConstant16: uint16(target),
+		},
+	}
+	p.hoistable = false
+
+	if !inst.IsBranch() {
+		for _, x := range loadTarget {
+			p.emit(line, x)
+		}
+		jump := &Instruction{Name: "JR", Registers: []int{atRegister}}
+		if inst.Name == "JAL" {
+			jump.Name = "JALR"
+		}
+		p.parseInstruction(line, jump)
+		return
+	}
+
+	inverted := &Instruction{
+		Name:        invertedBranches[inst.Name],
+		Registers:   inst.Registers,
+		CodePointer: CodePointer{Constant: uint32(len(loadTarget)+3) * 4},
+	}
+	p.parseInstruction(line, inverted)
+	tail := append(loadTarget, Instruction{Name: "JR", Registers: []int{atRegister}},
+		Instruction{Name: "NOP"})
+	if p.reorder {
+		// The delay slot has already been filled with a NOP.
+		for _, x := range tail {
+			p.emit(line, x)
+		}
+	} else {
+		p.relaxTail = tail
+		p.relaxTailLine = line
+		p.relaxTailExpansion = expansion
+	}
+}
+
+// flushRelaxTail emits the rest of a relaxed branch after its delay slot.
+func (p *executableParser) flushRelaxTail() {
+	tail := p.relaxTail
+	p.relaxTail = nil
+	for _, x := range tail {
+		p.emitExpanded(p.relaxTailLine, x, p.relaxTailExpansion)
+	}
+}
@@ -0,0 +1,189 @@
+package mips32
+
+import (
+	"errors"
+	"strconv"
+	"strings"
+)
+
+// A RegisterStyle selects how registers are written in rendered source code.
+type RegisterStyle int
+
+const (
+	// NumericRegisters writes registers by number, as in "$8".
+	NumericRegisters RegisterStyle = iota
+
+	// ABIRegisters writes registers by their conventional names, as in "$t0".
+	ABIRegisters
+
+	// RRegisters writes registers by number with an "r" prefix, as in "$r8".
+	RRegisters
+)
+
+var registerStyleNames = []string{"numeric", "abi", "r"}
+
+// ParseRegisterStyle finds the register style with a given name: numeric, abi, or r.
+func ParseRegisterStyle(name string) (RegisterStyle, error) {
+	for i, x := range registerStyleNames {
+		if x == name {
+			return RegisterStyle(i), nil
+		}
+	}
+	return 0, errors.New("unknown register style: " + name + " (expected one of " +
+		strings.Join(registerStyleNames, ", ") + ")")
+}
+
+func (r RegisterStyle) String() string {
+	if int(r) < len(registerStyleNames) {
+		return registerStyleNames[r]
+	}
+	return "RegisterStyle(" + strconv.Itoa(int(r)) + ")"
+}
+
+var abiRegisterNames = [32]string{
+	"zero", "at", "v0", "v1", "a0", "a1", "a2", "a3",
+	"t0", "t1", "t2", "t3", "t4", "t5", "t6", "t7",
+	"s0", "s1", "s2", "s3", "s4", "s5", "s6", "s7",
+	"t8", "t9", "k0", "k1", "gp", "sp", "fp", "ra",
+}
+
+// RenderOptions controls the style of rendered source code.
+//
+// The zero value is the default style: numeric register names, decimal constants, upper case
+// mnemonics, operands separated by ", ", and comments one space after the code.
+type RenderOptions struct {
+	Registers RegisterStyle
+
+	// HexArguments lists the kinds of instruction arguments whose constants are written in
+	// hexadecimal. The MemoryAddress entry applies to the offsets of memory references.
+	HexArguments map[ArgumentType]bool
+
+	// PreserveConstants writes constants the way they were spelled in the source code, when it
+	// is known, rather than in decimal or hexadecimal.
+	PreserveConstants bool
+
+	// HexDirectives writes the constants of directives like ".text" and ".word" in hexadecimal.
+	HexDirectives bool
+
+	LowercaseMnemonics bool
+
+	// MnemonicWidth pads instruction names to at least this many characters, so that the
+	// operands of consecutive lines start in the same column.
+	MnemonicWidth int
+
+	// CompactOperands separates operands with "," rather than ", ".
+	CompactOperands bool
+
+	// CommentColumn is the column (starting at 1) where comments after code are placed.
+	// A comment is placed one space after code which reaches past this column.
+	// If it is 0, comments always follow their code after one space.
+	CommentColumn int
+
+	// FoldIdioms writes common instruction sequences as pseudo-instructions when rendering an
+	// executable. See FoldIdioms for details.
+	FoldIdioms bool
+}
+
+// AllHexArguments returns a HexArguments map which writes every kind of constant in
+// hexadecimal.
+func AllHexArguments() map[ArgumentType]bool {
+	return map[ArgumentType]bool{
+		SignedConstant16:    true,
+		UnsignedConstant16:  true,
+		Constant5:           true,
+		AbsoluteCodePointer: true,
+		RelativeCodePointer: true,
+		MemoryAddress:       true,
+		Constant32:          true,
+	}
+}
+
+// ParseHexArguments parses a comma-separated list of argument kinds for the HexArguments option.
+// The kinds are named as in usage hints (signed16, unsigned16, shift5, target, label and
+// constant32), except that memory offsets are called "offset".
+// The name "all" selects every kind.
+func ParseHexArguments(list string) (map[ArgumentType]bool, error) {
+	res := map[ArgumentType]bool{}
+	if list == "" {
+		return res, nil
+	}
+	all := AllHexArguments()
+NameLoop:
+	for _, name := range strings.Split(list, ",") {
+		name = strings.TrimSpace(name)
+		if name == "all" {
+			return all, nil
+		} else if name == "offset" {
+			res[MemoryAddress] = true
+			continue
+		}
+		for arg := range all {
+			if arg != MemoryAddress && arg.String() == name {
+				res[arg] = true
+				continue NameLoop
+			}
+		}
+		return nil, errors.New("unknown argument kind: " + name)
+	}
+	return res, nil
+}
+
+func (o *RenderOptions) register(reg int) string {
+	switch o.Registers {
+	case ABIRegisters:
+		return "$" + abiRegisterNames[reg&31]
+	case RRegisters:
+		return "$r" + strconv.Itoa(reg)
+	}
+	return registerToString(reg)
+}
+
+func (o *RenderOptions) mnemonic(name string) string {
+	if o.LowercaseMnemonics {
+		return strings.ToLower(name)
+	}
+	return name
+}
+
+func (o *RenderOptions) operandSeparator() string {
+	if o.CompactOperands {
+		return ","
+	}
+	return ", "
+}
+
+// signedConstant formats a constant which is read as a signed number.
+func (o *RenderOptions) signedConstant(c int32, arg ArgumentType) string {
+	if !o.HexArguments[arg] {
+		return signedConst32ToString(c)
+	} else if c < 0 {
+		return "-0x" + strconv.FormatUint(uint64(-int64(c)), 16)
+	}
+	return "0x" + strconv.FormatUint(uint64(c), 16)
+}
+
+// unsignedConstant formats a constant which is read as an unsigned number.
+func (o *RenderOptions) unsignedConstant(c uint32, arg ArgumentType) string {
+	if o.HexArguments[arg] {
+		return "0x" + strconv.FormatUint(uint64(c), 16)
+	}
+	return unsignedConst32ToString(c)
+}
+
+// directiveConstant formats the constant of a directive.
+func (o *RenderOptions) directiveConstant(c uint32) string {
+	if o.HexDirectives {
+		return "0x" + strconv.FormatUint(uint64(c), 16)
+	}
+	return unsignedConst32ToString(c)
+}
+
+// withComment adds a comment to a rendered line.
+func (o *RenderOptions) withComment(code, comment string) string {
+	if code == "" {
+		return "#" + comment
+	} else if len(code)+1 < o.CommentColumn {
+		return padRight(code, o.CommentColumn-1) + "#" + comment
+	}
+	return code + " #" + comment
+}
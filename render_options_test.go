@@ -0,0 +1,113 @@
+package mips32
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestRenderOptions(t *testing.T) {
+	source := `.text 0x400100
+main: ADDIU $t0, $0, -16 # count
+	ORI $t1, $t1, 0xbeef
+	SW $ra, -8($sp)
+	SLL $t2, $t1, 4
+	BNE $t0, $0, -8
+	LI $t3, 0x80000000
+	JR $ra`
+	lines, err := TokenizeSource(source)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		options  RenderOptions
+		expected string
+	}{
+		{
+			RenderOptions{},
+			`.text 4194560
+main: ADDIU $8, $0, -16 # count
+ORI $9, $9, 48879
+SW $31, -8($29)
+SLL $10, $9, 4
+BNE $8, $0, -8
+LI $11, -2147483648
+JR $31`,
+		},
+		{
+			RenderOptions{
+				Registers:          ABIRegisters,
+				HexArguments:       AllHexArguments(),
+				HexDirectives:      true,
+				LowercaseMnemonics: true,
+				MnemonicWidth:      6,
+				CompactOperands:    true,
+				CommentColumn:      30,
+			},
+			`.text 0x400100
+main: addiu  $t0,$zero,-0x10 # count
+ori    $t1,$t1,0xbeef
+sw     $ra,-0x8($sp)
+sll    $t2,$t1,0x4
+bne    $t0,$zero,-0x8
+li     $t3,0x80000000
+jr     $ra`,
+		},
+		{
+			RenderOptions{
+				Registers:     RRegisters,
+				HexArguments:  map[ArgumentType]bool{UnsignedConstant16: true},
+				CommentColumn: 40,
+			},
+			`.text 4194560
+main: ADDIU $r8, $r0, -16              # count
+ORI $r9, $r9, 0xbeef
+SW $r31, -8($r29)
+SLL $r10, $r9, 4
+BNE $r8, $r0, -8
+LI $r11, -2147483648
+JR $r31`,
+		},
+	}
+	for i, test := range tests {
+		var rendered []string
+		for _, line := range lines {
+			rendered = append(rendered, line.Format(test.options))
+		}
+		actual := strings.Join(rendered, "\n")
+		if actual != test.expected {
+			t.Errorf("test %d: expected:\n%s\ngot:\n%s", i, test.expected, actual)
+			continue
+		}
+
+		// Every style must assemble into the same program.
+		reparsed, err := TokenizeSource(actual)
+		if err != nil {
+			t.Errorf("test %d: %s", i, err)
+			continue
+		}
+		for j := range reparsed {
+			reparsed[j].LineNumber = lines[j].LineNumber
+			if !reparsed[j].Equal(&lines[j]) {
+				t.Errorf("test %d: line %d changed: %s", i, j+1, reparsed[j].String())
+			}
+		}
+	}
+}
+
+func TestParseHexArguments(t *testing.T) {
+	hex, err := ParseHexArguments("unsigned16, offset,target")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(hex) != 3 || !hex[UnsignedConstant16] || !hex[MemoryAddress] ||
+		!hex[AbsoluteCodePointer] {
+		t.Error("unexpected result:", hex)
+	}
+	if hex, err := ParseHexArguments("all"); err != nil || len(hex) != 7 {
+		t.Error("unexpected result:", hex, err)
+	}
+	if _, err := ParseHexArguments("signed16,bogus"); err == nil {
+		t.Error("expected an error")
+	}
+}
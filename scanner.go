@@ -0,0 +1,162 @@
+package mips32
+
+import (
+	"strconv"
+	"strings"
+)
+
+// A TokenKind identifies the lexical class of a Token.
+type TokenKind int
+
+const (
+	// IdentifierToken is an instruction name, a symbol, or a local label reference like "1b".
+	IdentifierToken TokenKind = iota
+
+	// DirectiveToken is a directive name like ".text".
+	DirectiveToken
+
+	// RegisterToken is a register name like "$t0" or "$5".
+	RegisterToken
+
+	// NumberToken is an unsigned integer like "15" or "0x1f".
+	NumberToken
+
+	// StringToken is a double-quoted string like "\"lib.s\"".
+	StringToken
+
+	// CharToken is a character literal like "'A'" or "'\n'".
+	CharToken
+
+	// PunctuationToken is a single character of punctuation, such as a comma, a parenthesis, a
+	// colon, or an operator.
+	PunctuationToken
+
+	// CommentToken is a comment, including its leading "#", "//", or ";".
+	CommentToken
+)
+
+// A Span identifies a range of characters on a source line.
+// Columns are 1-based byte offsets, and EndColumn is the column just past the last character.
+type Span struct {
+	Column    int
+	EndColumn int
+}
+
+// A Token is a lexical token along with its position on its line.
+type Token struct {
+	Kind TokenKind
+	Text string
+	Span Span
+}
+
+// A SyntaxError is a tokenization error on a specific part of a line.
+type SyntaxError struct {
+	Column  int
+	Message string
+
+	// Hint is an optional suggestion for fixing the error.
+	Hint string
+}
+
+func (s *SyntaxError) Error() string {
+	return "column " + strconv.Itoa(s.Column) + ": " + s.Message
+}
+
+func syntaxError(column int, message string) *SyntaxError {
+	return &SyntaxError{Column: column, Message: message}
+}
+
+// scanLine splits a line of source code into tokens.
+func scanLine(text string) ([]Token, *SyntaxError) {
+	var tokens []Token
+	for i := 0; i < len(text); {
+		ch := text[i]
+		start := i
+		kind := PunctuationToken
+		switch {
+		case ch == ' ' || ch == '\t' || ch == '\r':
+			i++
+			continue
+		case ch == '#' || ch == ';' || strings.HasPrefix(text[i:], "//"):
+			kind = CommentToken
+			i = len(text)
+		case ch == '"' || ch == '\'':
+			end, err := scanQuoted(text, i)
+			if err != nil {
+				return nil, err
+			}
+			kind = StringToken
+			if ch == '\'' {
+				kind = CharToken
+			}
+			i = end
+		case ch == '$':
+			kind = RegisterToken
+			i++
+			for i < len(text) && isIdentifierChar(text[i]) {
+				i++
+			}
+		case ch >= '0' && ch <= '9':
+			for i < len(text) && isIdentifierChar(text[i]) {
+				i++
+			}
+			kind = NumberToken
+			if localReferenceRegexp.MatchString(text[start:i]) {
+				kind = IdentifierToken
+			}
+		case isIdentifierChar(ch) || ch == '.':
+			for i < len(text) && (isIdentifierChar(text[i]) || text[i] == '.') {
+				i++
+			}
+			kind = IdentifierToken
+		case strings.IndexByte(",():+-*/%&|^~!<>=", ch) >= 0:
+			i++
+		default:
+			return nil, syntaxError(i+1, "unexpected character: "+strconv.QuoteRune(rune(ch)))
+		}
+		tokens = append(tokens, Token{
+			Kind: kind,
+			Text: text[start:i],
+			Span: Span{Column: start + 1, EndColumn: i + 1},
+		})
+	}
+	return tokens, nil
+}
+
+// scanQuoted finds the end of a quoted string or character literal starting at text[start].
+func scanQuoted(text string, start int) (int, *SyntaxError) {
+	quote := text[start]
+	for i := start + 1; i < len(text); i++ {
+		if text[i] == '\\' {
+			i++
+		} else if text[i] == quote {
+			return i + 1, nil
+		}
+	}
+	if quote == '"' {
+		return 0, syntaxError(start+1, "unterminated string")
+	}
+	return 0, syntaxError(start+1, "unterminated character literal")
+}
+
+// unquoteString decodes the contents of a StringToken.
+func unquoteString(tok *Token) (string, *SyntaxError) {
+	res, err := strconv.Unquote(tok.Text)
+	if err != nil {
+		return "", syntaxError(tok.Span.Column, "invalid string: "+tok.Text)
+	}
+	return res, nil
+}
+
+// unquoteChar decodes the value of a CharToken.
+func unquoteChar(tok *Token) (uint32, *SyntaxError) {
+	body := tok.Text[1 : len(tok.Text)-1]
+	if body == "\\0" {
+		return 0, nil
+	}
+	value, _, tail, err := strconv.UnquoteChar(body, '\'')
+	if err != nil || tail != "" || value > 0xff {
+		return 0, syntaxError(tok.Span.Column, "invalid character literal: "+tok.Text)
+	}
+	return uint32(value), nil
+}
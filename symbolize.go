@@ -0,0 +1,91 @@
+package mips32
+
+import (
+	"bufio"
+	"errors"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// SymbolizeTargets replaces the constant targets of branches and jumps with symbols, so that the
+// executable renders as source code which assembles back into the same instructions.
+//
+// A target with several symbols uses the first one in alphabetical order.
+// A target without any symbol is given a synthetic one named after its address, like
+// "L_00400120".
+func (e *Executable) SymbolizeTargets() {
+	names := map[uint32]string{}
+	for _, pair := range e.sortedSymbolAddrPairs() {
+		if _, ok := names[pair.Address]; !ok {
+			names[pair.Address] = pair.Symbol
+		}
+	}
+	for start, insts := range e.Segments {
+		for i := range insts {
+			inst := &insts[i]
+			if inst.CodePointer.IsSymbol || (!inst.IsBranch() && inst.Name != "J" &&
+				inst.Name != "JAL") {
+				continue
+			}
+			addr := start + uint32(i*4)
+			target := addr + 4 + inst.CodePointer.Constant
+			if inst.CodePointer.Absolute {
+				target = (addr+4)&jumpRegionMask | inst.CodePointer.Constant&^jumpRegionMask
+			}
+			name, ok := names[target]
+			if !ok {
+				name = e.syntheticLabel(target)
+				names[target] = name
+				e.Symbols[name] = target
+			}
+			inst.CodePointer.IsSymbol = true
+			inst.CodePointer.Symbol = name
+			inst.CodePointer.Constant = 0
+		}
+	}
+}
+
+// syntheticLabel picks an unused symbol name for an address.
+func (e *Executable) syntheticLabel(addr uint32) string {
+	name := "L_" + hexWord(addr)
+	for i := 1; ; i++ {
+		if _, ok := e.Symbols[name]; !ok {
+			return name
+		}
+		name = "L_" + hexWord(addr) + "_" + strconv.Itoa(i)
+	}
+}
+
+// ReadSymbolFile reads a symbol table in the format printed by nm: each line has a hexadecimal
+// address, an optional one-letter symbol type, and a name.
+// Undefined symbols (which have a type but no address) are skipped, as are blank lines and lines
+// starting with "#".
+func ReadSymbolFile(r io.Reader) (map[string]uint32, error) {
+	res := map[string]uint32{}
+	scanner := bufio.NewScanner(r)
+	for lineNum := 1; scanner.Scan(); lineNum++ {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) == 0 || strings.HasPrefix(fields[0], "#") {
+			continue
+		}
+		lineError := func(message string) error {
+			return errors.New("line " + strconv.Itoa(lineNum) + ": " + message)
+		}
+		if len(fields) == 2 && len(fields[0]) == 1 && !isHexDigit(fields[0][0]) {
+			continue
+		} else if len(fields) != 2 && len(fields) != 3 {
+			return nil, lineError("expected address, optional type, and name")
+		}
+		addr, err := strconv.ParseUint(strings.TrimPrefix(fields[0], "0x"), 16, 32)
+		if err != nil {
+			return nil, lineError("invalid address: " + fields[0])
+		}
+		res[fields[len(fields)-1]] = uint32(addr)
+	}
+	return res, scanner.Err()
+}
+
+func isHexDigit(ch byte) bool {
+	return (ch >= '0' && ch <= '9') || (ch >= 'a' && ch <= 'f') || (ch >= 'A' && ch <= 'F')
+}
@@ -0,0 +1,110 @@
+package mips32
+
+import (
+	"bytes"
+	"encoding/binary"
+	"strings"
+	"testing"
+)
+
+func TestSymbolizeTargets(t *testing.T) {
+	lines, err := TokenizeSource(`.text 0x10400100
+main: ORI $t0, $0, 3
+loop: ADDIU $t0, $t0, -1
+	BNE $t0, $0, loop
+	NOP
+	JAL sub
+	NOP
+	BEQ $0, $0, 12
+	NOP
+sub: JR $ra
+	NOP
+	J 0x10000000
+	NOP`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	exc, err := ParseExecutable(lines)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var raw bytes.Buffer
+	if err := WriteRawImage(&raw, exc, 0x10400100, false); err != nil {
+		t.Fatal(err)
+	}
+
+	decoded := LoadRawImage(raw.Bytes(), 0x10400100, false).Executable
+	decoded.Symbols["main"] = 0x10400100
+	decoded.SymbolizeTargets()
+	expectedSymbols := map[string]uint32{
+		"main":       0x10400100,
+		"L_10400104": 0x10400104,
+		"L_10400120": 0x10400120,
+		"L_10400128": 0x10400128,
+		"L_10000000": 0x10000000,
+	}
+	for name, addr := range expectedSymbols {
+		if decoded.Symbols[name] != addr {
+			t.Errorf("expected symbol %s at %08x but got %08x", name, addr, decoded.Symbols[name])
+		}
+	}
+	if len(decoded.Symbols) != len(expectedSymbols) {
+		t.Error("unexpected symbols:", decoded.Symbols)
+	}
+
+	rendered, err := decoded.Render()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var source []string
+	for _, line := range rendered {
+		source = append(source, line.String())
+	}
+	lines, err = TokenizeSource(strings.Join(source, "\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	reassembled, err := ParseExecutable(lines)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var raw2 bytes.Buffer
+	// The label for the J target lies outside the code, so the flat binary starts there.
+	if err := WriteRawImage(&raw2, reassembled, 0x10000000, false); err != nil {
+		t.Fatal(err)
+	}
+	actual := raw2.Bytes()
+	if len(actual) < raw.Len() || !bytes.Equal(actual[len(actual)-raw.Len():], raw.Bytes()) {
+		t.Errorf("reassembled program differs:\n%s", strings.Join(source, "\n"))
+	}
+	if reassembled.Symbols["L_10400120"] != 0x10400120 ||
+		binary.BigEndian.Uint32(actual[len(actual)-raw.Len():]) != 0x34080003 {
+		t.Error("unexpected reassembled program")
+	}
+}
+
+func TestReadSymbolFile(t *testing.T) {
+	symbols, err := ReadSymbolFile(strings.NewReader(`# nm output
+80000000 T main
+0x80000010 loop
+         U printf
+
+8000ff00 b buffer`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := map[string]uint32{"main": 0x80000000, "loop": 0x80000010, "buffer": 0x8000ff00}
+	if len(symbols) != len(expected) {
+		t.Fatal("unexpected symbols:", symbols)
+	}
+	for name, addr := range expected {
+		if symbols[name] != addr {
+			t.Error("unexpected address for", name, "-", symbols[name])
+		}
+	}
+	for _, failure := range []string{"xyz main", "80000000 T main extra", "main"} {
+		if _, err := ReadSymbolFile(strings.NewReader(failure)); err == nil {
+			t.Error("expected error for:", failure)
+		}
+	}
+}
@@ -0,0 +1,168 @@
+package mips32
+
+import "strconv"
+
+const (
+	// maxBranchDistance is the furthest a branch can reach, in bytes, from its delay slot.
+	maxBranchDistance = 0x20000
+
+	// jumpRegionMask selects the address bits which a J or JAL cannot change.
+	jumpRegionMask = 0xf0000000
+)
+
+// checkTargets makes sure that every branch and jump in the executable refers to a defined
+// symbol and to an address it can actually reach.
+//
+// Problems are added to p.diagnostics, so that they are all reported before the program is
+// encoded or run.
+func (p *executableParser) checkTargets() {
+	for _, segment := range p.res.sortedSegmentAddresses() {
+		for i, inst := range p.res.Segments[segment] {
+			addr := segment + uint32(i*4)
+			line := p.instructionLines[addr]
+			if line == nil || line.Instruction == nil {
+				// Raw words are emitted exactly as written.
+				continue
+			}
+			message, hint, unreachable := checkTarget(&inst, addr, p.res.Symbols)
+			if message == "" {
+				continue
+			} else if unreachable && p.options.RelaxBranches {
+				p.relax.add(line)
+			} else {
+				p.diagnostics.addError(line, lastArgumentColumn(line.Instruction), message, hint)
+			}
+		}
+	}
+}
+
+// checkTarget checks the code pointer of a branch or jump at the given address.
+// It returns an empty message if the target is valid.
+// If the only problem is that the target is too far away, unreachable is set.
+func checkTarget(inst *Instruction, addr uint32, symbols map[string]uint32) (message,
+	hint string, unreachable bool) {
+	isJump := inst.Name == "J" || inst.Name == "JAL"
+	if !inst.IsBranch() && !isJump {
+		return
+	}
+
+	ptr := inst.CodePointer
+	target := ptr.Constant
+	if ptr.IsSymbol {
+		var ok bool
+		target, ok = symbols[ptr.Symbol]
+		if !ok {
+			message, hint = undefinedSymbol(ptr.Symbol, symbols)
+			return
+		}
+	} else if inst.IsBranch() {
+		// Constant branch targets are offsets from the delay slot.
+		target += addr + 4
+	}
+
+	if target&3 != 0 {
+		return "misaligned " + inst.Name + " target: " + hexAddress(target), "", false
+	}
+	if inst.IsBranch() {
+		distance := int64(int32(target - (addr + 4)))
+		if distance < -maxBranchDistance || distance >= maxBranchDistance {
+			return "branch target out of range: " + targetDescription(ptr, target) + " is " +
+					strconv.FormatInt(distance, 10) + " bytes from the delay slot",
+				"branches can only reach 128KB in either direction; use J or JR instead", true
+		}
+	} else if target&jumpRegionMask != (addr+4)&jumpRegionMask {
+		return "jump target outside the current 256MB region: " +
+				targetDescription(ptr, target),
+			inst.Name + " can only reach addresses whose top four bits match those of its " +
+				"delay slot; load the address into a register and use JR instead", true
+	}
+	return
+}
+
+// codeTarget finds the absolute address which a branch or jump at the given address goes to.
+// It fails if the target is an undefined symbol.
+func codeTarget(inst *Instruction, addr uint32, symbols map[string]uint32) (uint32, bool) {
+	ptr := inst.CodePointer
+	if ptr.IsSymbol {
+		target, ok := symbols[ptr.Symbol]
+		return target, ok
+	} else if ptr.Absolute {
+		return (addr+4)&jumpRegionMask | ptr.Constant&^jumpRegionMask, true
+	}
+	return addr + 4 + ptr.Constant, true
+}
+
+// resolveReferences fills in the values of "%hi(symbol)" and "%lo(symbol)" operands and of
+// ".word symbol" directives, now that the address of every symbol is known.
+func (p *executableParser) resolveReferences() {
+	for _, segment := range p.res.sortedSegmentAddresses() {
+		insts := p.res.Segments[segment]
+		for i := range insts {
+			inst := &insts[i]
+			if inst.AddressHalf == nil {
+				continue
+			}
+			addr, ok := p.res.Symbols[inst.AddressHalf.Symbol]
+			if !ok {
+				line := p.instructionLines[segment+uint32(i*4)]
+				message, hint := undefinedSymbol(inst.AddressHalf.Symbol, p.res.Symbols)
+				p.diagnostics.addError(line, addressHalfColumn(line.Instruction), message, hint)
+				continue
+			}
+			inst.setAddressHalfValue(inst.AddressHalf.Value(addr))
+		}
+	}
+	for _, word := range p.wordSymbols {
+		addr, ok := p.res.Symbols[word.symbol]
+		if !ok {
+			message, hint := undefinedSymbol(word.symbol, p.res.Symbols)
+			p.diagnostics.addError(word.line, word.line.Directive.Span.Column, message, hint)
+		} else if inst := p.res.Get(word.addr); inst != nil {
+			*inst = *decodeInstructionAt(addr, word.addr)
+		}
+	}
+}
+
+// addressHalfColumn finds the column of the "%hi" or "%lo" operand of an instruction.
+func addressHalfColumn(inst *TokenizedInstruction) int {
+	for i, arg := range inst.Arguments {
+		if _, ok := arg.AddressHalf(); ok && i < len(inst.ArgumentSpans) {
+			return inst.ArgumentSpans[i].Column
+		}
+	}
+	return inst.NameSpan.Column
+}
+
+func undefinedSymbol(symbol string, symbols map[string]uint32) (message, hint string) {
+	message = "undefined symbol: " + symbol
+	if name := closestSymbolName(symbol, symbols); name != "" {
+		hint = "did you mean " + name + "?"
+	}
+	return
+}
+
+func targetDescription(ptr CodePointer, target uint32) string {
+	if ptr.IsSymbol {
+		return ptr.Symbol + " (" + hexAddress(target) + ")"
+	}
+	return hexAddress(target)
+}
+
+func hexAddress(addr uint32) string {
+	return "0x" + strconv.FormatUint(uint64(addr), 16)
+}
+
+// closestSymbolName finds the symbol with the smallest edit distance to an undefined name.
+// It returns "" if no symbol is reasonably close.
+func closestSymbolName(name string, symbols map[string]uint32) string {
+	var best string
+	bestDistance := 3
+	for symbol := range symbols {
+		d := editDistance(name, symbol)
+		if d < bestDistance || (d == bestDistance && best != "" && symbol < best) {
+			best = symbol
+			bestDistance = d
+		}
+	}
+	return best
+}
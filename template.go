@@ -10,8 +10,55 @@ const (
 	AbsoluteCodePointer
 	RelativeCodePointer
 	MemoryAddress
+	Constant32
 )
 
+// String returns a short description of the argument type, as used in usage hints.
+func (a ArgumentType) String() string {
+	switch a {
+	case Register:
+		return "register"
+	case SignedConstant16:
+		return "signed16"
+	case UnsignedConstant16:
+		return "unsigned16"
+	case Constant5:
+		return "shift5"
+	case AbsoluteCodePointer:
+		return "target"
+	case RelativeCodePointer:
+		return "label"
+	case MemoryAddress:
+		return "offset(register)"
+	case Constant32:
+		return "constant32"
+	}
+	return "unknown"
+}
+
+func (a ArgumentType) matches(tokArg *ArgToken) bool {
+	var ok bool
+	switch a {
+	case Register:
+		_, ok = tokArg.Register()
+	case SignedConstant16:
+		_, ok = tokArg.SignedConstant16()
+	case UnsignedConstant16:
+		_, ok = tokArg.UnsignedConstant16()
+	case Constant5:
+		_, ok = tokArg.Constant5()
+	case AbsoluteCodePointer:
+		_, ok = tokArg.AbsoluteCodePointer()
+	case RelativeCodePointer:
+		_, ok = tokArg.RelativeCodePointer()
+	case MemoryAddress:
+		_, ok = tokArg.MemoryReference()
+	case Constant32:
+		_, ok = tokArg.Constant32()
+	}
+	return ok
+}
+
 // A Template describes the kinds of arguments an instruction can take.
 type Template struct {
 	Name      string
@@ -19,43 +66,35 @@ type Template struct {
 }
 
 func (t *Template) Match(tok *TokenizedInstruction) bool {
-	if t.Name != tok.Name || len(t.Arguments) != len(tok.Arguments) {
-		return false
+	return t.Name == tok.Name && t.mismatchedArgument(tok) < 0
+}
+
+// String describes the template's usage, as in "ADDIU register, register, signed16".
+func (t *Template) String() string {
+	res := t.Name
+	for i, arg := range t.Arguments {
+		if i == 0 {
+			res += " "
+		} else {
+			res += ", "
+		}
+		res += arg.String()
+	}
+	return res
+}
+
+// mismatchedArgument finds the index of the first argument which does not fit the template.
+// It returns len(t.Arguments) if the number of arguments is wrong, or -1 if every argument fits.
+func (t *Template) mismatchedArgument(tok *TokenizedInstruction) int {
+	if len(t.Arguments) != len(tok.Arguments) {
+		return len(t.Arguments)
 	}
 	for i, arg := range t.Arguments {
-		tokArg := tok.Arguments[i]
-		switch arg {
-		case Register:
-			if _, ok := tokArg.Register(); !ok {
-				return false
-			}
-		case SignedConstant16:
-			if _, ok := tokArg.SignedConstant16(); !ok {
-				return false
-			}
-		case UnsignedConstant16:
-			if _, ok := tokArg.UnsignedConstant16(); !ok {
-				return false
-			}
-		case Constant5:
-			if _, ok := tokArg.Constant5(); !ok {
-				return false
-			}
-		case AbsoluteCodePointer:
-			if _, ok := tokArg.AbsoluteCodePointer(); !ok {
-				return false
-			}
-		case RelativeCodePointer:
-			if _, ok := tokArg.RelativeCodePointer(); !ok {
-				return false
-			}
-		case MemoryAddress:
-			if _, ok := tokArg.MemoryReference(); !ok {
-				return false
-			}
+		if !arg.matches(tok.Arguments[i]) {
+			return i
 		}
 	}
-	return true
+	return -1
 }
 
 func (t *Template) RegisterCount() int {
@@ -110,3 +149,19 @@ var Templates = []Template{
 	{"XOR", []ArgumentType{Register, Register, Register}},
 	{"XORI", []ArgumentType{Register, Register, UnsignedConstant16}},
 }
+
+// PseudoTemplates describes the pseudo-instructions which FoldIdioms uses to render common
+// instruction sequences. Each one stands for one or more real instructions; the assembler does
+// not accept them.
+var PseudoTemplates = []Template{
+	{"B", []ArgumentType{RelativeCodePointer}},
+	{"BEQZ", []ArgumentType{Register, RelativeCodePointer}},
+	{"BNEZ", []ArgumentType{Register, RelativeCodePointer}},
+	{"LI", []ArgumentType{Register, Constant32}},
+	{"MOVE", []ArgumentType{Register, Register}},
+	{"NEGU", []ArgumentType{Register, Register}},
+	{"NOT", []ArgumentType{Register, Register}},
+}
+
+// allTemplates lists the templates of every instruction and pseudo-instruction, for rendering.
+var allTemplates = append(Templates[:len(Templates):len(Templates)], PseudoTemplates...)
@@ -0,0 +1,151 @@
+package mips32
+
+import "sort"
+
+// A CodeTrace records which parts of an executable can be reached as code.
+type CodeTrace struct {
+	// Code contains the address of every reachable instruction, including delay slots.
+	Code map[uint32]bool
+
+	// UnresolvedJumps lists the addresses of JR and JALR instructions whose targets could not
+	// be determined, in ascending order.
+	// Returns (JR $ra) are not included.
+	UnresolvedJumps []uint32
+}
+
+// TraceCode finds the code in an executable by following its control flow from a list of entry
+// points, rather than assuming that every word is an instruction.
+//
+// Branches are followed to both of their destinations, jumps to their targets, and calls to
+// their targets and to the instruction after their delay slot.
+// The target of a JR or JALR is only known if its register was loaded with a constant (using
+// LUI, ORI and ADDIU) earlier in the same straight-line run of code.
+// Tracing stops at words which are not valid instructions and at addresses outside the
+// executable's segments.
+func (e *Executable) TraceCode(entries []uint32) *CodeTrace {
+	res := &CodeTrace{Code: map[uint32]bool{}}
+	pending := append([]uint32{}, entries...)
+	unresolved := map[uint32]bool{}
+
+	for len(pending) > 0 {
+		addr := pending[len(pending)-1]
+		pending = pending[:len(pending)-1]
+		constants := map[int]uint32{0: 0}
+
+		for e.isCode(addr) && !res.Code[addr] {
+			res.Code[addr] = true
+			inst := e.Get(addr)
+			if !inst.HasDelaySlot() {
+				trackConstant(inst, constants)
+				addr += 4
+				continue
+			}
+			if e.isCode(addr + 4) {
+				res.Code[addr+4] = true
+			}
+
+			var target uint32
+			var known bool
+			if inst.Name == "JR" || inst.Name == "JALR" {
+				reg := inst.Registers[len(inst.Registers)-1]
+				target, known = constants[reg]
+				if !known && !(inst.Name == "JR" && reg == 31) {
+					unresolved[addr] = true
+				}
+			} else {
+				target, known = codeTarget(inst, addr, e.Symbols)
+			}
+			if known {
+				pending = append(pending, target)
+			}
+
+			if inst.Name == "J" || inst.Name == "JR" || alwaysBranches(inst) {
+				break
+			}
+			// Calls return after their delay slot, and branches may fall through to it.
+			addr += 8
+			constants = map[int]uint32{0: 0}
+		}
+	}
+
+	for addr := range unresolved {
+		res.UnresolvedJumps = append(res.UnresolvedJumps, addr)
+	}
+	sort.Sort(uint32List(res.UnresolvedJumps))
+	return res
+}
+
+// TraceFromSymbols finds the code in the executable, starting from its entry point and from
+// every symbol inside one of its segments.
+func (e *Executable) TraceFromSymbols() *CodeTrace {
+	entries := []uint32{e.Entry}
+	for _, addr := range e.Symbols {
+		if e.Get(addr) != nil {
+			entries = append(entries, addr)
+		}
+	}
+	return e.TraceCode(entries)
+}
+
+// MarkData turns every instruction which a CodeTrace did not reach into a raw ".word", so that
+// data is not rendered as instructions.
+func (e *Executable) MarkData(t *CodeTrace) error {
+	for start, insts := range e.Segments {
+		for i := range insts {
+			addr := start + uint32(i*4)
+			if t.Code[addr] || insts[i].Name == ".word" {
+				continue
+			}
+			word, err := insts[i].Encode(addr, e.Symbols)
+			if err != nil {
+				return err
+			}
+			insts[i] = Instruction{Name: ".word", RawWord: word}
+		}
+	}
+	return nil
+}
+
+// alwaysBranches returns true if a branch is taken no matter what its registers hold, like
+// "BEQ $0, $0, target".
+func alwaysBranches(inst *Instruction) bool {
+	switch inst.Name {
+	case "BEQ":
+		return inst.Registers[0] == inst.Registers[1]
+	case "BGEZ", "BLEZ":
+		return inst.Registers[0] == 0
+	}
+	return false
+}
+
+// trackConstant updates the known constant values of registers after an instruction.
+func trackConstant(inst *Instruction, constants map[int]uint32) {
+	var value uint32
+	known := false
+	switch inst.Name {
+	case "LUI":
+		value, known = uint32(inst.UnsignedConstant16)<<16, true
+	case "ORI":
+		value, known = constants[inst.Registers[1]]
+		value |= uint32(inst.UnsignedConstant16)
+	case "ADDIU":
+		value, known = constants[inst.Registers[1]]
+		value += uint32(int32(inst.SignedConstant16))
+	case "ADDU", "OR":
+		a, knownA := constants[inst.Registers[1]]
+		b, knownB := constants[inst.Registers[2]]
+		if inst.Name == "ADDU" {
+			value = a + b
+		} else {
+			value = a | b
+		}
+		known = knownA && knownB
+	}
+	for _, reg := range inst.WrittenRegisters() {
+		delete(constants, reg)
+	}
+	if known {
+		constants[inst.Registers[0]] = value
+	}
+	constants[0] = 0
+}
@@ -0,0 +1,86 @@
+package mips32
+
+import (
+	"bytes"
+	"reflect"
+	"testing"
+)
+
+func TestTraceCode(t *testing.T) {
+	lines, err := TokenizeSource(`.text 0x10400100
+main: LUI $t0, %hi(handler)
+	ADDIU $t0, $t0, %lo(handler)
+	JALR $t0
+	NOP
+	JAL dispatch
+	ADDIU $a0, $0, 1
+	BEQ $0, $0, main
+	NOP
+message: .word 0x68656c6c
+	.word 0x6f000000
+dispatch: SLL $t0, $a0, 2
+	LUI $t1, %hi(table)
+	ADDU $t1, $t1, $t0
+	LW $t1, %lo(table)($t1)
+	JR $t1
+	NOP
+table: .word handler
+	.word other
+handler: JR $ra
+	NOP
+other: J handler
+	NOP`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	exc, err := ParseExecutable(lines)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var raw bytes.Buffer
+	if err := WriteRawImage(&raw, exc, 0x10400100, false); err != nil {
+		t.Fatal(err)
+	}
+	decoded := LoadRawImage(raw.Bytes(), 0x10400100, false).Executable
+
+	trace := decoded.TraceCode([]uint32{0x10400100})
+	expectedCode := map[uint32]bool{}
+	for addr := uint32(0x10400100); addr < 0x10400120; addr += 4 {
+		expectedCode[addr] = true
+	}
+	for addr := uint32(0x10400128); addr < 0x10400140; addr += 4 {
+		expectedCode[addr] = true
+	}
+	expectedCode[0x10400148] = true
+	expectedCode[0x1040014c] = true
+	if !reflect.DeepEqual(trace.Code, expectedCode) {
+		t.Errorf("unexpected code addresses: %v", trace.Code)
+	}
+	if !reflect.DeepEqual(trace.UnresolvedJumps, []uint32{0x10400138}) {
+		t.Errorf("unexpected unresolved jumps: %v", trace.UnresolvedJumps)
+	}
+
+	// The handler reached through the jump table becomes code once it has a symbol.
+	decoded.Symbols["other"] = 0x10400150
+	trace = decoded.TraceFromSymbols()
+	expectedCode[0x10400150] = true
+	expectedCode[0x10400154] = true
+	if !reflect.DeepEqual(trace.Code, expectedCode) {
+		t.Errorf("unexpected code addresses: %v", trace.Code)
+	}
+
+	if err := decoded.MarkData(trace); err != nil {
+		t.Fatal(err)
+	}
+	for _, addr := range []uint32{0x10400120, 0x10400124, 0x10400140, 0x10400144} {
+		if inst := decoded.Get(addr); inst.Name != ".word" {
+			t.Errorf("expected data at %08x but got %s", addr, inst.Name)
+		}
+	}
+	if inst := decoded.Get(0x10400140); inst.RawWord != 0x10400148 {
+		t.Errorf("unexpected jump table entry: %08x", inst.RawWord)
+	}
+	if inst := decoded.Get(0x10400100); inst.Name != "LUI" {
+		t.Errorf("expected code at 10400100 but got %s", inst.Name)
+	}
+}
@@ -0,0 +1,191 @@
+package mips32
+
+import (
+	"errors"
+	"math/rand"
+	"sort"
+	"strconv"
+)
+
+const (
+	// verifyReturnAddress is the value of $ra when a trial starts, so that the program finishes
+	// when the function at its entry point returns.
+	verifyReturnAddress = 0xfffffff0
+
+	// maxVerifySteps limits how many instructions a trial runs.
+	maxVerifySteps = 1000000
+)
+
+// VerifyOptimization checks that an optimized executable, such as one changed by Optimize, does
+// the same thing as the original in a number of random trials.
+//
+// Each trial runs both programs in the emulator from the entry point, with the same random
+// values in the registers and memory. $ra holds an address past the end of the code, so that
+// returning from the entry point finishes the program.
+// If the original program finishes, the optimized program must finish too, with the same values
+// in memory and in the registers other than $zero. If the optimizer was allowed to assume the
+// calling convention and a function was called, the registers which functions need not
+// preserve are not compared, except for $v0 and $v1.
+// Return addresses are considered equal to each other, since they change when code moves.
+// If the original program fails, the optimized program must fail too.
+// Trials in which the original program runs for too long are skipped.
+//
+// The first trial which fails is reported as an error.
+func VerifyOptimization(original, optimized *Executable, options OptimizeOptions, trials int,
+	seed int64) error {
+	gen := rand.New(rand.NewSource(seed))
+	for i := 0; i < trials; i++ {
+		var registers RegisterFile
+		for reg := 1; reg < 32; reg++ {
+			registers[reg] = gen.Uint32()
+		}
+		registers[28] &^= 3
+		registers[29] &^= 7
+		registers[31] = verifyReturnAddress
+		memorySeed := gen.Uint64()
+
+		expected := runTrial(original, registers, memorySeed)
+		if expected.timedOut {
+			continue
+		}
+		actual := runTrial(optimized, registers, memorySeed)
+		err := compareTrials(expected, actual, options.AssumeCallingConvention)
+		if err != nil {
+			return errors.New("trial " + strconv.Itoa(i+1) + ": " + err.Error())
+		}
+	}
+	return nil
+}
+
+type trialResult struct {
+	exc       *Executable
+	registers RegisterFile
+	memory    *randomMemory
+	err       error
+	timedOut  bool
+
+	// called is set if a JAL or JALR was run.
+	called bool
+}
+
+func runTrial(e *Executable, registers RegisterFile, memorySeed uint64) *trialResult {
+	memory := &randomMemory{seed: memorySeed, written: map[uint32]byte{}}
+	emulator := &Emulator{
+		RegisterFile:      registers,
+		Memory:            memory,
+		Executable:        e,
+		ProgramCounter:    e.Entry,
+		ForceMemAlignment: true,
+	}
+	res := &trialResult{exc: e, memory: memory, timedOut: true}
+	for step := 0; step < maxVerifySteps; step++ {
+		if emulator.Done() {
+			res.timedOut = false
+			break
+		}
+		if inst := e.Get(emulator.ProgramCounter); inst != nil && !emulator.JumpNext &&
+			(inst.Name == "JAL" || inst.Name == "JALR") {
+			res.called = true
+		}
+		if err := emulator.Step(); err != nil {
+			res.err = err
+			res.timedOut = false
+			break
+		}
+	}
+	res.registers = emulator.RegisterFile
+	return res
+}
+
+func compareTrials(expected, actual *trialResult, callingConvention bool) error {
+	if expected.err != nil {
+		if actual.err == nil && !actual.timedOut {
+			return errors.New("original failed (" + expected.err.Error() +
+				") but optimized program finished")
+		}
+		return nil
+	} else if actual.err != nil {
+		return errors.New("optimized program failed: " + actual.err.Error())
+	} else if actual.timedOut {
+		return errors.New("optimized program did not finish")
+	}
+
+	skipped := RegisterSet(1)
+	if callingConvention && (expected.called || actual.called) {
+		skipped |= callClobberedRegisters &^ (0x3 << 2)
+	}
+	for reg := 0; reg < 32; reg++ {
+		if skipped.Contains(reg) {
+			continue
+		}
+		x, y := expected.registers[reg], actual.registers[reg]
+		if !equivalentValues(expected.exc, actual.exc, x, y) {
+			return errors.New("$" + abiRegisterNames[reg] + " is " + hexAddress(y) +
+				" but should be " + hexAddress(x))
+		}
+	}
+
+	words := map[uint32]bool{}
+	for addr := range expected.memory.written {
+		words[addr&^3] = true
+	}
+	for addr := range actual.memory.written {
+		words[addr&^3] = true
+	}
+	for _, addr := range sortedAddresses(words) {
+		x, y := expected.memory.word(addr), actual.memory.word(addr)
+		if !equivalentValues(expected.exc, actual.exc, x, y) {
+			return errors.New("memory at " + hexAddress(addr) + " is " + hexAddress(y) +
+				" but should be " + hexAddress(x))
+		}
+	}
+	return nil
+}
+
+// equivalentValues checks if a value left by the original program matches a value left by the
+// optimized program: either they are equal, or they are both return addresses.
+func equivalentValues(original, optimized *Executable, x, y uint32) bool {
+	return x == y || (isReturnAddress(original, x) && isReturnAddress(optimized, y))
+}
+
+func isReturnAddress(e *Executable, addr uint32) bool {
+	inst := e.Get(addr - 8)
+	return addr%4 == 0 && inst != nil && (inst.Name == "JAL" || inst.Name == "JALR")
+}
+
+func sortedAddresses(set map[uint32]bool) uint32List {
+	var res uint32List
+	for addr := range set {
+		res = append(res, addr)
+	}
+	sort.Sort(res)
+	return res
+}
+
+// randomMemory is a Memory whose initial contents are random, as given by a seed.
+// It remembers which bytes are written.
+type randomMemory struct {
+	seed    uint64
+	written map[uint32]byte
+}
+
+func (r *randomMemory) Get(ptr uint32) byte {
+	if b, ok := r.written[ptr]; ok {
+		return b
+	}
+	// Mix the seed and address as in the SplitMix64 generator.
+	x := r.seed + uint64(ptr)*0x9e3779b97f4a7c15
+	x = (x ^ x>>30) * 0xbf58476d1ce4e5b9
+	x = (x ^ x>>27) * 0x94d049bb133111eb
+	return byte(x ^ x>>31)
+}
+
+func (r *randomMemory) Set(ptr uint32, b byte) {
+	r.written[ptr] = b
+}
+
+// word reads a big-endian word, the byte order which the emulator uses by default.
+func (r *randomMemory) word(addr uint32) uint32 {
+	return uint32(r.Get(addr))<<24 | uint32(r.Get(addr+1))<<16 | uint32(r.Get(addr+2))<<8 |
+		uint32(r.Get(addr+3))
+}
@@ -1,6 +1,8 @@
 package main
 
 import (
+	"strings"
+
 	"github.com/gopherjs/gopherjs/js"
 	"github.com/unixpickle/mips32"
 )
@@ -8,20 +10,25 @@ import (
 const maxAssembleSize = 0x1000
 
 type Assembler struct {
-	textarea  *js.Object
-	errorView *js.Object
+	textarea   *js.Object
+	highlights *js.Object
+	errorView  *js.Object
 }
 
 func NewAssembler() *Assembler {
 	res := &Assembler{
-		textarea:  js.Global.Get("assembler-code"),
-		errorView: js.Global.Get("assembler-error"),
+		textarea:   js.Global.Get("assembler-code"),
+		highlights: js.Global.Get("assembler-highlights"),
+		errorView:  js.Global.Get("assembler-error"),
 	}
 	js.Global.Get("assembler-button").Call("addEventListener", "click", func() {
 		if res.Assemble() {
 			GlobalDebugger.Show()
 		}
 	})
+	res.textarea.Call("addEventListener", "scroll", func() {
+		res.highlights.Set("scrollTop", res.textarea.Get("scrollTop"))
+	})
 	return res
 }
 
@@ -42,12 +49,18 @@ func (a *Assembler) Assemble() bool {
 		a.showError(err)
 		return false
 	}
-	exc, err := mips32.ParseExecutable(lines)
+	var warnings mips32.DiagnosticList
+	exc, err := mips32.ParseExecutableOptions(lines, mips32.ParseOptions{
+		WarningHandler: func(d mips32.Diagnostic) {
+			warnings = append(warnings, d)
+		},
+	})
 	if err != nil {
 		a.showError(err)
 		return false
 	}
 	a.hideError()
+	a.highlightLines(warnings)
 	GlobalDebugger.SetExecutable(exc)
 
 	if exc.End() < maxAssembleSize {
@@ -66,9 +79,48 @@ func (a *Assembler) Assemble() bool {
 
 func (a *Assembler) hideError() {
 	a.errorView.Set("className", "error-view")
+	a.highlightLines(nil)
 }
 
 func (a *Assembler) showError(err error) {
 	a.errorView.Set("className", "error-view showing-error")
 	a.errorView.Set("textContent", err.Error())
+	if list, ok := err.(mips32.DiagnosticList); ok {
+		a.highlightLines(list)
+	} else {
+		a.highlightLines(nil)
+	}
+}
+
+// highlightLines marks each source line which has a diagnostic.
+// The highlights sit behind the transparent textarea, one block per source line.
+func (a *Assembler) highlightLines(diagnostics mips32.DiagnosticList) {
+	lineCount := strings.Count(a.textarea.Get("value").String(), "\n") + 1
+	classes := make([]string, lineCount)
+	titles := make([]string, lineCount)
+	for _, d := range diagnostics {
+		if d.Line < 1 || d.Line > lineCount {
+			continue
+		}
+		idx := d.Line - 1
+		if d.Severity == mips32.SeverityError {
+			classes[idx] = "line-error"
+		} else if classes[idx] == "" {
+			classes[idx] = "line-warning"
+		}
+		if titles[idx] != "" {
+			titles[idx] += "\n"
+		}
+		titles[idx] += d.Severity.String() + ": " + d.Message
+	}
+
+	document := js.Global.Get("document")
+	a.highlights.Set("innerHTML", "")
+	for i := range classes {
+		line := document.Call("createElement", "div")
+		line.Set("className", classes[i])
+		line.Set("title", titles[i])
+		a.highlights.Call("appendChild", line)
+	}
+	a.highlights.Set("scrollTop", a.textarea.Get("scrollTop"))
 }
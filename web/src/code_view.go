@@ -9,6 +9,10 @@ const PreviewLineCount = 7
 
 type CodeView struct {
 	element *js.Object
+
+	// dataFlow is the analysis of executable, which is used to show the live registers.
+	executable *mips32.Executable
+	dataFlow   *mips32.DataFlow
 }
 
 func NewCodeView() *CodeView {
@@ -22,10 +26,15 @@ func (c *CodeView) Update(e *mips32.Emulator) {
 	if (e.ProgramCounter / 4) > (PreviewLineCount / 2) {
 		startAddress = e.ProgramCounter - (PreviewLineCount/2)*4
 	}
-	c.element.Set("innerHTML", "<tr><td>Addr</td><td>Assembly</td><td>Code</td></tr>")
+	if c.executable != e.Executable {
+		c.executable = e.Executable
+		c.dataFlow = e.Executable.DataFlow()
+	}
+	c.element.Set("innerHTML",
+		"<tr><td>Addr</td><td>Assembly</td><td>Code</td><td>Live</td></tr>")
 	for i := 0; i < PreviewLineCount; i++ {
 		addr := startAddress + uint32(i*4)
-		row := createCodeViewLine(e, addr)
+		row := createCodeViewLine(e, c.dataFlow, addr)
 		if addr == e.ProgramCounter {
 			row.Set("className", row.Get("className").String()+" debugger-code-view-current")
 		}
@@ -33,9 +42,12 @@ func (c *CodeView) Update(e *mips32.Emulator) {
 	}
 }
 
-func createCodeViewLine(e *mips32.Emulator, addr uint32) *js.Object {
+func createCodeViewLine(e *mips32.Emulator, dataFlow *mips32.DataFlow, addr uint32) *js.Object {
 	document := js.Global.Get("document")
 	row := document.Call("createElement", "tr")
+	if pos, ok := e.Executable.LineTable[addr]; ok {
+		row.Set("title", pos.String())
+	}
 
 	addrColumn := document.Call("createElement", "td")
 	addrColumn.Set("textContent", format32BitHex(addr))
@@ -49,7 +61,7 @@ func createCodeViewLine(e *mips32.Emulator, addr uint32) *js.Object {
 		if err != nil {
 			codeColumn.Set("textContent", "(Unknown)")
 		} else {
-			codeColumn.Set("textContent", rendering.String())
+			codeColumn.Set("textContent", rendering.Format(GlobalRenderStyle.Options()))
 		}
 	} else {
 		codeColumn.Set("textContent", "NOP")
@@ -70,5 +82,13 @@ func createCodeViewLine(e *mips32.Emulator, addr uint32) *js.Object {
 	}
 	row.Call("appendChild", opcodeColumn)
 
+	// The registers which are live before the instruction runs.
+	liveColumn := document.Call("createElement", "td")
+	liveColumn.Set("className", "debugger-code-view-live")
+	if dataFlow.Analyzed(addr) {
+		liveColumn.Set("textContent", dataFlow.LiveIn(addr).String())
+	}
+	row.Call("appendChild", liveColumn)
+
 	return row
 }
@@ -54,6 +54,7 @@ func (d *Disassembler) disassemble() {
 
 	instructions := make([]*mips32.Instruction, len(data)/4)
 	instStrs := make([]string, len(instructions))
+	options := GlobalRenderStyle.Options()
 	for i := 0; i < len(data); i += 4 {
 		word := (uint32(data[i+0]) << 24) | (uint32(data[i+1]) << 16) | (uint32(data[i+2]) << 8) |
 			uint32(data[i+3])
@@ -63,7 +64,7 @@ func (d *Disassembler) disassemble() {
 			d.showError(err)
 			return
 		}
-		instStrs[i/4] = rendering.String()
+		instStrs[i/4] = rendering.Format(options)
 	}
 
 	d.hideError()
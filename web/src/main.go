@@ -5,6 +5,7 @@ import "github.com/gopherjs/gopherjs/js"
 var GlobalDebugger *Debugger
 var GlobalAssembler *Assembler
 var GlobalDisassembler *Disassembler
+var GlobalRenderStyle *RenderStyle
 
 var defaultProgram = `# Put your code here, then hit Assemble.
 # Large programs may take a moment or two to assemble.
@@ -16,6 +17,7 @@ ORI $r1, $r1, 0xBEEF`
 func main() {
 	js.Global.Get("window").Call("addEventListener", "load", func() {
 		go func() {
+			GlobalRenderStyle = NewRenderStyle()
 			GlobalDebugger = NewDebugger()
 			GlobalAssembler = NewAssembler()
 			GlobalDisassembler = NewDisassembler()
@@ -0,0 +1,44 @@
+package main
+
+import (
+	"github.com/gopherjs/gopherjs/js"
+	"github.com/unixpickle/mips32"
+)
+
+// RenderStyle reads the style controls, which choose how the disassembler and the debugger's
+// code view write instructions.
+type RenderStyle struct {
+	registers *js.Object
+	hex       *js.Object
+	lowercase *js.Object
+}
+
+func NewRenderStyle() *RenderStyle {
+	res := &RenderStyle{
+		registers: js.Global.Get("render-registers"),
+		hex:       js.Global.Get("render-hex"),
+		lowercase: js.Global.Get("render-lowercase"),
+	}
+	for _, control := range []*js.Object{res.registers, res.hex, res.lowercase} {
+		control.Call("addEventListener", "change", func() {
+			if GlobalDebugger != nil {
+				go GlobalDebugger.updateUI()
+			}
+		})
+	}
+	return res
+}
+
+// Options returns the rendering options for the current state of the controls.
+func (r *RenderStyle) Options() mips32.RenderOptions {
+	var res mips32.RenderOptions
+	if style, err := mips32.ParseRegisterStyle(r.registers.Get("value").String()); err == nil {
+		res.Registers = style
+	}
+	if r.hex.Get("checked").Bool() {
+		res.HexArguments = mips32.AllHexArguments()
+		res.HexDirectives = true
+	}
+	res.LowercaseMnemonics = r.lowercase.Get("checked").Bool()
+	return res
+}